@@ -1,13 +1,17 @@
 package cli
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -26,12 +30,18 @@ type CommandLine struct{}
 func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
+	fmt.Println(" createblockchain -address ADDRESS -blocktime SECONDS -consensus pow|poa -authorities ADDR,ADDR -issuers ADDR,ADDR -disposal ADDR -regulators ADDR,ADDR -quarantine ADDR creates a blockchain and sends genesis reward to address, issuers default to address, expired tokens may only be sent to the disposal address, regulators may freeze lots, which may then only be sent to the quarantine address")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag is set, mine off of this node")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -fee FEE -mine - Send amount of coins, leaving fee to the miner. Then -mine flag is set, mine off of this node")
 	fmt.Println(" createwallet - Creates a new Wallet")
 	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
 	fmt.Println(" reindexutxo - Rebuilds the UTXO set")
+	fmt.Println(" verifychain - Audits every block, signature and the UTXO set, reporting the first divergence")
+	fmt.Println(" exportchain -file PATH - Writes the chain and its UTXO set to a snapshot file")
+	fmt.Println(" importchain -file PATH -tip HASH - Loads a snapshot into an empty node, -tip refuses a snapshot ending elsewhere")
+	fmt.Println(" trace -tx ID -out N -forward - Prints the custody chain of an output back to its mint, -forward on to its current holders")
+	fmt.Println(" trace -lot ID - Prints the custody chain of every output a lot was minted in, on to its current holders")
+	fmt.Println(" migratechain - Rewrites a chain stored by an older version in the canonical encoding")
 	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
 }
 
@@ -55,16 +65,242 @@ func (cli *CommandLine) StartNode(nodeID, minerAddress string) {
 	network.StartServer(nodeID, minerAddress)
 }
 
+// openStore opens the chain database in the default data directory, read-only
+// for commands that only inspect the chain. It exits when a running node
+// holds the database.
+func openStore(readOnly bool) blockchain2.ChainStore {
+	open := blockchain2.OpenBadgerStore
+	if readOnly {
+		open = blockchain2.OpenBadgerStoreReadOnly
+	}
+
+	store, err := open(blockchain2.DefaultDataDir)
+	if errors.Is(err, blockchain2.ErrStoreLocked) {
+		log.Println("The chain database is in use by a running node, stop it or use the node's API instead!")
+		runtime.Goexit()
+	}
+	if errors.Is(err, blockchain2.ErrNoChain) {
+		log.Println("No existing blockchain found, create one!")
+		runtime.Goexit()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return store
+}
+
+// continueChain loads the chain from the default data directory and exits
+// when there is none yet.
+func continueChain(readOnly bool) *blockchain2.BlockChain {
+	store := openStore(readOnly)
+
+	chain, err := blockchain2.ContinueBlockChain(store)
+	if errors.Is(err, blockchain2.ErrNoChain) {
+		store.Close()
+		log.Println("No existing blockchain found, create one!")
+		runtime.Goexit()
+	}
+	if errors.Is(err, blockchain2.ErrReadOnlyTxn) {
+		store.Close()
+		log.Println("The chain database needs repairs, run reindexutxo first!")
+		runtime.Goexit()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return chain
+}
+
 func (cli *CommandLine) ReindexUTXO() {
-	chain := blockchain2.ContinueBlockChain()
+	chain := continueChain(false)
 	defer chain.Database.Close()
 	UTXOSet := blockchain2.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	if err := UTXOSet.Reindex(); err != nil {
+		log.Panic(err)
+	}
 
-	count := UTXOSet.CountTransactions()
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		log.Panic(err)
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
+// VerifyChain prints the audit report of the chain and exits with status 1
+// when a divergence was found.
+func (cli *CommandLine) VerifyChain() {
+	chain := continueChain(true)
+	defer chain.Database.Close()
+
+	report, err := chain.VerifyChain()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Tip: %x\n", report.Tip)
+	fmt.Printf("Height: %d\n", report.Height)
+	fmt.Printf("Blocks verified: %d\n", report.Blocks)
+	fmt.Printf("Transactions verified: %d\n", report.Transactions)
+	fmt.Printf("Legacy blocks (Merkle root not checked): %d\n", report.LegacyBlocks)
+
+	if report.Valid() {
+		fmt.Println("Result: valid")
+
+		return
+	}
+
+	d := report.Divergence
+	fmt.Println("Result: divergence")
+	fmt.Printf("Divergence height: %d\n", d.Height)
+	fmt.Printf("Divergence block: %x\n", d.BlockHash)
+	if len(d.TxID) > 0 {
+		fmt.Printf("Divergence tx: %x\n", d.TxID)
+	}
+	fmt.Printf("Reason: %v\n", d.Err)
+
+	chain.Database.Close()
+	os.Exit(1)
+}
+
+func (cli *CommandLine) ExportChain(path string) {
+	chain := continueChain(true)
+	defer chain.Database.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	if err := chain.ExportChain(file); err != nil {
+		log.Panic(err)
+	}
+
+	if err := file.Sync(); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Exported chain up to %x to %s\n", chain.LastHash, path)
+}
+
+func (cli *CommandLine) ImportChain(path, tip string) {
+	var expectedTip []byte
+
+	if tip != "" {
+		var err error
+
+		expectedTip, err = hex.DecodeString(tip)
+		if err != nil {
+			log.Panic("Tip must be a hex block hash")
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	store := openStore(false)
+	defer store.Close()
+
+	chain, err := blockchain2.ImportChain(store, file, expectedTip)
+	if errors.Is(err, blockchain2.ErrChainExists) {
+		log.Println("A blockchain already exists, import into an empty node!")
+		runtime.Goexit()
+	}
+	if err != nil {
+		log.Printf("Import failed, remove %s before retrying", blockchain2.DefaultDataDir)
+		log.Panic(err)
+	}
+
+	fmt.Printf("Imported chain up to %x\n", chain.LastHash)
+}
+
+// Trace prints the custody chain of output out of transaction txID, back to
+// the mint or coinbase it came from or, when forward is set, on to the
+// outputs holding its tokens now.
+func (cli *CommandLine) Trace(txID string, out int, forward bool) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic("Transaction ID must be hex encoded")
+	}
+
+	chain := continueChain(true)
+	defer chain.Database.Close()
+
+	var steps []blockchain2.TraceStep
+	if forward {
+		steps, err = chain.TraceForward(id, out)
+	} else {
+		steps, err = chain.TraceBack(id, out)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	printTrace(steps)
+}
+
+// TraceLot prints every output lot, a hex asset ID, was minted in and every
+// output its tokens moved to since, on to its current holders.
+func (cli *CommandLine) TraceLot(lot string) {
+	id, err := hex.DecodeString(lot)
+	if err != nil || len(id) == 0 {
+		log.Panic("Asset ID must be hex encoded")
+	}
+
+	chain := continueChain(true)
+	defer chain.Database.Close()
+
+	steps, err := chain.TraceLot(id)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	printTrace(steps)
+}
+
+func printTrace(steps []blockchain2.TraceStep) {
+	for _, step := range steps {
+		holder := string(wallet2.HashToAddress(step.Output.PubKeyHash))
+		if len(step.Output.Script) > 0 {
+			holder = fmt.Sprintf("script %x", step.Output.Script)
+		}
+
+		fmt.Printf("Height %d at %s: %x:%d\n", step.Height, time.Unix(step.Timestamp, 0).UTC().Format(time.RFC3339), step.TxID, step.Out)
+		fmt.Printf("  Holder: %s\n", holder)
+		fmt.Printf("  Amount: %d\n", step.Output.Value)
+		if len(step.Output.Asset) > 0 {
+			fmt.Printf("  Asset: %x\n", step.Output.Asset)
+		}
+		if step.Origin {
+			fmt.Println("  Origin: minted")
+		}
+		if step.Unspent {
+			fmt.Println("  Held: yes")
+		}
+	}
+}
+
+func (cli *CommandLine) MigrateChain() {
+	store := openStore(false)
+	defer store.Close()
+
+	rewritten, err := blockchain2.MigrateEncoding(store)
+	if errors.Is(err, blockchain2.ErrNoChain) {
+		log.Println("No existing blockchain found, create one!")
+		runtime.Goexit()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Done! Rewrote %d records, every block links back to genesis.\n", rewritten)
+}
+
 func (cli *CommandLine) ListAddresses() {
 	wallets, _ := wallet2.CreateWallets()
 	addresses := wallets.GetAllAddresses()
@@ -83,17 +319,19 @@ func (cli *CommandLine) CreateWallet() {
 }
 
 func (cli *CommandLine) PrintChain() {
-	chain := blockchain2.ContinueBlockChain()
+	chain := continueChain(true)
 	defer chain.Database.Close()
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			log.Panic(err)
+		}
 
 		fmt.Printf("Hash: %x\n", block.Hash)
 		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
-		pow := blockchain2.NewProof(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("Seal: %s\n", strconv.FormatBool(chain.SealIsValid(block)))
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
 		}
@@ -105,15 +343,16 @@ func (cli *CommandLine) PrintChain() {
 	}
 }
 
-func (cli *CommandLine) CreateBlockChain(address string) {
+func (cli *CommandLine) CreateBlockChain(address string, params blockchain2.ChainParams) {
 	if !wallet2.ValidateAddress(address) {
 		log.Panic("Address is not Valid")
 	}
-	chain := blockchain2.InitBlockChain(address)
-	defer chain.Database.Close()
+	store := openStore(false)
+	defer store.Close()
 
-	UTXOSet := blockchain2.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	if _, err := blockchain2.InitBlockChain(store, address, params); err != nil {
+		log.Panic(err)
+	}
 
 	fmt.Println("Finished!")
 }
@@ -122,14 +361,17 @@ func (cli *CommandLine) GetBalance(address string) {
 	if !wallet2.ValidateAddress(address) {
 		log.Panic("Address is not Valid")
 	}
-	chain := blockchain2.ContinueBlockChain()
+	chain := continueChain(true)
 	UTXOSet := blockchain2.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
 	balance := 0
 	pubKeyHash := wallet2.Base58Decode([]byte(address))
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
+	UTXOs, err := UTXOSet.FindUnspentTransactions(pubKeyHash, nil)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	for _, out := range UTXOs {
 		balance += out.Value
@@ -138,14 +380,14 @@ func (cli *CommandLine) GetBalance(address string) {
 	fmt.Printf("Balance of %s: %d\n", address, balance)
 }
 
-func (cli *CommandLine) Send(from, to string, amount int, mineNow bool) {
+func (cli *CommandLine) Send(from, to string, amount, fee int, mineNow bool) {
 	if !wallet2.ValidateAddress(to) {
 		log.Panic("Address is not Valid")
 	}
 	if !wallet2.ValidateAddress(from) {
 		log.Panic("Address is not Valid")
 	}
-	chain := blockchain2.ContinueBlockChain()
+	chain := continueChain(!mineNow)
 	UTXOSet := blockchain2.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
@@ -156,13 +398,20 @@ func (cli *CommandLine) Send(from, to string, amount int, mineNow bool) {
 	wallet2.DeleteWalletLock()
 	wallet := wallets.GetWallet(from)
 
-	tx, _ := blockchain2.NewTransaction(wallet, to, amount, &UTXOSet, true)
+	tx, err := blockchain2.NewTransaction(wallet, []blockchain2.Payment{{To: to, Amount: amount}}, fee, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	if mineNow {
-		cbTx := blockchain2.CoinbaseTx(from, "")
-		txs := []*blockchain2.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
+		txs, err := chain.AssembleBlock([]*blockchain2.Transaction{tx}, from)
+		if err != nil {
+			log.Panic(err)
+		}
+		_, err = chain.MineBlock(context.Background(), txs)
+		if err != nil {
+			log.Panic(err)
+		}
 	} else {
 		fmt.Println(hex.EncodeToString(tx.ID))
 		network.SendTx(network.KnownNodes[0], tx)
@@ -182,15 +431,36 @@ func (cli *CommandLine) Run() {
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	migrateChainCmd := flag.NewFlagSet("migratechain", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	traceCmd := flag.NewFlagSet("trace", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
 	createBlockchainPassword := createBlockchainCmd.String("password", "", "password for the government account")
+	createBlockchainBlockTime := createBlockchainCmd.Int64("blocktime", blockchain2.DefaultChainParams.TargetBlockInterval, "Target number of seconds between blocks")
+	createBlockchainRetarget := createBlockchainCmd.Int("retarget", blockchain2.DefaultChainParams.RetargetInterval, "Number of blocks between difficulty adjustments")
+	createBlockchainConsensus := createBlockchainCmd.String("consensus", blockchain2.ConsensusPoW, "Consensus engine, pow or poa")
+	createBlockchainAuthorities := createBlockchainCmd.String("authorities", "", "Comma separated addresses allowed to sign blocks under poa")
+	createBlockchainIssuers := createBlockchainCmd.String("issuers", "", "Comma separated addresses allowed to mint tokens, defaults to -address")
+	createBlockchainDisposal := createBlockchainCmd.String("disposal", "", "The address expired tokens may only be sent to")
+	createBlockchainRegulators := createBlockchainCmd.String("regulators", "", "Comma separated addresses allowed to freeze and unfreeze lots")
+	createBlockchainQuarantine := createBlockchainCmd.String("quarantine", "", "The address frozen lots may only be sent to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee left to the miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	exportChainFile := exportChainCmd.String("file", "", "Snapshot file to write")
+	importChainFile := importChainCmd.String("file", "", "Snapshot file to load")
+	importChainTip := importChainCmd.String("tip", "", "Expected hash of the snapshot tip")
+	traceTx := traceCmd.String("tx", "", "Transaction ID holding the output")
+	traceOut := traceCmd.Int("out", 0, "Index of the output in the transaction")
+	traceForward := traceCmd.Bool("forward", false, "Trace on to the current holders instead of back to the mint")
+	traceLot := traceCmd.String("lot", "", "Asset ID of a lot to trace from all its mints on to its current holders")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
 
 	switch os.Args[1] {
@@ -199,6 +469,31 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importchain":
+		err := importChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "migratechain":
+		err := migrateChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "trace":
+		err := traceCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getbalance":
 		err := getBalanceCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -260,7 +555,24 @@ func (cli *CommandLine) Run() {
 
 		cli.createGovernmentAccount(*createBlockchainPassword, *createBlockchainAddress)
 
-		cli.CreateBlockChain(*createBlockchainAddress)
+		params := blockchain2.DefaultChainParams
+		params.TargetBlockInterval = *createBlockchainBlockTime
+		params.RetargetInterval = *createBlockchainRetarget
+		params.Consensus = *createBlockchainConsensus
+		if *createBlockchainAuthorities != "" {
+			params.Authorities = strings.Split(*createBlockchainAuthorities, ",")
+		}
+		params.Issuers = []string{*createBlockchainAddress}
+		if *createBlockchainIssuers != "" {
+			params.Issuers = strings.Split(*createBlockchainIssuers, ",")
+		}
+		params.DisposalAddress = *createBlockchainDisposal
+		if *createBlockchainRegulators != "" {
+			params.Regulators = strings.Split(*createBlockchainRegulators, ",")
+		}
+		params.QuarantineAddress = *createBlockchainQuarantine
+
+		cli.CreateBlockChain(*createBlockchainAddress, params)
 	}
 
 	if printChainCmd.Parsed() {
@@ -276,6 +588,37 @@ func (cli *CommandLine) Run() {
 	if reindexUTXOCmd.Parsed() {
 		cli.ReindexUTXO()
 	}
+	if verifyChainCmd.Parsed() {
+		cli.VerifyChain()
+	}
+	if exportChainCmd.Parsed() {
+		if *exportChainFile == "" {
+			exportChainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.ExportChain(*exportChainFile)
+	}
+	if importChainCmd.Parsed() {
+		if *importChainFile == "" {
+			importChainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.ImportChain(*importChainFile, *importChainTip)
+	}
+	if migrateChainCmd.Parsed() {
+		cli.MigrateChain()
+	}
+	if traceCmd.Parsed() {
+		if *traceLot == "" && (*traceTx == "" || *traceOut < 0) {
+			traceCmd.Usage()
+			runtime.Goexit()
+		}
+		if *traceLot != "" {
+			cli.TraceLot(*traceLot)
+		} else {
+			cli.Trace(*traceTx, *traceOut, *traceForward)
+		}
+	}
 
 	if sendCmd.Parsed() {
 		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
@@ -283,7 +626,7 @@ func (cli *CommandLine) Run() {
 			runtime.Goexit()
 		}
 
-		cli.Send(*sendFrom, *sendTo, *sendAmount, *sendMine)
+		cli.Send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendMine)
 	}
 
 	if startNodeCmd.Parsed() {
@@ -0,0 +1,295 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MaxAssetFieldLength bounds each field of an asset definition.
+const MaxAssetFieldLength = 64
+
+var (
+	ErrInvalidAsset   = errors.New("asset is invalid")
+	ErrUnknownAsset   = errors.New("asset is not registered by the issuer")
+	ErrDuplicateAsset = errors.New("asset is already registered")
+	ErrAssetImbalance = errors.New("transaction does not conserve an asset")
+
+	assetPrefix = []byte("asset-")
+)
+
+// Asset defines a lot of a product. A mint registers it, and its outputs
+// carry the asset ID instead of the native token. An asset belongs to the
+// issuer that registered it; only that issuer may mint more of it.
+type Asset struct {
+	Product      string
+	Manufacturer string
+	Lot          string
+}
+
+// RegisteredAsset is an asset registered on the main chain by a mint.
+type RegisteredAsset struct {
+	Asset
+	ID     []byte
+	Issuer []byte
+	TxID   []byte
+}
+
+func assetKey(id []byte) []byte {
+	return append(append([]byte{}, assetPrefix...), id...)
+}
+
+// AssetID returns the identifier of the asset registered by the issuer with
+// pubKeyHash: the SHA-256 of the canonical encoding of both.
+func AssetID(pubKeyHash []byte, asset Asset) []byte {
+	e := newEncoder()
+	e.bytes(pubKeyHash)
+	e.asset(&asset)
+
+	hash := sha256.Sum256(e.buf.Bytes())
+
+	return hash[:]
+}
+
+func (a Asset) valid() bool {
+	for _, field := range []string{a.Product, a.Manufacturer, a.Lot} {
+		if field == "" || len(field) > MaxAssetFieldLength {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkAssets runs the asset checks of CheckSanity: only mints may register
+// assets, each once, and coinbases pay the native token only.
+func (tx *Transaction) checkAssets() error {
+	if len(tx.Assets) > 0 && (!hasAssets(tx.Version) || !tx.IsMint()) {
+		return ErrInvalidAsset
+	}
+
+	seen := make(map[string]bool)
+	for _, asset := range tx.Assets {
+		if !asset.valid() {
+			return ErrInvalidAsset
+		}
+
+		id := string(AssetID(tx.Issuer(), asset))
+		if seen[id] {
+			return ErrDuplicateAsset
+		}
+		seen[id] = true
+	}
+
+	for _, out := range tx.Outputs {
+		if len(out.Asset) == 0 {
+			continue
+		}
+
+		if !hasAssets(tx.Version) || len(out.Asset) != sha256.Size || tx.IsCoinbase() {
+			return ErrInvalidAsset
+		}
+	}
+
+	return nil
+}
+
+// registers reports whether tx registers the asset id.
+func (tx *Transaction) registers(id []byte) bool {
+	for _, asset := range tx.Assets {
+		if bytes.Equal(AssetID(tx.Issuer(), asset), id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkConservation checks that, for every asset but the native token, the
+// outputs of tx hold exactly what the inputs spend from prevTXs.
+func (tx *Transaction) checkConservation(prevTXs map[string]Transaction) error {
+	balance := make(map[string]int)
+
+	for _, in := range tx.Inputs {
+		out := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		balance[string(out.Asset)] += out.Value
+	}
+
+	for _, out := range tx.Outputs {
+		balance[string(out.Asset)] -= out.Value
+	}
+
+	for asset, diff := range balance {
+		if asset != "" && diff != 0 {
+			return fmt.Errorf("asset %x: %w", asset, ErrAssetImbalance)
+		}
+	}
+
+	return nil
+}
+
+// validateMintAssets checks the assets a mint registers and issues against
+// the main chain, when indexed, and against spent, which holds those of the
+// transactions before it. A mint may only register assets that are new, and
+// only issue assets it registers or its issuer registered before. The
+// registrations are recorded in spent.
+func (chain *BlockChain) validateMintAssets(tx *Transaction, spent map[string]bool, indexed bool) error {
+	issuer := tx.Issuer()
+
+	for _, asset := range tx.Assets {
+		id := AssetID(issuer, asset)
+		if spent[fmt.Sprintf("asset:%x", id)] {
+			return ErrDuplicateAsset
+		}
+
+		if !indexed {
+			continue
+		}
+
+		_, err := chain.GetAsset(id)
+		if err == nil {
+			return ErrDuplicateAsset
+		}
+		if !errors.Is(err, ErrUnknownAsset) {
+			return err
+		}
+	}
+
+	for _, out := range tx.Outputs {
+		if len(out.Asset) == 0 || tx.registers(out.Asset) || spent[fmt.Sprintf("asset:%x:%x", out.Asset, issuer)] {
+			continue
+		}
+
+		if indexed {
+			registered, err := chain.GetAsset(out.Asset)
+			if err == nil && bytes.Equal(registered.Issuer, issuer) {
+				continue
+			}
+			if err != nil && !errors.Is(err, ErrUnknownAsset) {
+				return err
+			}
+		}
+
+		return fmt.Errorf("asset %x: %w", out.Asset, ErrUnknownAsset)
+	}
+
+	for _, asset := range tx.Assets {
+		id := AssetID(issuer, asset)
+		spent[fmt.Sprintf("asset:%x", id)] = true
+		spent[fmt.Sprintf("asset:%x:%x", id, issuer)] = true
+	}
+
+	return nil
+}
+
+// indexAssets records, or with a negative sign removes, the registrations of
+// a mint. It runs with the other main chain indexes.
+func indexAssets(txn StoreTxn, tx *Transaction, sign int) error {
+	for _, asset := range tx.Assets {
+		key := assetKey(AssetID(tx.Issuer(), asset))
+
+		var err error
+		if sign > 0 {
+			err = txn.Set(key, tx.ID)
+		} else {
+			err = txn.Delete(key)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAsset returns the registration of the asset id on the main chain, or an
+// error wrapping ErrUnknownAsset.
+func (chain *BlockChain) GetAsset(id []byte) (*RegisteredAsset, error) {
+	var txID []byte
+
+	err := chain.Database.View(func(txn StoreTxn) error {
+		val, err := txn.Get(assetKey(id))
+		if err == ErrKeyNotFound {
+			return fmt.Errorf("asset %x: %w", id, ErrUnknownAsset)
+		}
+
+		txID = val
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := chain.FindTransaction(txID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, asset := range tx.Assets {
+		if bytes.Equal(AssetID(tx.Issuer(), asset), id) {
+			return &RegisteredAsset{Asset: asset, ID: id, Issuer: tx.Issuer(), TxID: tx.ID}, nil
+		}
+	}
+
+	return nil, corrupt("asset index", fmt.Errorf("transaction %x does not register asset %x", txID, id))
+}
+
+// AssetBalance is what a wallet holds of one asset. Asset is nil for the
+// native token.
+type AssetBalance struct {
+	Asset []byte
+	Value int
+}
+
+// Balances returns the unspent, unexpired holdings of pubKeyHash per asset,
+// the native token first and the others ordered by ID.
+func (u UTXOSet) Balances(pubKeyHash []byte) ([]AssetBalance, error) {
+	values := make(map[string]int)
+	now := time.Now().Unix()
+
+	err := u.Blockchain.Database.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(utxoPrefix, func(k, v []byte) error {
+			if isUTXOMeta(k) {
+				return nil
+			}
+
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			for _, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) && !out.IsExpired(now) {
+					values[string(out.Asset)] += out.Value
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]AssetBalance, 0, len(values))
+	for asset, value := range values {
+		balance := AssetBalance{Value: value}
+		if asset != "" {
+			balance.Asset = []byte(asset)
+		}
+
+		balances = append(balances, balance)
+	}
+
+	sort.Slice(balances, func(i, j int) bool {
+		return bytes.Compare(balances[i].Asset, balances[j].Asset) < 0
+	})
+
+	return balances, nil
+}
@@ -0,0 +1,107 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+	"github.com/swagftw/covax19-blockchain/types"
+)
+
+func TestAssets(t *testing.T) {
+	bharat := wallet.MakeWallet()
+	serum := wallet.MakeWallet()
+	clinic := wallet.MakeWallet()
+	citizen := wallet.MakeWallet()
+
+	params := DefaultChainParams
+	params.Issuers = []string{string(bharat.Address()), string(serum.Address())}
+
+	chain := newTestChain(t, params, bharat)
+
+	covaxin := Asset{Product: "Covaxin", Manufacturer: "Bharat Biotech", Lot: "A"}
+	covishield := Asset{Product: "Covishield", Manufacturer: "Serum Institute", Lot: "B"}
+	lotA := AssetID(wallet.PublicKeyToHash(bharat.PublicKey), covaxin)
+	lotB := AssetID(wallet.PublicKeyToHash(serum.PublicKey), covishield)
+
+	mintA, err := NewMintTx(bharat, []Payment{{To: string(clinic.Address()), Amount: 10, Asset: lotA}}, "order-a", covaxin)
+	assert.NoError(t, err)
+
+	mintB, err := NewMintTx(serum, []Payment{{To: string(clinic.Address()), Amount: 20, Asset: lotB}}, "order-b", covishield)
+	assert.NoError(t, err)
+
+	// only the manufacturer that registered a lot may issue it
+	stolen, err := NewMintTx(serum, []Payment{{To: string(citizen.Address()), Amount: 5, Asset: lotA}}, "order-c")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, chain.VerifyTransaction(stolen), ErrUnknownAsset)
+
+	mineBlock(t, chain, bharat, mintA, mintB)
+
+	registered, err := chain.GetAsset(lotA)
+	assert.NoError(t, err)
+	assert.Equal(t, covaxin, registered.Asset)
+	assert.Equal(t, mintA.ID, registered.TxID)
+
+	again, err := NewMintTx(bharat, []Payment{{To: string(clinic.Address()), Amount: 1, Asset: lotA}}, "order-d", covaxin)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, chain.VerifyTransaction(again), ErrDuplicateAsset)
+
+	more, err := NewMintTx(bharat, []Payment{{To: string(clinic.Address()), Amount: 1, Asset: lotA}}, "order-e")
+	assert.NoError(t, err)
+	assert.NoError(t, chain.VerifyTransaction(more))
+
+	utxo := UTXOSet{chain}
+	clinicHash := wallet.PublicKeyToHash(clinic.PublicKey)
+
+	balances, err := utxo.Balances(clinicHash)
+	assert.NoError(t, err)
+	assert.Len(t, balances, 2)
+
+	native, err := utxo.FindUnspentTransactions(clinicHash, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, native)
+
+	// lots are spent separately
+	_, err = NewTransaction(clinic, []Payment{{To: string(citizen.Address()), Amount: 11, Asset: lotA}}, 0, &utxo)
+	assert.ErrorIs(t, err, types.ErrNotEnoughFunds)
+
+	dose, err := NewTransaction(clinic, []Payment{{To: string(citizen.Address()), Amount: 1, Asset: lotA}}, 0, &utxo)
+	assert.NoError(t, err)
+	assert.Len(t, dose.Inputs, 1)
+	assert.NoError(t, chain.VerifyTransaction(dose))
+
+	// one lot cannot be passed off as another
+	swapped := &Transaction{
+		Version: TxVersion,
+		Inputs:  []TxInput{{ID: mintA.ID, Out: 0, PubKey: clinic.PublicKey}},
+		Outputs: []TxOutput{{Value: 10, PubKeyHash: wallet.PublicKeyToHash(citizen.PublicKey), Asset: lotB}},
+	}
+	swapped.ID = swapped.Hash()
+	assert.NoError(t, chain.SignTransaction(swapped, clinic.PrivateKey))
+	assert.ErrorIs(t, chain.VerifyTransaction(swapped), ErrAssetImbalance)
+
+	mineBlock(t, chain, bharat, dose)
+
+	unspent, err := utxo.FindUnspentTransactions(clinicHash, lotA)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 1)
+	assert.Equal(t, 9, unspent[0].Value)
+
+	unspent, err = utxo.FindUnspentTransactions(clinicHash, lotB)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 1)
+	assert.Equal(t, 20, unspent[0].Value)
+
+	stored, err := DeserializeOutputs(TxOutputs{Outputs: dose.Outputs}.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, lotA, stored.Outputs[0].Asset)
+
+	decoded, err := DeserializeTransaction(mintA.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, mintA.Assets, decoded.Assets)
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
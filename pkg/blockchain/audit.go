@@ -0,0 +1,383 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrBrokenLink   = errors.New("block does not link to the previous block")
+	ErrDoubleSpend  = errors.New("transaction input spends an already spent output")
+	ErrUTXOMismatch = errors.New("stored UTXO set does not match the chain")
+)
+
+// ChainReport is the result of auditing the main chain with VerifyChain.
+type ChainReport struct {
+	Tip          []byte
+	Height       int
+	Blocks       int
+	Transactions int
+	// LegacyBlocks counts blocks sealed before headers were versioned or
+	// holding version 0 transactions. Their Merkle roots were computed over
+	// gob data that cannot be reproduced, so they are not checked, and the
+	// hash of a legacy header is only checked against its proof.
+	LegacyBlocks int
+	// Divergence is the first problem found, nil when the chain is valid.
+	Divergence *Divergence
+}
+
+// Divergence locates the first check VerifyChain failed. TxID is empty when
+// the problem is with the block itself, and Height is -1 when the block could
+// not be loaded.
+type Divergence struct {
+	Height    int
+	BlockHash []byte
+	TxID      []byte
+	// Err wraps one of the Err* sentinels describing the failed check.
+	Err error
+}
+
+// Valid reports whether the audit found no divergence.
+func (r *ChainReport) Valid() bool {
+	return r.Divergence == nil
+}
+
+// VerifyChain audits the main chain from genesis to the tip. It checks every
+// block's seal, link to its parent, height, size and Merkle root, validates
+// every transaction and coinbase value, checks that no output is spent twice,
+// and that the stored UTXO set matches one recomputed from the blocks. The audit stops at the first
+// divergence; the returned error is only set when the store cannot be read.
+func (chain *BlockChain) VerifyChain() (*ChainReport, error) {
+	report := &ChainReport{Tip: chain.tip(), Height: -1}
+
+	hashes, divergence, err := chain.mainChain()
+	if err != nil {
+		return nil, err
+	}
+	if divergence != nil {
+		report.Divergence = divergence
+
+		return report, nil
+	}
+
+	var parent *Block
+
+	txs := make(map[string]*Transaction)
+	spent := make(map[string]bool)
+
+	for i := len(hashes) - 1; i >= 0; i-- {
+		expected := len(hashes) - 1 - i
+
+		block, err := chain.GetBlock(hashes[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if report.Divergence = chain.auditBlock(&block, parent, expected); report.Divergence != nil {
+			return report, nil
+		}
+
+		if block.hasLegacyHeader() || block.hasLegacyTransactions() {
+			report.LegacyBlocks++
+		} else if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+			report.Divergence = &Divergence{Height: expected, BlockHash: block.Hash, Err: ErrInvalidMerkleRoot}
+
+			return report, nil
+		}
+
+		fees := 0
+
+		var coinbase *Transaction
+
+		for _, tx := range block.Transactions {
+			fee, err := chain.auditTransaction(tx, txs, spent, block.Timestamp)
+			if err == nil && !tx.IsFinal(block.Timestamp) {
+				err = ErrLockTime
+			}
+			if err == nil {
+				err = chain.checkTxVersion(tx, block.Height)
+			}
+
+			if err != nil {
+				report.Divergence = &Divergence{Height: expected, BlockHash: block.Hash, TxID: tx.ID, Err: err}
+
+				return report, nil
+			}
+
+			if tx.IsCoinbase() {
+				coinbase = tx
+			}
+
+			fees += fee
+			txs[hex.EncodeToString(tx.ID)] = tx
+		}
+
+		if coinbase != nil && coinbase.OutputTotal() > BlockSubsidy+fees {
+			report.Divergence = &Divergence{Height: expected, BlockHash: block.Hash, TxID: coinbase.ID, Err: ErrExcessiveCoinbase}
+
+			return report, nil
+		}
+
+		report.Blocks++
+		report.Transactions += len(block.Transactions)
+		report.Height = block.Height
+		parent = &block
+	}
+
+	if err := chain.auditUTXO(); err != nil {
+		var mismatch *utxoMismatch
+		if !errors.As(err, &mismatch) {
+			return nil, err
+		}
+
+		report.Divergence = &Divergence{Height: report.Height, BlockHash: report.Tip, TxID: mismatch.txID, Err: err}
+	}
+
+	return report, nil
+}
+
+// mainChain returns the main chain block hashes from the tip down to genesis.
+// A block that is missing or cannot be decoded is reported as a divergence.
+func (chain *BlockChain) mainChain() ([][]byte, *Divergence, error) {
+	var hashes [][]byte
+
+	iter := chain.Iterator()
+
+	for {
+		hash := iter.CurrentHash
+
+		block, err := iter.Next()
+		if errors.Is(err, ErrBlockNotFound) || errors.Is(err, ErrCorruptData) {
+			return nil, &Divergence{Height: -1, BlockHash: hash, Err: err}, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hashes = append(hashes, hash)
+
+		if len(block.PrevHash) == 0 {
+			return hashes, nil, nil
+		}
+	}
+}
+
+// auditBlock runs the header checks of a block whose parent is parent, nil
+// for genesis, and which should sit at the given height.
+func (chain *BlockChain) auditBlock(block, parent *Block, height int) *Divergence {
+	fail := func(err error) *Divergence {
+		return &Divergence{Height: height, BlockHash: block.Hash, Err: err}
+	}
+
+	if block.Height != height {
+		return fail(ErrInvalidHeight)
+	}
+
+	var parentHeader *BlockHeader
+	if parent != nil {
+		parentHeader = &parent.BlockHeader
+
+		if !bytes.Equal(block.PrevHash, parent.Hash) {
+			return fail(ErrBrokenLink)
+		}
+	}
+
+	var err error
+	if block.hasLegacyHeader() {
+		err = chain.verifyLegacySeal(parentHeader, block)
+	} else {
+		err = chain.Engine.VerifySeal(chain, parentHeader, block)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if len(block.Transactions) == 0 {
+		return fail(ErrNoTransactions)
+	}
+
+	if len(block.Serialize()) > MaxBlockSize {
+		return fail(ErrBlockTooLarge)
+	}
+
+	return nil
+}
+
+// auditTransaction validates tx, in a block with the given timestamp, against
+// the earlier transactions of the chain, marks the outputs it spends, or the
+// reference it issues under, and returns its fee.
+func (chain *BlockChain) auditTransaction(tx *Transaction, txs map[string]*Transaction, spent map[string]bool, timestamp int64) (int, error) {
+	if _, ok := txs[hex.EncodeToString(tx.ID)]; ok {
+		return 0, ErrDuplicateTxID
+	}
+
+	if tx.IsCoinbase() {
+		return 0, tx.CheckSanity()
+	}
+
+	if tx.IsMint() {
+		if !chain.IsIssuer(tx.Issuer()) {
+			return 0, ErrNotIssuer
+		}
+
+		outpoint := fmt.Sprintf("%x:%d", tx.Reference(), MintInput)
+		if spent[outpoint] {
+			return 0, ErrDuplicateIssuance
+		}
+		spent[outpoint] = true
+
+		if err := tx.Validate(nil); err != nil {
+			return 0, err
+		}
+
+		return 0, chain.validateMintAssets(tx, spent, false)
+	}
+
+	if tx.isControl() {
+		return 0, chain.validateControl(tx, spent, false)
+	}
+
+	prevTXs := make(map[string]Transaction)
+
+	for _, in := range tx.Inputs {
+		id := hex.EncodeToString(in.ID)
+
+		prevTX, ok := txs[id]
+		if !ok || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return 0, fmt.Errorf("input %s:%d: %w", id, in.Out, ErrMissingInput)
+		}
+
+		outpoint := fmt.Sprintf("%s:%d", id, in.Out)
+		if spent[outpoint] {
+			return 0, fmt.Errorf("input %s: %w", outpoint, ErrDoubleSpend)
+		}
+		spent[outpoint] = true
+
+		prevTXs[id] = *prevTX
+	}
+
+	if err := tx.Validate(prevTXs); err != nil {
+		return 0, err
+	}
+
+	if err := chain.checkExpiry(tx, prevTXs, timestamp); err != nil {
+		return 0, err
+	}
+
+	if err := chain.checkFrozen(tx, prevTXs, spent, false); err != nil {
+		return 0, err
+	}
+
+	return tx.Fee(prevTXs)
+}
+
+type utxoMismatch struct {
+	txID   []byte
+	output int
+	reason string
+}
+
+func (e *utxoMismatch) Error() string {
+	return fmt.Sprintf("output %x:%d %s: %v", e.txID, e.output, e.reason, ErrUTXOMismatch)
+}
+
+func (e *utxoMismatch) Unwrap() error {
+	return ErrUTXOMismatch
+}
+
+// auditUTXO compares the stored UTXO set with FindUTXO, returning a
+// *utxoMismatch for the first output, in transaction id order, that differs.
+func (chain *BlockChain) auditUTXO() error {
+	expected, err := chain.FindUTXO()
+	if err != nil {
+		return err
+	}
+
+	var stored map[string]TxOutputs
+
+	err = chain.Database.View(func(txn StoreTxn) error {
+		stored, err = storedUTXO(txn)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(expected)+len(stored))
+	for id := range expected {
+		ids = append(ids, id)
+	}
+	for id := range stored {
+		if _, ok := expected[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		want, have := outputsByIndex(expected[id]), outputsByIndex(stored[id])
+		txID, _ := hex.DecodeString(id)
+
+		indexes := make([]int, 0, len(want)+len(have))
+		for index := range want {
+			indexes = append(indexes, index)
+		}
+		for index := range have {
+			if _, ok := want[index]; !ok {
+				indexes = append(indexes, index)
+			}
+		}
+		sort.Ints(indexes)
+
+		for _, index := range indexes {
+			w, wok := want[index]
+			h, hok := have[index]
+
+			switch {
+			case !hok:
+				return &utxoMismatch{txID, index, "is missing from the UTXO set"}
+			case !wok:
+				return &utxoMismatch{txID, index, "is spent or unknown but in the UTXO set"}
+			case !outputEqual(w, h):
+				return &utxoMismatch{txID, index, "differs from the UTXO set"}
+			}
+		}
+	}
+
+	return nil
+}
+
+// storedUTXO reads the UTXO set, keyed by hex transaction id.
+func storedUTXO(txn StoreTxn) (map[string]TxOutputs, error) {
+	stored := make(map[string]TxOutputs)
+
+	err := txn.IteratePrefix(utxoPrefix, func(key, value []byte) error {
+		if isUTXOMeta(key) {
+			return nil
+		}
+
+		outs, err := DeserializeOutputs(value)
+		if err != nil {
+			return err
+		}
+
+		stored[hex.EncodeToString(bytes.TrimPrefix(key, utxoPrefix))] = outs
+
+		return nil
+	})
+
+	return stored, err
+}
+
+func outputsByIndex(outs TxOutputs) map[int]TxOutput {
+	byIndex := make(map[int]TxOutput, len(outs.Outputs))
+	for i, out := range outs.Outputs {
+		byIndex[outs.Index(i)] = out
+	}
+
+	return byIndex
+}
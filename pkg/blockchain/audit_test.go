@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestVerifyChain(t *testing.T) {
+	from := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+
+	chain := newTestChain(t, DefaultChainParams, from)
+
+	utxo := UTXOSet{chain}
+	tx, err := NewTransaction(from, []Payment{{To: string(to.Address()), Amount: 5}}, 0, &utxo)
+	assert.NoError(t, err)
+
+	block := mineBlock(t, chain, from, tx)
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+	assert.Equal(t, 1, report.Height)
+	assert.Equal(t, 2, report.Blocks)
+	assert.Equal(t, 3, report.Transactions)
+
+	// a UTXO entry whose asset or expiry was changed
+	for _, tamper := range []func(out *TxOutput){
+		func(out *TxOutput) { out.Asset = []byte("other lot") },
+		func(out *TxOutput) { out.Expiry = 1 },
+	} {
+		var original []byte
+
+		err = chain.Database.Update(func(txn StoreTxn) error {
+			original, err = txn.Get(utxoKey(tx.ID))
+			if err != nil {
+				return err
+			}
+
+			outs, err := DeserializeOutputs(original)
+			if err != nil {
+				return err
+			}
+			tamper(&outs.Outputs[0])
+
+			return txn.Set(utxoKey(tx.ID), outs.Serialize())
+		})
+		assert.NoError(t, err)
+
+		report, err = chain.VerifyChain()
+		assert.NoError(t, err)
+		if assert.NotNil(t, report.Divergence) {
+			assert.ErrorIs(t, report.Divergence.Err, ErrUTXOMismatch)
+			assert.Equal(t, tx.ID, report.Divergence.TxID)
+		}
+
+		err = chain.Database.Update(func(txn StoreTxn) error {
+			return txn.Set(utxoKey(tx.ID), original)
+		})
+		assert.NoError(t, err)
+	}
+
+	// a UTXO entry that went missing
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		return txn.Delete(utxoKey(block.Transactions[0].ID))
+	})
+	assert.NoError(t, err)
+
+	report, err = chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, report.Divergence.Err, ErrUTXOMismatch)
+	assert.Equal(t, block.Transactions[0].ID, report.Divergence.TxID)
+
+	// a signature altered after the block was stored breaks its Merkle root
+	block.Transactions[1].Inputs[0].Signature[0] ^= 0xff
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		return txn.Set(block.Hash, block.Serialize())
+	})
+	assert.NoError(t, err)
+
+	report, err = chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.False(t, report.Valid())
+	assert.ErrorIs(t, report.Divergence.Err, ErrInvalidMerkleRoot)
+	assert.Equal(t, 1, report.Divergence.Height)
+	assert.Equal(t, block.Hash, report.Divergence.BlockHash)
+}
+
+func TestVerifyLegacyChain(t *testing.T) {
+	miner := wallet.MakeWallet()
+
+	// legacyBlock seals a block the way it was before headers were versioned:
+	// over the parent hash, the Merkle root, the nonce and the difficulty
+	legacyBlock := func(prevHash []byte, height int, data string) *Block {
+		coinbase, err := CoinbaseTx(string(miner.Address()), data)
+		assert.NoError(t, err)
+		coinbase.Version = 0
+
+		block := &Block{
+			BlockHeader: BlockHeader{
+				PrevHash:   prevHash,
+				Timestamp:  time.Now().Unix(),
+				Difficulty: DefaultChainParams.InitialDifficulty,
+				Height:     height,
+			},
+			Transactions: []*Transaction{coinbase},
+		}
+
+		pow, err := NewProof(block)
+		assert.NoError(t, err)
+
+		for nonce := 0; ; nonce++ {
+			hash := sha256.Sum256(bytes.Join([][]byte{prevHash, block.HashTransactions(), ToHex(int64(nonce)), ToHex(int64(block.Difficulty))}, []byte{}))
+			if pow.meetsTarget(hash[:]) {
+				block.Nonce = nonce
+				block.Hash = hash[:]
+
+				return block
+			}
+		}
+	}
+
+	genesis := legacyBlock([]byte{}, 0, genesisData)
+	legacy := legacyBlock(genesis.Hash, 1, "")
+
+	store := NewMemoryStore()
+	err := store.Update(func(txn StoreTxn) error {
+		for _, block := range []*Block{genesis, legacy} {
+			if err := txn.Set(block.Hash, encodeGob(block)); err != nil {
+				return err
+			}
+		}
+
+		return txn.Set([]byte("lh"), legacy.Hash)
+	})
+	assert.NoError(t, err)
+
+	chain, err := ContinueBlockChain(store)
+	assert.NoError(t, err)
+
+	mineBlock(t, chain, miner)
+
+	// legacy transactions are only valid in the blocks the chain had when
+	// it was opened
+	legacyTx := &Transaction{
+		Version: 1,
+		Inputs:  []TxInput{{ID: genesis.Transactions[0].ID, Out: 0, PubKey: miner.PublicKey}},
+		Outputs: []TxOutput{*NewTXOutput(genesis.Transactions[0].Outputs[0].Value, string(miner.Address()))},
+	}
+	legacyTx.ID = legacyTx.Hash()
+	assert.NoError(t, legacyTx.Sign(miner.PrivateKey, map[string]Transaction{hex.EncodeToString(genesis.Transactions[0].ID): *genesis.Transactions[0]}))
+
+	assert.ErrorIs(t, chain.VerifyTransaction(legacyTx), ErrLegacyTransaction)
+
+	_, err = chain.AssembleBlock([]*Transaction{legacyTx}, string(miner.Address()))
+	assert.ErrorIs(t, err, ErrNoTransactions)
+
+	coinbase, err := CoinbaseTx(string(miner.Address()), "")
+	assert.NoError(t, err)
+
+	_, err = chain.MineBlock(context.Background(), []*Transaction{coinbase, legacyTx})
+	assert.ErrorIs(t, err, ErrLegacyTransaction)
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+	assert.Equal(t, 3, report.Blocks)
+	assert.Equal(t, 2, report.LegacyBlocks)
+
+	// a snapshot of the chain imports with the same checks
+	var snapshot bytes.Buffer
+	assert.NoError(t, chain.ExportChain(&snapshot))
+
+	imported, err := ImportChain(NewMemoryStore(), &snapshot, chain.LastHash)
+	assert.NoError(t, err)
+	assert.Equal(t, chain.LastHash, imported.LastHash)
+
+	// the stored hash of a legacy block must still carry its proof of work
+	forged := *legacy
+	forged.Hash = bytes.Repeat([]byte{0xff}, sha256.Size)
+	divergence := chain.auditBlock(&forged, genesis, 1)
+	if assert.NotNil(t, divergence) {
+		assert.ErrorIs(t, divergence.Err, ErrInvalidPoW)
+	}
+}
@@ -2,19 +2,51 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/gob"
-	"log"
 	"time"
 )
 
+// BlockVersion is the version of the block header layout.
+const BlockVersion = 1
+
+// BlockHeader holds everything a block commits to. The transactions are
+// committed through MerkleRoot, so a header can be checked without them.
+type BlockHeader struct {
+	Version    int
+	PrevHash   []byte
+	MerkleRoot []byte
+	Timestamp  int64
+	Difficulty int
+	Nonce      int
+	Height     int
+	// Signer and Signature seal blocks under proof of authority.
+	Signer    []byte
+	Signature []byte
+}
+
 type Block struct {
-	Timestamp    int64
+	BlockHeader
 	Hash         []byte
 	Transactions []*Transaction
-	PrevHash     []byte
-	Nonce        int
-	Height       int
-	Difficulty   int
+}
+
+// NewBlock returns an unsealed block on top of prevHash with its Merkle root set.
+func NewBlock(txs []*Transaction, prevHash []byte, height int) *Block {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:   BlockVersion,
+			PrevHash:  prevHash,
+			Timestamp: time.Now().Unix(),
+			Height:    height,
+		},
+		Hash:         []byte{},
+		Transactions: txs,
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	return block
 }
 
 func (b *Block) HashTransactions() []byte {
@@ -28,46 +60,163 @@ func (b *Block) HashTransactions() []byte {
 	return tree.RootNode.Data
 }
 
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
-	block := &Block{Timestamp: time.Now().Unix(), Hash: []byte{}, Transactions: txs, PrevHash: prevHash, Height: height, Difficulty: Difficulty}
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
+// hasLegacyHeader reports whether the block was sealed before headers were
+// versioned. Neither its hash nor its Merkle root can be recomputed, see
+// verifyLegacySeal.
+func (b *Block) hasLegacyHeader() bool {
+	return b.Version == 0
+}
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+// hasLegacyTransactions reports whether the block holds version 0
+// transactions. Their Merkle leaves were gob streams that cannot be
+// reproduced, so the Merkle root of such a block cannot be recomputed.
+func (b *Block) hasLegacyTransactions() bool {
+	for _, tx := range b.Transactions {
+		if tx.Version == 0 {
+			return true
+		}
+	}
 
-	return block
+	return false
 }
 
-func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
+// TransactionProof returns the Merkle path proving that the transaction at
+// index is committed to by the block's Merkle root.
+func (b *Block) TransactionProof(index int) ([]MerkleProofStep, error) {
+	var txHashes [][]byte
+
+	for _, tx := range b.Transactions {
+		txHashes = append(txHashes, tx.Serialize())
+	}
+
+	return NewMerkleTree(txHashes).Proof(index)
 }
 
-func (b *Block) Serialize() []byte {
-	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res)
+// hashData is what the block hash is computed over: every header field except
+// the seal signature, with the given nonce.
+func (h *BlockHeader) hashData(nonce int) []byte {
+	return bytes.Join(
+		[][]byte{
+			ToHex(int64(h.Version)),
+			h.PrevHash,
+			h.MerkleRoot,
+			ToHex(h.Timestamp),
+			ToHex(int64(h.Difficulty)),
+			ToHex(int64(nonce)),
+			ToHex(int64(h.Height)),
+			h.Signer,
+		},
+		[]byte{},
+	)
+}
 
-	err := encoder.Encode(b)
+// ComputeHash returns the hash of the header.
+func (h *BlockHeader) ComputeHash() []byte {
+	hash := sha256.Sum256(h.hashData(h.Nonce))
 
-	Handle(err)
+	return hash[:]
+}
 
-	return res.Bytes()
+// Serialize returns the canonical encoding of the header.
+func (h *BlockHeader) Serialize() []byte {
+	e := newEncoder()
+	e.header(h)
+
+	return e.buf.Bytes()
+}
+
+// DeserializeHeader decodes a header in the canonical or the legacy gob encoding.
+func DeserializeHeader(data []byte) (*BlockHeader, error) {
+	var header BlockHeader
+
+	if isCanonical(data) {
+		d := newDecoder(data)
+		header = d.header()
+
+		if err := d.finish(); err != nil {
+			return nil, corrupt("block header", err)
+		}
+
+		return &header, nil
+	}
+
+	if err := decodeGob(data, &header); err != nil {
+		return nil, corrupt("block header", err)
+	}
+
+	return &header, nil
+}
+
+// CreateBlock mines a block with proof of work at the given difficulty.
+func CreateBlock(txs []*Transaction, prevHash []byte, height, difficulty int) (*Block, error) {
+	block := NewBlock(txs, prevHash, height)
+	block.Difficulty = difficulty
+	engine := &PoWConsensus{}
+
+	if err := engine.Seal(context.Background(), block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
 }
 
-func Deserialize(data []byte) *Block {
+// Serialize returns the canonical encoding of the block.
+func (b *Block) Serialize() []byte {
+	e := newEncoder()
+	e.header(&b.BlockHeader)
+	e.bytes(b.Hash)
+
+	e.count(len(b.Transactions))
+	for _, tx := range b.Transactions {
+		e.transaction(tx)
+	}
+
+	return e.buf.Bytes()
+}
+
+// Deserialize decodes a block in the canonical or the legacy gob encoding,
+// returning an error wrapping ErrCorruptData when data is not a valid encoding.
+func Deserialize(data []byte) (*Block, error) {
 	var block Block
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if isCanonical(data) {
+		d := newDecoder(data)
+		block.BlockHeader = d.header()
+		block.Hash = d.bytes()
+
+		if n := d.count(28); n > 0 {
+			block.Transactions = make([]*Transaction, n)
+			for i := range block.Transactions {
+				tx := d.transaction()
+				block.Transactions[i] = &tx
+			}
+		}
 
-	err := decoder.Decode(&block)
+		if err := d.finish(); err != nil {
+			return nil, corrupt("block", err)
+		}
 
-	Handle(err)
+		return &block, nil
+	}
+
+	if err := decodeGob(data, &block); err != nil {
+		return nil, corrupt("block", err)
+	}
 
-	return &block
+	return &block, nil
 }
 
-func Handle(err error) {
-	if err != nil {
-		log.Panic(err)
+// encodeGob is used for internal bookkeeping records. It panics on failure, which only happens for types gob cannot encode.
+func encodeGob(v interface{}) []byte {
+	var res bytes.Buffer
+
+	if err := gob.NewEncoder(&res).Encode(v); err != nil {
+		panic(err)
 	}
+
+	return res.Bytes()
+}
+
+func decodeGob(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
 }
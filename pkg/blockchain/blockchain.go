@@ -2,272 +2,473 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
-	"runtime"
-	"strings"
+	"math/big"
+	"sync"
+	"time"
 
-	"github.com/dgraph-io/badger"
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
 )
 
 const (
-	dbPath      = "./tmp/blocks"
-	genesisData = "First Transaction from Genesis"
+	// DefaultDataDir is where the node and the CLI keep the chain database.
+	DefaultDataDir = "./tmp/blocks"
+	genesisData    = "First Transaction from Genesis"
 )
 
+// legacyTxKey holds the legacy height of the chain. Chains created since it
+// exists have none, chains created before get the height of the first block
+// added after they are opened.
+var legacyTxKey = []byte("legacytx")
+
 type BlockChain struct {
 	LastHash []byte
-	Database *badger.DB
-}
+	Database ChainStore
+	Params   ChainParams
+	Engine   Consensus
 
-func DBexists(path string) bool {
-	if _, err := os.Stat(path + "/MANIFEST"); os.IsNotExist(err) {
-		return false
-	}
+	// legacyTxHeight is the first height at which transactions older than
+	// MinTxVersion are rejected, see legacyTxKey.
+	legacyTxHeight int
 
-	return true
+	// mutex serializes changes of LastHash, which locally mined blocks and
+	// blocks received from peers race to make.
+	mutex sync.Mutex
 }
 
-func ContinueBlockChain() *BlockChain {
-	path := dbPath
-	if !DBexists(path) {
-		log.Println("No existing blockchain found, create one!")
-		runtime.Goexit()
-	}
+// ContinueBlockChain loads the chain kept in store. It returns ErrNoChain when
+// the store holds no chain yet and ErrImportIncomplete when a snapshot import
+// into it did not finish.
+func ContinueBlockChain(store ChainStore) (*BlockChain, error) {
+	var (
+		lastHash []byte
+		params   ChainParams
+	)
 
-	var lastHash []byte
+	err := store.View(func(txn StoreTxn) error {
+		var err error
 
-	opts := badger.DefaultOptions(path)
+		if _, err := txn.Get(importingKey); err == nil {
+			return ErrImportIncomplete
+		}
 
-	db, err := openDB(path, opts)
-	Handle(err)
+		lastHash, err = txn.Get([]byte("lh"))
+		if err != nil {
+			return err
+		}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		_ = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
+		params, err = loadParams(txn)
 
 		return err
 	})
-	Handle(err)
+	if err == ErrKeyNotFound {
+		return nil, ErrNoChain
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	engine, err := NewConsensus(params)
+	if err != nil {
+		return nil, err
+	}
 
-	chain := BlockChain{lastHash, db}
+	chain := BlockChain{LastHash: lastHash, Database: store, Params: params, Engine: engine}
 
-	return &chain
-}
+	if err := chain.ensureIndexes(); err != nil {
+		return nil, fmt.Errorf("building indexes: %w", err)
+	}
 
-func InitBlockChain(address string) *BlockChain {
-	if DBexists(dbPath) {
-		log.Println("Blockchain already exists")
-		runtime.Goexit()
+	if err := chain.loadLegacyTxHeight(); err != nil {
+		return nil, err
 	}
 
-	var lastHash []byte
+	UTXOSet := UTXOSet{&chain}
+	meta, ok, err := UTXOSet.Meta()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok || !bytes.Equal(meta.Tip, lastHash) {
+		log.Println("UTXO set does not match the chain tip, reindexing")
+		if err := UTXOSet.Reindex(); err != nil {
+			return nil, fmt.Errorf("reindexing UTXO set: %w", err)
+		}
+	}
 
-	opts := badger.DefaultOptions(dbPath)
+	return &chain, nil
+}
+
+// loadLegacyTxHeight reads the legacy height of the chain, and records it
+// for chains created before it was kept.
+func (chain *BlockChain) loadLegacyTxHeight() error {
+	err := chain.Database.View(func(txn StoreTxn) error {
+		val, err := txn.Get(legacyTxKey)
+		if err != nil {
+			return err
+		}
 
-	db, err := openDB(dbPath, opts)
-	Handle(err)
+		if len(val) != 8 {
+			return corrupt("legacy height", errTruncated)
+		}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbtx)
-		log.Println("Genesis created")
-		err = txn.Set(genesis.Hash, genesis.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), genesis.Hash)
+		chain.legacyTxHeight = int(binary.BigEndian.Uint64(val))
 
-		lastHash = genesis.Hash
+		return nil
+	})
+	if err != ErrKeyNotFound {
+		return err
+	}
 
+	height, err := chain.GetBestHeight()
+	if err != nil {
 		return err
+	}
+
+	chain.legacyTxHeight = height + 1
+
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		return setLegacyTxHeight(txn, chain.legacyTxHeight)
 	})
+	if err == ErrReadOnlyTxn {
+		// a chain opened for inspection does not get new blocks
+		return nil
+	}
 
-	Handle(err)
+	return err
+}
 
-	blockchain := BlockChain{lastHash, db}
+func setLegacyTxHeight(txn StoreTxn, height int) error {
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], uint64(height))
 
-	return &blockchain
+	return txn.Set(legacyTxKey, val[:])
 }
 
-func (chain *BlockChain) AddBlock(block *Block) {
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get(block.Hash); err == nil {
-			return nil
+// InitBlockChain creates a chain with the given parameters in an empty store
+// and pays the genesis coinbase to address. It returns ErrChainExists when the
+// store already holds a chain.
+func InitBlockChain(store ChainStore, address string, params ChainParams) (*BlockChain, error) {
+	err := store.View(func(txn StoreTxn) error {
+		_, err := txn.Get([]byte("lh"))
+
+		return err
+	})
+	if err == nil {
+		return nil, ErrChainExists
+	}
+	if err != ErrKeyNotFound {
+		return nil, err
+	}
+
+	engine, err := NewConsensus(params)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, issuer := range params.Issuers {
+		if !wallet.ValidateAddress(issuer) {
+			return nil, fmt.Errorf("issuer %q: invalid address", issuer)
 		}
+	}
 
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		Handle(err)
-
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		var lastHash []byte
-		_ = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
-
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		var lastBlockData []byte
-		_ = item.Value(func(val []byte) error {
-			lastBlockData = val
-			return nil
-		})
-
-		lastBlock := Deserialize(lastBlockData)
-
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			Handle(err)
-			chain.LastHash = block.Hash
+	if params.DisposalAddress != "" && !wallet.ValidateAddress(params.DisposalAddress) {
+		return nil, fmt.Errorf("disposal address %q: invalid address", params.DisposalAddress)
+	}
+
+	for _, regulator := range params.Regulators {
+		if !wallet.ValidateAddress(regulator) {
+			return nil, fmt.Errorf("regulator %q: invalid address", regulator)
 		}
+	}
 
-		return nil
+	if params.QuarantineAddress != "" && !wallet.ValidateAddress(params.QuarantineAddress) {
+		return nil, fmt.Errorf("quarantine address %q: invalid address", params.QuarantineAddress)
+	}
+
+	chain := &BlockChain{Database: store, Params: params, Engine: engine}
+
+	coinbase, err := CoinbaseTx(address, genesisCoinbaseData(params))
+	if err != nil {
+		return nil, err
+	}
+
+	genesis := NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	if err := engine.Prepare(chain, nil, genesis); err != nil {
+		return nil, err
+	}
+
+	if err := engine.Seal(context.Background(), genesis); err != nil {
+		return nil, err
+	}
+
+	err = store.Update(func(txn StoreTxn) error {
+		return writeGenesis(txn, params, genesis, engine.Work(&genesis.BlockHeader), 0)
 	})
-	Handle(err)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Genesis created")
+
+	chain.LastHash = genesis.Hash
+
+	return chain, nil
 }
 
-func (chain *BlockChain) GetBestHeight() int {
-	var lastBlock Block
+// writeGenesis stores the chain parameters, the legacy height and genesis,
+// making it the tip.
+func writeGenesis(txn StoreTxn, params ChainParams, genesis *Block, work *big.Int, legacyTxHeight int) error {
+	if err := txn.Set(paramsKey, encodeGob(params)); err != nil {
+		return err
+	}
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		var lastHash []byte
-		_ = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
+	if err := setLegacyTxHeight(txn, legacyTxHeight); err != nil {
+		return err
+	}
 
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		var lastBlockData []byte
-		_ = item.Value(func(val []byte) error {
-			lastBlockData = val
-			return nil
-		})
+	if err := storeBlock(txn, genesis, work); err != nil {
+		return err
+	}
 
-		lastBlock = *Deserialize(lastBlockData)
+	if err := connectUTXO(txn, genesis); err != nil {
+		return err
+	}
 
-		return nil
-	})
-	Handle(err)
+	if err := indexBlock(txn, genesis); err != nil {
+		return err
+	}
 
-	return lastBlock.Height
+	if err := txn.Set(indexedKey, []byte{}); err != nil {
+		return err
+	}
+
+	return txn.Set([]byte("lh"), genesis.Hash)
 }
 
-func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
-	var block Block
+// AddBlock stores a block received from a peer. The block becomes the new tip
+// when its branch has more cumulative work than the current chain, in which
+// case the returned update lists the blocks that were disconnected and connected.
+func (chain *BlockChain) AddBlock(block *Block) (*ChainUpdate, error) {
+	if _, err := chain.GetBlock(block.Hash); err == nil {
+		return &ChainUpdate{}, nil
+	}
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		if item, err := txn.Get(blockHash); err != nil {
-			return errors.New("Block is not found")
-		} else {
-			var blockData []byte
-			_ = item.Value(func(val []byte) error {
-				blockData = val
-				return nil
-			})
-
-			block = *Deserialize(blockData)
-		}
+	chain.mutex.Lock()
+	defer chain.mutex.Unlock()
 
-		return nil
+	if err := chain.validateHeader(block); err != nil {
+		return nil, err
+	}
+
+	parentWork, err := chain.chainWork(block.PrevHash)
+	if err != nil {
+		return nil, err
+	}
+
+	work := new(big.Int).Add(parentWork, chain.Engine.Work(&block.BlockHeader))
+
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		return storeBlock(txn, block, work)
 	})
 	if err != nil {
-		return block, err
+		return nil, err
+	}
+
+	tipWork, err := chain.chainWork(chain.LastHash)
+	if err != nil {
+		return nil, err
 	}
 
-	return block, nil
+	if work.Cmp(tipWork) <= 0 {
+		return &ChainUpdate{}, nil
+	}
+
+	return chain.reorganize(block)
 }
 
-func (chain *BlockChain) GetBlockHashes() [][]byte {
-	var blocks [][]byte
+// GetBestHeight returns the height of the tip, reading only its header.
+func (chain *BlockChain) GetBestHeight() (int, error) {
+	var lastHash []byte
 
-	iter := chain.Iterator()
+	err := chain.Database.View(func(txn StoreTxn) error {
+		var err error
 
-	for {
-		block := iter.Next()
+		lastHash, err = txn.Get([]byte("lh"))
 
-		blocks = append(blocks, block.Hash)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
 
-		if len(block.PrevHash) == 0 {
-			break
-		}
+	header, err := chain.GetBlockHeader(lastHash)
+	if err != nil {
+		return 0, err
 	}
 
-	return blocks
+	return header.Height, nil
 }
 
-func (chain *BlockChain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
+// GetBlockHeader loads the header of a block without decoding its transactions.
+func (chain *BlockChain) GetBlockHeader(blockHash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
 
-	var lastHeight int
+	err := chain.Database.View(func(txn StoreTxn) error {
+		val, err := txn.Get(headerKey(blockHash))
+		if err != nil {
+			return err
+		}
+
+		header, err = DeserializeHeader(val)
 
-	for _, tx := range transactions {
-		if !chain.VerifyTransaction(tx) {
-			log.Panic("Invalid Transaction")
+		return err
+	})
+	if err == ErrKeyNotFound {
+		// blocks stored before headers were kept separately
+		block, err := chain.GetBlock(blockHash)
+		if err != nil {
+			return nil, err
 		}
+
+		return &block.BlockHeader, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		_ = item.Value(func(val []byte) error {
-			lastHash = val
+	return header, nil
+}
 
-			return nil
-		})
+// GetBlock loads a stored block. It returns an error wrapping ErrBlockNotFound
+// when the block is unknown.
+func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
+	var block Block
 
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		var lastBlockData []byte
-		_ = item.Value(func(val []byte) error {
-			lastBlockData = val
-			return nil
-		})
+	err := chain.Database.View(func(txn StoreTxn) error {
+		blockData, err := txn.Get(blockHash)
+		if err == ErrKeyNotFound {
+			return fmt.Errorf("block %x: %w", blockHash, ErrBlockNotFound)
+		}
+		if err != nil {
+			return err
+		}
 
-		lastBlock := Deserialize(lastBlockData)
+		decoded, err := Deserialize(blockData)
+		if err != nil {
+			return err
+		}
 
-		lastHeight = lastBlock.Height
+		block = *decoded
 
-		return err
+		return nil
 	})
-	Handle(err)
 
-	newBlock := CreateBlock(transactions, lastHash, lastHeight+1)
+	return block, err
+}
+
+// GetBlockHashes returns the main chain block hashes from the tip down to genesis.
+func (chain *BlockChain) GetBlockHashes() ([][]byte, error) {
+	var blocks [][]byte
 
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
+	best, err := chain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
 
-		chain.LastHash = newBlock.Hash
+	err = chain.Database.View(func(txn StoreTxn) error {
+		for height := best; height >= 0; height-- {
+			hash, err := txn.Get(heightKey(height))
+			if err != nil {
+				return err
+			}
 
-		return err
+			blocks = append(blocks, hash)
+		}
+
+		return nil
 	})
-	Handle(err)
 
-	return newBlock
+	return blocks, err
+}
+
+// MineBlock mines the transactions into a block on top of the current tip.
+// Mining stops with ctx.Err() when ctx is cancelled, for example because a
+// peer delivered a block for the same height first.
+func (chain *BlockChain) MineBlock(ctx context.Context, transactions []*Transaction) (*Block, error) {
+	newBlock, err := chain.prepareBlock(transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := chain.Engine.Seal(ctx, newBlock); err != nil {
+		return nil, err
+	}
+
+	chain.mutex.Lock()
+	defer chain.mutex.Unlock()
+
+	if !bytes.Equal(newBlock.PrevHash, chain.LastHash) {
+		return nil, fmt.Errorf("block %x: %w", newBlock.Hash, ErrStaleTip)
+	}
+
+	if err := chain.connectBlock(newBlock); err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
 }
 
-func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
+// prepareBlock returns an unsealed block of the transactions on top of the
+// current tip, after checking them against it.
+func (chain *BlockChain) prepareBlock(transactions []*Transaction) (*Block, error) {
+	chain.mutex.Lock()
+	defer chain.mutex.Unlock()
+
+	lastHeader, err := chain.GetBlockHeader(chain.LastHash)
+	if err != nil {
+		return nil, err
+	}
+
+	newBlock := NewBlock(transactions, chain.LastHash, lastHeader.Height+1)
+
+	// Prepare may move the timestamp the transactions are checked at
+	if err := chain.Engine.Prepare(chain, lastHeader, newBlock); err != nil {
+		return nil, err
+	}
+
+	if err := chain.validateTransactions(newBlock); err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+// tip returns LastHash, which may change under concurrent callers.
+func (chain *BlockChain) tip() []byte {
+	chain.mutex.Lock()
+	defer chain.mutex.Unlock()
+
+	return chain.LastHash
+}
+
+func (chain *BlockChain) FindUTXO() (map[string]TxOutputs, error) {
 	UTXO := make(map[string]TxOutputs)
 	spentTXOs := make(map[string][]int)
 
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
@@ -283,9 +484,10 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 				}
 				outs := UTXO[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
 				UTXO[txID] = outs
 			}
-			if tx.IsCoinbase() == false {
+			if tx.spendsOutputs() {
 				for _, in := range tx.Inputs {
 					inTxID := hex.EncodeToString(in.ID)
 					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
@@ -298,78 +500,96 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 		}
 	}
 
-	return UTXO
+	return UTXO, nil
 }
 
+// FindTransaction looks up a main chain transaction through the transaction index.
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := bc.Iterator()
+	block, i, err := bc.FindTransactionBlock(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
 
-	for {
-		block := iter.Next()
+	return *block.Transactions[i], nil
+}
 
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
+// FindTransactionBlock returns the main chain block containing the transaction
+// and the position of the transaction in it.
+func (bc *BlockChain) FindTransactionBlock(ID []byte) (*Block, int, error) {
+	loc, err := bc.lookupTransaction(ID)
+	if err != nil {
+		return nil, 0, err
+	}
 
-		if len(block.PrevHash) == 0 {
-			break
-		}
+	block, err := bc.GetBlock(loc.BlockHash)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return Transaction{}, errors.New("Transaction does not exist")
-}
+	if loc.Position >= len(block.Transactions) {
+		return nil, 0, corrupt(fmt.Sprintf("tx index %x", ID), errors.New("position out of range"))
+	}
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
+	return &block, loc.Position, nil
+}
 
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-		Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+// SignTransaction signs tx with the outputs it spends from the main chain.
+func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return err
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return tx.Sign(privKey, prevTXs)
 }
 
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
+// VerifyTransaction checks tx against the main chain, for example before it
+// enters the memory pool. It returns an error wrapping ErrInvalidTxID when its
+// ID is not its hash, ErrDuplicateTxID when the ID is on the chain already,
+// ErrTxNotFound when an input spends an unknown transaction, ErrDoubleSpend
+// when the output is already spent, ErrNotIssuer or ErrDuplicateIssuance for a rejected mint,
+// ErrNotRegulator or ErrControlSequence for a rejected freeze or unfreeze,
+// ErrLockTime when its lock time has not passed, ErrExpiredOutput or
+// ErrExpiryExtended when it breaks output expiry, ErrFrozen when it spends a
+// frozen asset elsewhere than to the quarantine address and otherwise one of
+// the errors of Transaction.Validate. Transactions older than MinTxVersion are
+// rejected with ErrLegacyTransaction, the next block being above the legacy height.
+func (bc *BlockChain) VerifyTransaction(tx *Transaction) error {
+	if tx.Version < MinTxVersion {
+		return fmt.Errorf("tx %x: %w", tx.ID, ErrLegacyTransaction)
+	}
+
 	if tx.IsCoinbase() {
-		return true
+		return tx.CheckSanity()
 	}
-	prevTXs := make(map[string]Transaction)
 
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-		Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	if tx.spendsOutputs() {
+		if _, err := bc.prevTransactions(tx); err != nil {
+			return err
+		}
 	}
 
-	return tx.Verify(prevTXs)
-}
+	now := time.Now().Unix()
 
-func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
-	lockPath := filepath.Join(dir, "LOCK")
-	if err := os.Remove(lockPath); err != nil {
-		return nil, fmt.Errorf(`removing "LOCK": %s`, err)
+	if !tx.IsFinal(now) {
+		return fmt.Errorf("tx %x: %w", tx.ID, ErrLockTime)
 	}
-	retryOpts := originalOpts
-	retryOpts.Truncate = true
-	db, err := badger.Open(retryOpts)
-	return db, err
+
+	_, err := bc.validateTransaction(tx, nil, make(map[string]bool), now)
+
+	return err
 }
 
-func openDB(dir string, opts badger.Options) (*badger.DB, error) {
-	if db, err := badger.Open(opts); err != nil {
-		if strings.Contains(err.Error(), "LOCK") {
-			if db, err := retry(dir, opts); err == nil {
-				log.Println("database unlocked, value log truncated")
-				return db, nil
-			}
-			log.Println("could not unlock database:", err)
+func (bc *BlockChain) prevTransactions(tx *Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+
+	for _, in := range tx.Inputs {
+		prevTX, err := bc.FindTransaction(in.ID)
+		if err != nil {
+			return nil, fmt.Errorf("tx %x: %w", tx.ID, err)
 		}
-		return nil, err
-	} else {
-		return db, nil
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
+
+	return prevTXs, nil
 }
@@ -0,0 +1,269 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+	"github.com/swagftw/covax19-blockchain/types"
+)
+
+func TestChainOnMemoryStore(t *testing.T) {
+	from := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+
+	chain := newTestChain(t, DefaultChainParams, from)
+	defer chain.Database.Close()
+
+	utxo := UTXOSet{chain}
+	tx, err := NewTransaction(from, []Payment{{To: string(to.Address()), Amount: 5}}, 0, &utxo)
+	assert.NoError(t, err)
+
+	block := mineBlock(t, chain, from, tx)
+
+	height, err := chain.GetBestHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, height)
+
+	byHeight, err := chain.GetBlockByHeight(1)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, byHeight.Hash)
+
+	found, err := chain.FindTransaction(tx.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tx.ID, found.ID)
+
+	unspent, err := utxo.FindUnspentTransactions(wallet.PublicKeyToHash(to.PublicKey), nil)
+	assert.NoError(t, err)
+
+	balance := 0
+	for _, out := range unspent {
+		balance += out.Value
+	}
+	assert.Equal(t, 5, balance)
+
+	meta, ok, err := utxo.Meta()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, block.Hash, meta.Tip)
+}
+
+func TestChainErrors(t *testing.T) {
+	store := NewMemoryStore()
+
+	_, err := ContinueBlockChain(store)
+	assert.ErrorIs(t, err, ErrNoChain)
+
+	params := DefaultChainParams
+	params.InitialDifficulty = params.MinDifficulty
+
+	chain, err := InitBlockChain(store, string(wallet.MakeWallet().Address()), params)
+	assert.NoError(t, err)
+
+	_, err = InitBlockChain(store, string(wallet.MakeWallet().Address()), params)
+	assert.ErrorIs(t, err, ErrChainExists)
+
+	_, err = chain.GetBlock([]byte("unknown"))
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+
+	_, err = chain.FindTransaction([]byte("unknown"))
+	assert.ErrorIs(t, err, ErrTxNotFound)
+
+	_, err = Deserialize([]byte("garbage"))
+	assert.ErrorIs(t, err, ErrCorruptData)
+
+	_, err = DeserializeTransaction([]byte("garbage"))
+	assert.ErrorIs(t, err, ErrCorruptData)
+
+	spend := &Transaction{Version: TxVersion, Inputs: []TxInput{{ID: []byte("unknown"), Out: 0}}}
+	assert.ErrorIs(t, chain.VerifyTransaction(spend), ErrTxNotFound)
+}
+
+func TestContinueStaleUTXO(t *testing.T) {
+	from := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+
+	chain := newTestChain(t, DefaultChainParams, from)
+
+	utxo := UTXOSet{chain}
+	tx, err := NewTransaction(from, []Payment{{To: string(to.Address()), Amount: 5}}, 0, &utxo)
+	assert.NoError(t, err)
+
+	block := mineBlock(t, chain, from, tx)
+
+	balance := func(chain *BlockChain) int {
+		unspent, err := UTXOSet{chain}.FindUnspentTransactions(wallet.PublicKeyToHash(to.PublicKey), nil)
+		assert.NoError(t, err)
+
+		total := 0
+		for _, out := range unspent {
+			total += out.Value
+		}
+
+		return total
+	}
+
+	// the tip moved but the UTXO set, and its meta, stayed at the parent
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		return disconnectUTXO(txn, block)
+	})
+	assert.NoError(t, err)
+
+	meta, ok, err := utxo.Meta()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, block.PrevHash, meta.Tip)
+	assert.Equal(t, 0, balance(chain))
+
+	continued, err := ContinueBlockChain(chain.Database)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, continued.LastHash)
+	assert.Equal(t, 5, balance(continued))
+
+	meta, ok, err = UTXOSet{continued}.Meta()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, block.Hash, meta.Tip)
+	assert.Equal(t, 1, meta.Height)
+
+	// a set without meta, as an interrupted rebuild leaves it, is rebuilt too
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		return txn.Delete(utxoMetaKey)
+	})
+	assert.NoError(t, err)
+
+	continued, err = ContinueBlockChain(chain.Database)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, balance(continued))
+
+	meta, ok, err = UTXOSet{continued}.Meta()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, block.Hash, meta.Tip)
+
+	report, err := continued.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
+
+func TestTransactionValidation(t *testing.T) {
+	from := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+
+	chain := newTestChain(t, DefaultChainParams, from)
+
+	utxo := UTXOSet{chain}
+
+	_, err := NewTransaction(from, []Payment{{To: string(to.Address()), Amount: 0}}, 0, &utxo)
+	assert.ErrorIs(t, err, ErrInvalidOutputValue)
+
+	_, err = NewTransaction(to, []Payment{{To: string(from.Address()), Amount: 5}}, 0, &utxo)
+	assert.ErrorIs(t, err, types.ErrNotEnoughFunds)
+
+	tx, err := NewTransaction(from, []Payment{{To: string(to.Address()), Amount: 5}}, 0, &utxo)
+	assert.NoError(t, err)
+	assert.NoError(t, chain.VerifyTransaction(tx))
+
+	prevTX, err := chain.FindTransaction(tx.Inputs[0].ID)
+	assert.NoError(t, err)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prevTX.ID): prevTX}
+
+	// respend signs a transaction spending inputs of the genesis transaction
+	respend := func(inputs []TxInput, outputs ...TxOutput) *Transaction {
+		tx := &Transaction{Version: TxVersion, Inputs: inputs, Outputs: outputs}
+		tx.ID = tx.Hash()
+		assert.NoError(t, tx.Sign(from.PrivateKey, prevTXs))
+
+		return tx
+	}
+	in := TxInput{ID: prevTX.ID, Out: 0, PubKey: from.PublicKey}
+	value := prevTX.Outputs[0].Value
+
+	assert.ErrorIs(t, chain.VerifyTransaction(respend([]TxInput{in}, *NewTXOutput(value+1, string(to.Address())))), ErrValueImbalance)
+	assert.ErrorIs(t, chain.VerifyTransaction(respend([]TxInput{in}, *NewTXOutput(-1, string(to.Address())))), ErrInvalidOutputValue)
+	assert.ErrorIs(t, chain.VerifyTransaction(respend([]TxInput{in, in}, *NewTXOutput(value, string(to.Address())))), ErrDuplicateInput)
+
+	mineBlock(t, chain, from, tx)
+
+	// the genesis output is spent by tx now
+	assert.ErrorIs(t, chain.VerifyTransaction(respend([]TxInput{in}, *NewTXOutput(value, string(to.Address())))), ErrDoubleSpend)
+}
+
+func TestMultiRecipientTransaction(t *testing.T) {
+	from := wallet.MakeWallet()
+	recipients := []*wallet.Wallet{wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()}
+
+	chain := newTestChain(t, DefaultChainParams, from)
+
+	utxo := UTXOSet{chain}
+
+	var payments []Payment
+	for i, w := range recipients {
+		payments = append(payments, Payment{To: string(w.Address()), Amount: i + 2})
+	}
+
+	_, err := NewTransaction(from, nil, 0, &utxo)
+	assert.ErrorIs(t, err, ErrEmptyTransaction)
+
+	_, err = NewTransaction(from, append(payments, Payment{To: string(from.Address()), Amount: 20}), 0, &utxo)
+	assert.ErrorIs(t, err, types.ErrNotEnoughFunds)
+
+	tx, err := NewTransaction(from, payments, 0, &utxo)
+	assert.NoError(t, err)
+	// one output per payment and a single change output
+	assert.Len(t, tx.Outputs, len(payments)+1)
+
+	mineBlock(t, chain, from, tx)
+
+	for i, w := range recipients {
+		unspent, err := utxo.FindUnspentTransactions(wallet.PublicKeyToHash(w.PublicKey), nil)
+		assert.NoError(t, err)
+		assert.Len(t, unspent, 1)
+		assert.Equal(t, i+2, unspent[0].Value)
+	}
+}
+
+func TestDuplicateTxID(t *testing.T) {
+	from := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+
+	chain := newTestChain(t, DefaultChainParams, from)
+	utxo := UTXOSet{chain}
+
+	tx, err := NewTransaction(from, []Payment{{To: string(to.Address()), Amount: 5}}, 0, &utxo)
+	assert.NoError(t, err)
+
+	coinbase, err := CoinbaseTx(string(from.Address()), "reused")
+	assert.NoError(t, err)
+
+	_, err = chain.MineBlock(context.Background(), []*Transaction{coinbase, tx})
+	assert.NoError(t, err)
+
+	// a transaction carrying the ID of tx would overwrite its unspent outputs
+	forged, err := NewTransaction(from, []Payment{{To: string(from.Address()), Amount: 1}}, 0, &utxo)
+	assert.NoError(t, err)
+	forged.ID = tx.ID
+	assert.ErrorIs(t, chain.VerifyTransaction(forged), ErrInvalidTxID)
+
+	fresh, err := CoinbaseTx(string(from.Address()), "")
+	assert.NoError(t, err)
+
+	_, err = chain.MineBlock(context.Background(), []*Transaction{fresh, forged})
+	assert.ErrorIs(t, err, ErrInvalidTxID)
+
+	// the same coinbase again is a valid transaction whose ID is taken
+	_, err = chain.MineBlock(context.Background(), []*Transaction{coinbase})
+	assert.ErrorIs(t, err, ErrDuplicateTxID)
+
+	unspent, err := utxo.FindUnspentTransactions(wallet.PublicKeyToHash(to.PublicKey), nil)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 1)
+	assert.Equal(t, 5, unspent[0].Value)
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
@@ -1,36 +1,41 @@
 package blockchain
 
-import "github.com/dgraph-io/badger"
+import "fmt"
 
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Database    ChainStore
 }
 
 func (chain *BlockChain) Iterator() *BlockChainIterator {
-	iter := &BlockChainIterator{chain.LastHash, chain.Database}
+	iter := &BlockChainIterator{chain.tip(), chain.Database}
 
 	return iter
 }
 
-func (iter *BlockChainIterator) Next() *Block {
+// Next returns the current block and moves to its parent. Callers stop after
+// the genesis block, whose PrevHash is empty.
+func (iter *BlockChainIterator) Next() (*Block, error) {
 	var block *Block
 
-	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		Handle(err)
-		var encodedBlock []byte
-		err = item.Value(func(val []byte) error {
-			encodedBlock = val
-			return nil
-		})
-		block = Deserialize(encodedBlock)
+	err := iter.Database.View(func(txn StoreTxn) error {
+		encodedBlock, err := txn.Get(iter.CurrentHash)
+		if err == ErrKeyNotFound {
+			return ErrBlockNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		block, err = Deserialize(encodedBlock)
 
 		return err
 	})
-	Handle(err)
+	if err != nil {
+		return nil, fmt.Errorf("block %x: %w", iter.CurrentHash, err)
+	}
 
 	iter.CurrentHash = block.PrevHash
 
-	return block
+	return block, nil
 }
@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	ConsensusPoW = "pow"
+	ConsensusPoA = "poa"
+)
+
+var ErrUnknownConsensus = errors.New("unknown consensus engine")
+
+// Consensus decides who may produce blocks and how a block proves it.
+type Consensus interface {
+	// Prepare fills in the consensus fields of block, a child of parent, before
+	// it is sealed. parent is nil for the genesis block.
+	Prepare(chain *BlockChain, parent *BlockHeader, block *Block) error
+	// Seal completes block by setting its hash and proof. It stops with ctx.Err() when ctx is done.
+	Seal(ctx context.Context, block *Block) error
+	// VerifySeal checks the consensus fields and proof of block, a child of
+	// parent. parent is nil for the genesis block.
+	VerifySeal(chain *BlockChain, parent *BlockHeader, block *Block) error
+	// ExpectedSigner returns the public key hash expected to seal the child of
+	// parent, or nil when anyone may.
+	ExpectedSigner(parent *BlockHeader) []byte
+	// Work is the weight a block adds to its chain for fork choice.
+	Work(header *BlockHeader) *big.Int
+}
+
+// NewConsensus returns the engine selected by the chain parameters.
+func NewConsensus(params ChainParams) (Consensus, error) {
+	switch params.Consensus {
+	case "", ConsensusPoW:
+		return &PoWConsensus{}, nil
+	case ConsensusPoA:
+		return NewPoAConsensus(params.Authorities)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownConsensus, params.Consensus)
+	}
+}
+
+// PoWConsensus is the proof of work engine with difficulty retargeting.
+type PoWConsensus struct {
+	// Progress, if set, receives progress reports while a block is mined.
+	Progress ProgressFunc
+}
+
+// Prepare sets the difficulty of block. A nil parent prepares the genesis block.
+func (e *PoWConsensus) Prepare(chain *BlockChain, parent *BlockHeader, block *Block) error {
+	if parent == nil {
+		block.Difficulty = chain.Params.InitialDifficulty
+
+		return nil
+	}
+
+	difficulty, err := chain.NextDifficulty(parent)
+	if err != nil {
+		return err
+	}
+
+	block.Difficulty = difficulty
+
+	return nil
+}
+
+func (e *PoWConsensus) Seal(ctx context.Context, block *Block) error {
+	pow, err := NewProof(block)
+	if err != nil {
+		return err
+	}
+	pow.Progress = e.Progress
+
+	nonce, hash, err := pow.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	block.Hash = hash
+	block.Nonce = nonce
+
+	return nil
+}
+
+// VerifySeal checks the difficulty of block before its proof, so that a block
+// with a difficulty out of range is rejected without hashing it.
+func (e *PoWConsensus) VerifySeal(chain *BlockChain, parent *BlockHeader, block *Block) error {
+	difficulty := chain.Params.InitialDifficulty
+	if parent != nil {
+		var err error
+
+		difficulty, err = chain.NextDifficulty(parent)
+		if err != nil {
+			return err
+		}
+
+		if block.Difficulty < chain.Params.MinDifficulty || block.Difficulty > chain.Params.MaxDifficulty {
+			return ErrInvalidDifficulty
+		}
+	}
+
+	if block.Difficulty != difficulty {
+		return ErrInvalidDifficulty
+	}
+
+	if !bytes.Equal(block.ComputeHash(), block.Hash) {
+		return ErrInvalidHash
+	}
+
+	pow, err := NewProof(block)
+	if err != nil {
+		return err
+	}
+
+	if !pow.Validate() {
+		return ErrInvalidPoW
+	}
+
+	return nil
+}
+
+func (e *PoWConsensus) ExpectedSigner(parent *BlockHeader) []byte {
+	return nil
+}
+
+// Work is the expected number of hashes needed to mine the block.
+func (e *PoWConsensus) Work(header *BlockHeader) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(header.Difficulty))
+}
+
+// verifyLegacySeal checks a block sealed before headers were versioned, a
+// child of parent. Its hash covered a Merkle root of gob encoded transactions
+// that cannot be reproduced, so only the proof carried by the stored hash is
+// checked: the proof of work target, or the signature of an authority.
+func (chain *BlockChain) verifyLegacySeal(parent *BlockHeader, block *Block) error {
+	if len(block.Hash) != sha256.Size {
+		return ErrInvalidHash
+	}
+
+	if engine, ok := chain.Engine.(*PoAConsensus); ok {
+		if parent == nil {
+			return nil
+		}
+
+		return engine.verifySignature(block)
+	}
+
+	pow, err := NewProof(block)
+	if err != nil {
+		return err
+	}
+
+	if !pow.meetsTarget(block.Hash) {
+		return ErrInvalidPoW
+	}
+
+	return nil
+}
+
+// SealIsValid reports whether the block carries a valid seal for its position in the chain.
+func (chain *BlockChain) SealIsValid(block *Block) bool {
+	var parent *BlockHeader
+
+	if len(block.PrevHash) > 0 {
+		var err error
+
+		parent, err = chain.GetBlockHeader(block.PrevHash)
+		if err != nil {
+			return false
+		}
+	}
+
+	return chain.Engine.VerifySeal(chain, parent, block) == nil
+}
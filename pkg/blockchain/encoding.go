@@ -0,0 +1,379 @@
+package blockchain
+
+// Blocks, transactions and UTXO entries are stored, relayed and hashed in the
+// canonical encoding below. Encoding the same value always yields the same
+// bytes, so hashes do not depend on the Go version or on struct layout.
+//
+// Every record starts with a two byte prefix: the marker 0xC0, which can never
+// begin a gob stream, followed by the encoding version (currently 1). Records
+// without the marker are legacy gob data and are still decoded.
+//
+// After the prefix, fields are written in the order listed:
+//
+//	int     8 bytes, big-endian two's complement
+//	count   4 bytes, big-endian unsigned
+//	bytes   count, then that many raw bytes
+//	list    count, then each element
+//
+//	TxOutput     Value int, PubKeyHash bytes, Script bytes, Expiry int,
+//	             Asset bytes
+//	TxInput      ID bytes, Out int, Signature bytes, PubKey bytes, Script bytes
+//	Transaction  Version int, ID bytes, Inputs list, Outputs list, LockTime int,
+//	             Assets list
+//	Asset        Product bytes, Manufacturer bytes, Lot bytes
+//	BlockHeader  Version int, PrevHash bytes, MerkleRoot bytes, Timestamp int,
+//	             Difficulty int, Nonce int, Height int, Signer bytes, Signature bytes
+//	Block        BlockHeader, Hash bytes, Transactions list
+//	TxOutputs    Outputs list, Indexes list of int, Scripts list of bytes,
+//	             Expiries list of int, Assets list of bytes
+//	ChainParams  Consensus bytes, Authorities list of bytes, InitialDifficulty int,
+//	             MinDifficulty int, MaxDifficulty int, TargetBlockInterval int,
+//	             RetargetInterval int, MaxRetargetStep int, Issuers list of bytes,
+//	             DisposalAddress bytes, Regulators list of bytes,
+//	             QuarantineAddress bytes
+//
+// The Script fields are only written in transactions of version 3 and later,
+// Expiry in version 4 and later, Asset and Assets in version 5 and later, so
+// that earlier transactions keep their encoding and hash. TxOutputs writes the
+// outputs without any of them and follows them with each list up to the last
+// one that is needed: Scripts when an output has a script, Expiries when an
+// output expires and Assets when an output holds an asset.
+//
+// Nested values are written without their own prefix. Empty byte strings
+// decode as nil.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	encodingMarker  = 0xC0
+	encodingVersion = 1
+)
+
+var (
+	errTruncated     = errors.New("truncated record")
+	errTrailingBytes = errors.New("trailing bytes after record")
+)
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func newEncoder() *encoder {
+	e := &encoder{}
+	e.buf.WriteByte(encodingMarker)
+	e.buf.WriteByte(encodingVersion)
+
+	return e
+}
+
+func (e *encoder) int(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) count(n int) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) bytes(data []byte) {
+	e.count(len(data))
+	e.buf.Write(data)
+}
+
+func (e *encoder) output(out *TxOutput, version int) {
+	e.int(int64(out.Value))
+	e.bytes(out.PubKeyHash)
+
+	if hasScripts(version) {
+		e.bytes(out.Script)
+	}
+
+	if hasExpiry(version) {
+		e.int(out.Expiry)
+	}
+
+	if hasAssets(version) {
+		e.bytes(out.Asset)
+	}
+}
+
+func (e *encoder) asset(a *Asset) {
+	e.bytes([]byte(a.Product))
+	e.bytes([]byte(a.Manufacturer))
+	e.bytes([]byte(a.Lot))
+}
+
+func (e *encoder) input(in *TxInput, scripts bool) {
+	e.bytes(in.ID)
+	e.int(int64(in.Out))
+	e.bytes(in.Signature)
+	e.bytes(in.PubKey)
+
+	if scripts {
+		e.bytes(in.Script)
+	}
+}
+
+// hasScripts reports whether transactions of version carry Script fields.
+func hasScripts(version int) bool {
+	return version >= 3
+}
+
+// hasExpiry reports whether transactions of version carry output expiries.
+func hasExpiry(version int) bool {
+	return version >= 4
+}
+
+// hasAssets reports whether transactions of version carry assets.
+func hasAssets(version int) bool {
+	return version >= 5
+}
+
+func (e *encoder) transaction(tx *Transaction) {
+	e.int(int64(tx.Version))
+	e.bytes(tx.ID)
+
+	scripts := hasScripts(tx.Version)
+
+	e.count(len(tx.Inputs))
+	for i := range tx.Inputs {
+		e.input(&tx.Inputs[i], scripts)
+	}
+
+	e.count(len(tx.Outputs))
+	for i := range tx.Outputs {
+		e.output(&tx.Outputs[i], tx.Version)
+	}
+
+	e.int(tx.LockTime)
+
+	if hasAssets(tx.Version) {
+		e.count(len(tx.Assets))
+		for i := range tx.Assets {
+			e.asset(&tx.Assets[i])
+		}
+	}
+}
+
+func (e *encoder) header(h *BlockHeader) {
+	e.int(int64(h.Version))
+	e.bytes(h.PrevHash)
+	e.bytes(h.MerkleRoot)
+	e.int(h.Timestamp)
+	e.int(int64(h.Difficulty))
+	e.int(int64(h.Nonce))
+	e.int(int64(h.Height))
+	e.bytes(h.Signer)
+	e.bytes(h.Signature)
+}
+
+func (e *encoder) strings(list []string) {
+	e.count(len(list))
+	for _, s := range list {
+		e.bytes([]byte(s))
+	}
+}
+
+func (e *encoder) params(p *ChainParams) {
+	e.bytes([]byte(p.Consensus))
+	e.strings(p.Authorities)
+	e.int(int64(p.InitialDifficulty))
+	e.int(int64(p.MinDifficulty))
+	e.int(int64(p.MaxDifficulty))
+	e.int(p.TargetBlockInterval)
+	e.int(int64(p.RetargetInterval))
+	e.int(int64(p.MaxRetargetStep))
+	e.strings(p.Issuers)
+	e.bytes([]byte(p.DisposalAddress))
+	e.strings(p.Regulators)
+	e.bytes([]byte(p.QuarantineAddress))
+}
+
+// decoder reads a canonical record. The first error sticks; later reads
+// return zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// isCanonical reports whether data starts with the canonical record prefix.
+func isCanonical(data []byte) bool {
+	return len(data) > 0 && data[0] == encodingMarker
+}
+
+func newDecoder(data []byte) *decoder {
+	d := &decoder{data: data}
+
+	prefix := d.next(2)
+	if d.err == nil && prefix[1] != encodingVersion {
+		d.err = fmt.Errorf("unknown encoding version %d", prefix[1])
+	}
+
+	return d
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n > len(d.data) {
+		d.err = errTruncated
+
+		return nil
+	}
+
+	b := d.data[:n]
+	d.data = d.data[n:]
+
+	return b
+}
+
+func (d *decoder) int() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// count reads a length and rejects lengths that cannot fit in the rest of
+// the record, given that each element takes at least min bytes.
+func (d *decoder) count(min int) int {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+
+	n := int(binary.BigEndian.Uint32(b))
+	if n*min > len(d.data) {
+		d.err = errTruncated
+
+		return 0
+	}
+
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count(1)
+	if n == 0 {
+		return nil
+	}
+
+	return append([]byte{}, d.next(n)...)
+}
+
+func (d *decoder) output(version int) TxOutput {
+	out := TxOutput{
+		Value:      int(d.int()),
+		PubKeyHash: d.bytes(),
+	}
+
+	if hasScripts(version) {
+		out.Script = d.bytes()
+	}
+
+	if hasExpiry(version) {
+		out.Expiry = d.int()
+	}
+
+	if hasAssets(version) {
+		out.Asset = d.bytes()
+	}
+
+	return out
+}
+
+func (d *decoder) asset() Asset {
+	return Asset{
+		Product:      string(d.bytes()),
+		Manufacturer: string(d.bytes()),
+		Lot:          string(d.bytes()),
+	}
+}
+
+func (d *decoder) input(scripts bool) TxInput {
+	in := TxInput{
+		ID:        d.bytes(),
+		Out:       int(d.int()),
+		Signature: d.bytes(),
+		PubKey:    d.bytes(),
+	}
+
+	if scripts {
+		in.Script = d.bytes()
+	}
+
+	return in
+}
+
+func (d *decoder) transaction() Transaction {
+	tx := Transaction{
+		Version: int(d.int()),
+		ID:      d.bytes(),
+	}
+
+	scripts := hasScripts(tx.Version)
+
+	if n := d.count(20); n > 0 {
+		tx.Inputs = make([]TxInput, n)
+		for i := range tx.Inputs {
+			tx.Inputs[i] = d.input(scripts)
+		}
+	}
+
+	if n := d.count(12); n > 0 {
+		tx.Outputs = make([]TxOutput, n)
+		for i := range tx.Outputs {
+			tx.Outputs[i] = d.output(tx.Version)
+		}
+	}
+
+	tx.LockTime = d.int()
+
+	if !hasAssets(tx.Version) {
+		return tx
+	}
+
+	if n := d.count(12); n > 0 {
+		tx.Assets = make([]Asset, n)
+		for i := range tx.Assets {
+			tx.Assets[i] = d.asset()
+		}
+	}
+
+	return tx
+}
+
+func (d *decoder) header() BlockHeader {
+	return BlockHeader{
+		Version:    int(d.int()),
+		PrevHash:   d.bytes(),
+		MerkleRoot: d.bytes(),
+		Timestamp:  d.int(),
+		Difficulty: int(d.int()),
+		Nonce:      int(d.int()),
+		Height:     int(d.int()),
+		Signer:     d.bytes(),
+		Signature:  d.bytes(),
+	}
+}
+
+// finish returns the first decoding error, or an error when bytes remain.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errTrailingBytes
+	}
+
+	return d.err
+}
@@ -0,0 +1,129 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestCanonicalEncoding(t *testing.T) {
+	coinbase, err := CoinbaseTx(string(wallet.MakeWallet().Address()), "data")
+	assert.NoError(t, err)
+
+	block := NewBlock([]*Transaction{coinbase}, []byte{1, 2, 3}, 7)
+	block.Hash = block.ComputeHash()
+
+	encoded := block.Serialize()
+	assert.Equal(t, encoded, block.Serialize())
+
+	decoded, err := Deserialize(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, encoded, decoded.Serialize())
+	assert.Equal(t, coinbase.ID, decoded.Transactions[0].ID)
+	assert.Equal(t, TxVersion, decoded.Transactions[0].Version)
+	assert.Equal(t, coinbase.Hash(), decoded.Transactions[0].Hash())
+
+	legacy, err := Deserialize(encodeGob(block))
+	assert.NoError(t, err)
+	assert.Equal(t, encoded, legacy.Serialize())
+
+	_, err = Deserialize(encoded[:len(encoded)-1])
+	assert.ErrorIs(t, err, ErrCorruptData)
+
+	_, err = Deserialize(append(encoded, 0))
+	assert.ErrorIs(t, err, ErrCorruptData)
+}
+
+func TestLegacySignatures(t *testing.T) {
+	from := wallet.MakeWallet()
+	prev, err := CoinbaseTx(string(from.Address()), "")
+	assert.NoError(t, err)
+
+	tx := Transaction{
+		Inputs:  []TxInput{{ID: prev.ID, Out: 0, PubKey: from.PublicKey}},
+		Outputs: []TxOutput{*NewTXOutput(20, string(wallet.MakeWallet().Address()))},
+	}
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): *prev}
+
+	for _, version := range []int{0, 1} {
+		tx.Version = version
+		assert.NoError(t, tx.Sign(from.PrivateKey, prevTXs))
+		assert.True(t, tx.Verify(prevTXs))
+
+		tx.Version = 1 - version
+		assert.False(t, tx.Verify(prevTXs))
+	}
+}
+
+func TestMigrateEncoding(t *testing.T) {
+	from := wallet.MakeWallet()
+
+	params := DefaultChainParams
+	params.InitialDifficulty = params.MinDifficulty
+
+	store := NewMemoryStore()
+	chain, err := InitBlockChain(store, string(from.Address()), params)
+	assert.NoError(t, err)
+
+	coinbase, err := CoinbaseTx(string(from.Address()), "")
+	assert.NoError(t, err)
+
+	_, err = chain.MineBlock(context.Background(), []*Transaction{coinbase})
+	assert.NoError(t, err)
+
+	// write everything back the way older versions stored it
+	err = store.Update(func(txn StoreTxn) error {
+		hashes, err := mainChainHashes(txn)
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			data, _ := txn.Get(hash)
+			block, err := Deserialize(data)
+			if err != nil {
+				return err
+			}
+
+			if err := txn.Set(hash, encodeGob(block)); err != nil {
+				return err
+			}
+			if err := txn.Set(headerKey(hash), encodeGob(&block.BlockHeader)); err != nil {
+				return err
+			}
+		}
+
+		return txn.IteratePrefix(utxoPrefix, func(key, value []byte) error {
+			if isUTXOMeta(key) {
+				return nil
+			}
+			outs, err := DeserializeOutputs(value)
+			if err != nil {
+				return err
+			}
+
+			return txn.Set(key, encodeGob(outs))
+		})
+	})
+	assert.NoError(t, err)
+
+	rewritten, err := MigrateEncoding(store)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, rewritten)
+
+	rewritten, err = MigrateEncoding(store)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rewritten)
+
+	migrated, err := ContinueBlockChain(store)
+	assert.NoError(t, err)
+	assert.Equal(t, chain.LastHash, migrated.LastHash)
+
+	unspent, err := UTXOSet{migrated}.FindUnspentTransactions(wallet.PublicKeyToHash(from.PublicKey), nil)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 2)
+}
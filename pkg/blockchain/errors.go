@@ -0,0 +1,19 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBlockNotFound = errors.New("block not found")
+	ErrTxNotFound    = errors.New("transaction not found")
+	ErrCorruptData   = errors.New("stored or received data is corrupt")
+	ErrNoChain       = errors.New("no existing blockchain found")
+	ErrChainExists   = errors.New("blockchain already exists")
+)
+
+// corrupt wraps a decoding failure as ErrCorruptData.
+func corrupt(what string, err error) error {
+	return fmt.Errorf("%s: %w: %v", what, ErrCorruptData, err)
+}
@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+var (
+	ErrExpiredOutput    = errors.New("expired output must be spent to the disposal address")
+	ErrExpiryExtended   = errors.New("transaction output expires later than its inputs")
+	ErrInvalidExpiry    = errors.New("transaction output expiry is invalid")
+	ErrNothingToDispose = errors.New("wallet has no expired outputs")
+)
+
+// ExpiringOutput is an unspent output that expires.
+type ExpiringOutput struct {
+	TxID   []byte
+	Index  int
+	Value  int
+	Expiry int64
+	Asset  []byte
+}
+
+// IsExpired reports whether out has expired at the Unix time timestamp.
+func (out *TxOutput) IsExpired(timestamp int64) bool {
+	return out.Expiry != 0 && out.Expiry <= timestamp
+}
+
+// inputExpiry returns the earliest expiry of the outputs tx spends from
+// prevTXs, or zero when none expires.
+func (tx *Transaction) inputExpiry(prevTXs map[string]Transaction) int64 {
+	var expiry int64
+
+	for _, in := range tx.Inputs {
+		prevTX, ok := prevTXs[hex.EncodeToString(in.ID)]
+		if !ok || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			continue
+		}
+
+		if e := prevTX.Outputs[in.Out].Expiry; e != 0 && (expiry == 0 || e < expiry) {
+			expiry = e
+		}
+	}
+
+	return expiry
+}
+
+// checkExpiry enforces the expiry of the outputs tx spends from prevTXs, for
+// tx in a block with the given timestamp. When an input has expired, every
+// output must pay the disposal address. Otherwise every output must expire no
+// later than the earliest expiring input, so that expiry carries downstream.
+func (chain *BlockChain) checkExpiry(tx *Transaction, prevTXs map[string]Transaction, timestamp int64) error {
+	if !tx.spendsOutputs() {
+		return nil
+	}
+
+	expiry := tx.inputExpiry(prevTXs)
+	if expiry == 0 {
+		return nil
+	}
+
+	if expiry <= timestamp {
+		disposal := chain.disposalHash()
+
+		for _, out := range tx.Outputs {
+			if disposal == nil || len(out.Script) > 0 || !bytes.Equal(out.PubKeyHash, disposal) {
+				return ErrExpiredOutput
+			}
+		}
+
+		return nil
+	}
+
+	for _, out := range tx.Outputs {
+		if out.Expiry == 0 || out.Expiry > expiry {
+			return ErrExpiryExtended
+		}
+	}
+
+	return nil
+}
+
+// disposalHash returns the public key hash of the disposal address, or nil
+// when the chain has none and expired outputs cannot be spent.
+func (chain *BlockChain) disposalHash() []byte {
+	if chain.Params.DisposalAddress == "" {
+		return nil
+	}
+
+	return addressHash(chain.Params.DisposalAddress)
+}
+
+// NewDisposalTx spends every output of the wallet that has expired at the
+// Unix time now to the disposal address, in one output per asset.
+func NewDisposalTx(w *wallet.Wallet, now int64, UTXO *UTXOSet) (*Transaction, error) {
+	chain := UTXO.Blockchain
+	if chain.Params.DisposalAddress == "" {
+		return nil, fmt.Errorf("%w: the chain has no disposal address", ErrExpiredOutput)
+	}
+
+	outputs, err := UTXO.FindExpiringOutputs(wallet.PublicKeyToHash(w.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	tx := Transaction{Version: TxVersion}
+	totals := make(map[string]int)
+
+	for _, out := range outputs {
+		if out.Expiry > now {
+			continue
+		}
+
+		tx.Inputs = append(tx.Inputs, TxInput{ID: out.TxID, Out: out.Index, PubKey: w.PublicKey})
+
+		if _, ok := totals[string(out.Asset)]; !ok {
+			disposed := NewTXOutput(0, chain.Params.DisposalAddress)
+			disposed.Asset = out.Asset
+			tx.Outputs = append(tx.Outputs, *disposed)
+		}
+		totals[string(out.Asset)] += out.Value
+	}
+
+	if len(tx.Inputs) == 0 {
+		return nil, ErrNothingToDispose
+	}
+
+	for i := range tx.Outputs {
+		tx.Outputs[i].Value = totals[string(tx.Outputs[i].Asset)]
+	}
+	tx.ID = tx.Hash()
+
+	if err := chain.SignTransaction(&tx, w.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// FindExpiringOutputs returns the unspent outputs locked to pubKeyHash that
+// expire, earliest first.
+func (u UTXOSet) FindExpiringOutputs(pubKeyHash []byte) ([]ExpiringOutput, error) {
+	var expiring []ExpiringOutput
+
+	err := u.Blockchain.Database.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(utxoPrefix, func(k, v []byte) error {
+			if isUTXOMeta(k) {
+				return nil
+			}
+
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			for i, out := range outs.Outputs {
+				if out.Expiry != 0 && out.IsLockedWithKey(pubKeyHash) {
+					expiring = append(expiring, ExpiringOutput{
+						TxID:   append([]byte{}, bytes.TrimPrefix(k, utxoPrefix)...),
+						Index:  outs.Index(i),
+						Value:  out.Value,
+						Expiry: out.Expiry,
+						Asset:  out.Asset,
+					})
+				}
+			}
+
+			return nil
+		})
+	})
+
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].Expiry < expiring[j].Expiry
+	})
+
+	return expiring, err
+}
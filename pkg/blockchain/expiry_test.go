@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+	"github.com/swagftw/covax19-blockchain/types"
+)
+
+func TestExpiry(t *testing.T) {
+	issuer := wallet.MakeWallet()
+	clinic := wallet.MakeWallet()
+	citizen := wallet.MakeWallet()
+	disposal := wallet.MakeWallet()
+
+	params := DefaultChainParams
+	params.Issuers = []string{string(issuer.Address())}
+	params.DisposalAddress = string(disposal.Address())
+
+	chain := newTestChain(t, params, issuer)
+
+	expired := time.Now().Add(-time.Hour).Unix()
+	fresh := time.Now().Add(24 * time.Hour).Unix()
+
+	lots, err := NewMintTx(issuer, []Payment{
+		{To: string(clinic.Address()), Amount: 10, Expiry: fresh},
+		{To: string(clinic.Address()), Amount: 5, Expiry: expired},
+	}, "lots-1")
+	assert.NoError(t, err)
+
+	mineBlock(t, chain, issuer, lots)
+
+	utxo := UTXOSet{chain}
+	clinicHash := wallet.PublicKeyToHash(clinic.PublicKey)
+
+	expiring, err := utxo.FindExpiringOutputs(clinicHash)
+	assert.NoError(t, err)
+	assert.Len(t, expiring, 2)
+	assert.Equal(t, expired, expiring[0].Expiry)
+
+	// expired doses are not part of the balance, nor spendable
+	unspent, err := utxo.FindUnspentTransactions(clinicHash, nil)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 1)
+
+	_, err = NewTransaction(clinic, []Payment{{To: string(citizen.Address()), Amount: 11}}, 0, &utxo)
+	assert.ErrorIs(t, err, types.ErrNotEnoughFunds)
+
+	dose, err := NewTransaction(clinic, []Payment{{To: string(citizen.Address()), Amount: 1}}, 0, &utxo)
+	assert.NoError(t, err)
+	for _, out := range dose.Outputs {
+		assert.Equal(t, fresh, out.Expiry)
+	}
+
+	// expiry cannot be dropped downstream
+	extended := *dose
+	extended.Inputs = append([]TxInput{}, dose.Inputs...)
+	extended.Outputs = []TxOutput{*NewTXOutput(10, string(citizen.Address()))}
+	extended.ID = extended.Hash()
+	assert.NoError(t, chain.SignTransaction(&extended, clinic.PrivateKey))
+	assert.ErrorIs(t, chain.VerifyTransaction(&extended), ErrExpiryExtended)
+
+	// expired doses can only go to the disposal address
+	passOn := &Transaction{
+		Version: TxVersion,
+		Inputs:  []TxInput{{ID: lots.ID, Out: 1, PubKey: clinic.PublicKey}},
+		Outputs: []TxOutput{*NewTXOutput(5, string(citizen.Address()))},
+	}
+	passOn.ID = passOn.Hash()
+	assert.NoError(t, chain.SignTransaction(passOn, clinic.PrivateKey))
+	assert.ErrorIs(t, chain.VerifyTransaction(passOn), ErrExpiredOutput)
+
+	disposed, err := NewDisposalTx(clinic, time.Now().Unix(), &utxo)
+	assert.NoError(t, err)
+	assert.NoError(t, chain.VerifyTransaction(disposed))
+
+	mineBlock(t, chain, issuer, dose, disposed)
+
+	_, err = NewDisposalTx(clinic, time.Now().Unix(), &utxo)
+	assert.ErrorIs(t, err, ErrNothingToDispose)
+
+	unspent, err = utxo.FindUnspentTransactions(wallet.PublicKeyToHash(disposal.PublicKey), nil)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 1)
+	assert.Equal(t, 5, unspent[0].Value)
+
+	stored, err := DeserializeOutputs(TxOutputs{Outputs: dose.Outputs}.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, stored.Outputs[0].Expiry)
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
@@ -0,0 +1,296 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// BlockSubsidy is what a coinbase may pay on top of the fees of its block.
+	BlockSubsidy = 20
+	// MaxBlockSize is the largest canonical encoding of a block, in bytes.
+	MaxBlockSize = 1 << 20
+	// DefaultFeeEstimateBlocks is how many recent blocks EstimateFee samples by default.
+	DefaultFeeEstimateBlocks = 10
+
+	// blockReserve is the room AssembleBlock leaves for the header, the seal
+	// and the coinbase.
+	blockReserve = 1024
+)
+
+var ErrInvalidFee = errors.New("transaction fee must not be negative")
+
+// FeeEstimate holds fee rates, in tokens per 1000 bytes of transaction, paid
+// by the transactions of recent blocks.
+type FeeEstimate struct {
+	Blocks       int
+	Transactions int
+	// Low, Medium and High are the 25th, 50th and 90th percentile fee rates.
+	Low    int
+	Medium int
+	High   int
+}
+
+// FeeRate returns fee per 1000 bytes of the canonical encoding of tx.
+func FeeRate(tx *Transaction, fee int) int {
+	return fee * 1000 / len(tx.Serialize())
+}
+
+// Fee returns the fee that a transaction of size bytes pays at rate, rounded up.
+func Fee(rate, size int) int {
+	return (rate*size + 999) / 1000
+}
+
+// PaymentTxSize returns the size of a transaction with the given number of
+// signed inputs and outputs.
+func PaymentTxSize(inputs, outputs int) int {
+	tx := Transaction{Version: TxVersion, ID: make([]byte, 32)}
+
+	for i := 0; i < inputs; i++ {
+		tx.Inputs = append(tx.Inputs, TxInput{ID: make([]byte, 32), Signature: make([]byte, 65), PubKey: make([]byte, 64)})
+	}
+
+	for i := 0; i < outputs; i++ {
+		tx.Outputs = append(tx.Outputs, TxOutput{Value: 1, PubKeyHash: make([]byte, 20)})
+	}
+
+	return len(tx.Serialize())
+}
+
+// TransactionFee returns the fee of tx, whose inputs spend main chain outputs.
+func (chain *BlockChain) TransactionFee(tx *Transaction) (int, error) {
+	if !tx.spendsOutputs() {
+		return 0, nil
+	}
+
+	prevTXs, err := chain.prevTransactions(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	return tx.Fee(prevTXs)
+}
+
+// AssembleBlock picks the transactions of the next block from candidates,
+// highest fee rate first, skipping those that are invalid, conflict with a
+// transaction picked before or do not fit in MaxBlockSize. A candidate that
+// spends the outputs of another is picked once that one is, after it in the
+// block. Freezes and unfreezes go last, so that they cannot invalidate the
+// spends verified before them. It returns the transactions after a coinbase
+// paying the subsidy and their fees to miner, or ErrNoTransactions when no
+// candidate can be mined.
+func (chain *BlockChain) AssembleBlock(candidates []*Transaction, miner string) ([]*Transaction, error) {
+	type candidate struct {
+		tx   *Transaction
+		size int
+		rate int
+	}
+
+	byID := make(map[string]*Transaction)
+	for _, tx := range candidates {
+		byID[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	var valid []candidate
+
+	for _, tx := range candidates {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		// the next block is above the legacy height
+		if tx.Version < MinTxVersion {
+			log.Printf("skipping transaction %x: %v", tx.ID, ErrLegacyTransaction)
+			continue
+		}
+
+		fee, err := chain.candidateFee(tx, byID)
+		if err != nil {
+			log.Printf("skipping transaction %x: %v", tx.ID, err)
+			continue
+		}
+
+		size := len(tx.Serialize())
+		valid = append(valid, candidate{tx: tx, size: size, rate: fee * 1000 / size})
+	}
+
+	sort.Slice(valid, func(i, j int) bool {
+		if ci, cj := valid[i].tx.isControl(), valid[j].tx.isControl(); ci != cj {
+			return cj
+		}
+
+		if valid[i].rate != valid[j].rate {
+			return valid[i].rate > valid[j].rate
+		}
+
+		return bytes.Compare(valid[i].tx.ID, valid[j].tx.ID) < 0
+	})
+
+	var txs []*Transaction
+
+	// inBlock and spent track the transactions picked so far the way
+	// validateTransactions does for a received block
+	inBlock := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	size := blockReserve
+	fees := 0
+	now := time.Now().Unix()
+
+	// waiting reports whether tx spends a candidate that is not picked yet
+	waiting := func(tx *Transaction) bool {
+		for _, in := range tx.Inputs {
+			id := hex.EncodeToString(in.ID)
+			if _, ok := inBlock[id]; !ok && byID[id] != nil {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, controls := range []bool{false, true} {
+		// every pass may pick the parents of candidates left waiting
+		for picked := true; picked; {
+			picked = false
+			left := valid[:0]
+
+			for _, c := range valid {
+				if c.tx.isControl() != controls {
+					left = append(left, c)
+					continue
+				}
+
+				if size+c.size > MaxBlockSize {
+					continue
+				}
+
+				if !c.tx.IsFinal(now) {
+					log.Printf("skipping transaction %x: %v", c.tx.ID, ErrLockTime)
+					continue
+				}
+
+				fee, err := chain.validateTransaction(c.tx, inBlock, spent, now)
+				if err != nil {
+					if waiting(c.tx) {
+						left = append(left, c)
+					} else {
+						log.Printf("skipping transaction: %v", err)
+					}
+
+					continue
+				}
+
+				inBlock[hex.EncodeToString(c.tx.ID)] = *c.tx
+				txs = append(txs, c.tx)
+				size += c.size
+				fees += fee
+				picked = true
+			}
+
+			valid = left
+		}
+	}
+
+	if len(txs) == 0 {
+		return nil, ErrNoTransactions
+	}
+
+	coinbase, err := newCoinbase(miner, "", BlockSubsidy+fees)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]*Transaction{coinbase}, txs...), nil
+}
+
+// candidateFee returns the fee of tx, whose inputs spend main chain outputs
+// or those of the candidates in byID.
+func (chain *BlockChain) candidateFee(tx *Transaction, byID map[string]*Transaction) (int, error) {
+	if !tx.spendsOutputs() {
+		return 0, nil
+	}
+
+	prevTXs := make(map[string]Transaction)
+
+	for _, in := range tx.Inputs {
+		id := hex.EncodeToString(in.ID)
+
+		if parent, ok := byID[id]; ok {
+			prevTXs[id] = *parent
+			continue
+		}
+
+		prevTX, err := chain.FindTransaction(in.ID)
+		if err != nil {
+			return 0, fmt.Errorf("input %x:%d: %w", in.ID, in.Out, err)
+		}
+
+		prevTXs[id] = prevTX
+	}
+
+	return tx.Fee(prevTXs)
+}
+
+// EstimateFee returns the fee rates paid in the last blocks main chain blocks.
+// Coinbases and mints are not counted; rates are zero when no transaction is.
+func (chain *BlockChain) EstimateFee(blocks int) (*FeeEstimate, error) {
+	estimate := &FeeEstimate{}
+
+	var rates []int
+
+	iter := chain.Iterator()
+
+	for estimate.Blocks < blocks {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		estimate.Blocks++
+
+		for _, tx := range block.Transactions {
+			if !tx.spendsOutputs() {
+				continue
+			}
+
+			fee, err := chain.TransactionFee(tx)
+			if err != nil {
+				return nil, err
+			}
+
+			rates = append(rates, FeeRate(tx, fee))
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	estimate.Transactions = len(rates)
+	if len(rates) == 0 {
+		return estimate, nil
+	}
+
+	sort.Ints(rates)
+
+	// nearest-rank percentile
+	percentile := func(p int) int {
+		rank := (len(rates)*p + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+
+		return rates[rank-1]
+	}
+
+	estimate.Low = percentile(25)
+	estimate.Medium = percentile(50)
+	estimate.High = percentile(90)
+
+	return estimate, nil
+}
@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+	"github.com/swagftw/covax19-blockchain/types"
+)
+
+func TestFees(t *testing.T) {
+	a := wallet.MakeWallet()
+	b := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+	miner := string(wallet.MakeWallet().Address())
+
+	chain := newTestChain(t, DefaultChainParams, a)
+
+	mineBlock(t, chain, b)
+
+	utxo := UTXOSet{chain}
+	pay := []Payment{{To: string(to.Address()), Amount: 5}}
+
+	_, err := NewTransaction(a, pay, -1, &utxo)
+	assert.ErrorIs(t, err, ErrInvalidFee)
+
+	_, err = NewTransaction(a, pay, BlockSubsidy, &utxo)
+	assert.ErrorIs(t, err, types.ErrNotEnoughFunds)
+
+	cheap, err := NewTransaction(a, pay, 1, &utxo)
+	assert.NoError(t, err)
+
+	urgent, err := NewTransaction(b, pay, 4, &utxo)
+	assert.NoError(t, err)
+
+	conflicting, err := NewTransaction(a, pay, 0, &utxo)
+	assert.NoError(t, err)
+
+	fee, err := chain.TransactionFee(urgent)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, fee)
+
+	txs, err := chain.AssembleBlock([]*Transaction{cheap, conflicting, urgent}, miner)
+	assert.NoError(t, err)
+	assert.Len(t, txs, 3)
+	assert.Equal(t, BlockSubsidy+5, txs[0].OutputTotal())
+	assert.Equal(t, urgent.ID, txs[1].ID)
+	assert.Equal(t, cheap.ID, txs[2].ID)
+
+	greedy, err := newCoinbase(miner, "", BlockSubsidy+6)
+	assert.NoError(t, err)
+
+	_, err = chain.MineBlock(context.Background(), []*Transaction{greedy, urgent, cheap})
+	assert.ErrorIs(t, err, ErrExcessiveCoinbase)
+
+	_, err = chain.MineBlock(context.Background(), txs)
+	assert.NoError(t, err)
+
+	estimate, err := chain.EstimateFee(DefaultFeeEstimateBlocks)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, estimate.Blocks)
+	assert.Equal(t, 2, estimate.Transactions)
+	assert.Equal(t, FeeRate(cheap, 1), estimate.Low)
+	assert.Equal(t, FeeRate(urgent, 4), estimate.High)
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
+
+func TestAssembleChainedTransactions(t *testing.T) {
+	a := wallet.MakeWallet()
+	b := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+	miner := string(wallet.MakeWallet().Address())
+
+	chain := newTestChain(t, DefaultChainParams, a)
+
+	utxo := UTXOSet{chain}
+	parent, err := NewTransaction(a, []Payment{{To: string(b.Address()), Amount: 5}}, 1, &utxo)
+	assert.NoError(t, err)
+
+	// child spends the output parent pays to b before parent is mined
+	out := -1
+	for i, o := range parent.Outputs {
+		if o.IsLockedWithKey(wallet.PublicKeyToHash(b.PublicKey)) {
+			out = i
+		}
+	}
+
+	child := &Transaction{
+		Version: TxVersion,
+		Inputs:  []TxInput{{ID: parent.ID, Out: out, PubKey: b.PublicKey}},
+		Outputs: []TxOutput{*NewTXOutput(3, string(to.Address()))},
+	}
+	child.ID = child.Hash()
+	assert.NoError(t, child.Sign(b.PrivateKey, map[string]Transaction{hex.EncodeToString(parent.ID): *parent}))
+
+	_, err = chain.AssembleBlock([]*Transaction{child}, miner)
+	assert.ErrorIs(t, err, ErrNoTransactions)
+
+	// child pays the higher fee rate but has to follow its parent
+	txs, err := chain.AssembleBlock([]*Transaction{child, parent}, miner)
+	assert.NoError(t, err)
+	if assert.Len(t, txs, 3) {
+		assert.Equal(t, BlockSubsidy+3, txs[0].OutputTotal())
+		assert.Equal(t, parent.ID, txs[1].ID)
+		assert.Equal(t, child.ID, txs[2].ID)
+	}
+
+	_, err = chain.MineBlock(context.Background(), txs)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,382 @@
+package blockchain
+
+// Freezes and unfreezes of an asset are numbered from zero, in the order they
+// are on the chain, so freezes are even and unfreezes odd and the asset is
+// frozen while their count is odd. Each one signs its number, so that it
+// cannot be replayed once the state has moved on.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+const (
+	// FreezeInput is the Out of the single input of a freeze transaction. The
+	// input ID holds the asset ID followed by the 8 byte big-endian number of
+	// the freeze, and PubKey the regulator's key.
+	FreezeInput = -3
+	// UnfreezeInput is the Out of the single input of an unfreeze transaction.
+	UnfreezeInput = -4
+)
+
+var (
+	ErrNotRegulator    = errors.New("freeze is not signed by a regulator")
+	ErrInvalidControl  = errors.New("freeze transaction is malformed")
+	ErrFrozen          = errors.New("asset is frozen")
+	ErrNotFrozen       = errors.New("asset is not frozen")
+	ErrControlSequence = errors.New("freeze transaction is out of sequence")
+
+	controlsPrefix = []byte("controls-")
+)
+
+func controlsKey(asset []byte) []byte {
+	return append(append([]byte{}, controlsPrefix...), asset...)
+}
+
+// AssetHolder is what one lock, an address or a locking script, holds of an
+// asset.
+type AssetHolder struct {
+	PubKeyHash []byte
+	Script     []byte
+	Value      int
+	Outputs    int
+}
+
+// NewFreezeTx freezes asset, signed by the regulator wallet, after the
+// freezes and unfreezes of the main chain. Outputs of a frozen asset may only
+// be spent to the quarantine address. It returns ErrFrozen when the asset is
+// frozen already.
+func NewFreezeTx(regulator *wallet.Wallet, asset []byte, chain *BlockChain) (*Transaction, error) {
+	return newControlTx(regulator, asset, FreezeInput, chain)
+}
+
+// NewUnfreezeTx lifts the freeze of asset, signed by the regulator wallet. It
+// returns ErrNotFrozen when the asset is not frozen.
+func NewUnfreezeTx(regulator *wallet.Wallet, asset []byte, chain *BlockChain) (*Transaction, error) {
+	return newControlTx(regulator, asset, UnfreezeInput, chain)
+}
+
+func newControlTx(regulator *wallet.Wallet, asset []byte, out int, chain *BlockChain) (*Transaction, error) {
+	controls, err := chain.Controls(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	if frozen := controls%2 == 1; out == FreezeInput && frozen {
+		return nil, ErrFrozen
+	} else if out == UnfreezeInput && !frozen {
+		return nil, ErrNotFrozen
+	}
+
+	id := make([]byte, len(asset)+8)
+	copy(id, asset)
+	binary.BigEndian.PutUint64(id[len(asset):], uint64(controls))
+
+	tx := Transaction{
+		Version:  TxVersion,
+		Inputs:   []TxInput{{ID: id, Out: out, PubKey: regulator.PublicKey}},
+		LockTime: time.Now().Unix(),
+	}
+	tx.ID = tx.Hash()
+
+	if err := tx.SignInput(0, regulator.PrivateKey, tx.Issuer(), SigHashAll); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// IsFreeze reports whether tx freezes an asset.
+func (tx *Transaction) IsFreeze() bool {
+	return len(tx.Inputs) == 1 && tx.Inputs[0].Out == FreezeInput
+}
+
+// IsUnfreeze reports whether tx lifts the freeze of an asset.
+func (tx *Transaction) IsUnfreeze() bool {
+	return len(tx.Inputs) == 1 && tx.Inputs[0].Out == UnfreezeInput
+}
+
+// isControl reports whether tx freezes or unfreezes an asset.
+func (tx *Transaction) isControl() bool {
+	return tx.IsFreeze() || tx.IsUnfreeze()
+}
+
+// ControlledAsset returns the asset a freeze or unfreeze applies to.
+func (tx *Transaction) ControlledAsset() []byte {
+	return tx.Inputs[0].ID[:sha256.Size]
+}
+
+// controlNumber returns the number of a freeze or unfreeze among those of its
+// asset.
+func (tx *Transaction) controlNumber() int64 {
+	return int64(binary.BigEndian.Uint64(tx.Inputs[0].ID[sha256.Size:]))
+}
+
+// checkControl runs the checks of CheckSanity for freezes and unfreezes: a
+// single input naming an asset and a number that is even for freezes and odd
+// for unfreezes, no outputs and no registrations.
+func (tx *Transaction) checkControl() error {
+	if !hasAssets(tx.Version) || len(tx.Outputs) > 0 || len(tx.Assets) > 0 {
+		return ErrInvalidControl
+	}
+
+	if len(tx.Inputs[0].ID) != sha256.Size+8 || tx.controlNumber() < 0 {
+		return ErrInvalidControl
+	}
+
+	if tx.IsFreeze() != (tx.controlNumber()%2 == 0) {
+		return ErrInvalidControl
+	}
+
+	return nil
+}
+
+// IsRegulator reports whether the key with pubKeyHash may sign freezes.
+func (chain *BlockChain) IsRegulator(pubKeyHash []byte) bool {
+	for _, regulator := range chain.Params.Regulators {
+		if wallet.ValidateAddress(regulator) && bytes.Equal(addressHash(regulator), pubKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Controls returns the number of freezes and unfreezes of asset on the main
+// chain, which is odd while it is frozen.
+func (chain *BlockChain) Controls(asset []byte) (int64, error) {
+	var controls int64
+
+	err := chain.Database.View(func(txn StoreTxn) error {
+		var err error
+
+		controls, err = getControls(txn, asset)
+
+		return err
+	})
+
+	return controls, err
+}
+
+// IsFrozen reports whether asset is frozen on the main chain.
+func (chain *BlockChain) IsFrozen(asset []byte) (bool, error) {
+	controls, err := chain.Controls(asset)
+
+	return controls%2 == 1, err
+}
+
+// controls returns the number of freezes and unfreezes of asset after the
+// transactions recorded in spent, on top of the main chain when indexed.
+func (chain *BlockChain) controls(asset []byte, spent map[string]bool, indexed bool) (int64, error) {
+	var controls int64
+
+	if indexed {
+		var err error
+		if controls, err = chain.Controls(asset); err != nil {
+			return 0, err
+		}
+	}
+
+	for spent[fmt.Sprintf("control:%x:%d", asset, controls)] {
+		controls++
+	}
+
+	return controls, nil
+}
+
+// isFrozen reports whether asset is frozen after the transactions recorded in
+// spent, on top of the main chain when indexed.
+func (chain *BlockChain) isFrozen(asset []byte, spent map[string]bool, indexed bool) (bool, error) {
+	controls, err := chain.controls(asset, spent, indexed)
+
+	return controls%2 == 1, err
+}
+
+// validateControl checks a freeze or unfreeze against the regulator set and
+// the assets and freezes of the main chain, when indexed, and of spent, which
+// holds those of the transactions before it, and records it in spent. It must
+// carry the next number of its asset.
+func (chain *BlockChain) validateControl(tx *Transaction, spent map[string]bool, indexed bool) error {
+	if err := tx.Validate(nil); err != nil {
+		return err
+	}
+
+	if !chain.IsRegulator(tx.Issuer()) {
+		return ErrNotRegulator
+	}
+
+	asset := tx.ControlledAsset()
+
+	if !spent[fmt.Sprintf("asset:%x", asset)] {
+		if !indexed {
+			return fmt.Errorf("asset %x: %w", asset, ErrUnknownAsset)
+		}
+
+		if _, err := chain.GetAsset(asset); err != nil {
+			return err
+		}
+	}
+
+	controls, err := chain.controls(asset, spent, indexed)
+	if err != nil {
+		return err
+	}
+
+	if tx.controlNumber() != controls {
+		return ErrControlSequence
+	}
+
+	spent[fmt.Sprintf("control:%x:%d", asset, controls)] = true
+
+	return nil
+}
+
+// checkFrozen rejects spends of outputs of a frozen asset, from prevTXs,
+// unless every output of tx pays the quarantine address.
+func (chain *BlockChain) checkFrozen(tx *Transaction, prevTXs map[string]Transaction, spent map[string]bool, indexed bool) error {
+	if !tx.spendsOutputs() {
+		return nil
+	}
+
+	for _, in := range tx.Inputs {
+		asset := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out].Asset
+		if len(asset) == 0 {
+			continue
+		}
+
+		frozen, err := chain.isFrozen(asset, spent, indexed)
+		if err != nil {
+			return err
+		}
+
+		if frozen && !chain.paysQuarantine(tx) {
+			return fmt.Errorf("asset %x: %w", asset, ErrFrozen)
+		}
+	}
+
+	return nil
+}
+
+// paysQuarantine reports whether every output of tx pays the quarantine
+// address. Without one, frozen outputs cannot be spent.
+func (chain *BlockChain) paysQuarantine(tx *Transaction) bool {
+	if chain.Params.QuarantineAddress == "" {
+		return false
+	}
+
+	quarantine := addressHash(chain.Params.QuarantineAddress)
+
+	for _, out := range tx.Outputs {
+		if len(out.Script) > 0 || !bytes.Equal(out.PubKeyHash, quarantine) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getControls(txn StoreTxn, asset []byte) (int64, error) {
+	val, err := txn.Get(controlsKey(asset))
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(val) != 8 {
+		return 0, corrupt("freeze count", errTruncated)
+	}
+
+	return int64(binary.BigEndian.Uint64(val)), nil
+}
+
+// indexControl adds a freeze or unfreeze, multiplied by sign, to the count of
+// its asset. Counting does not depend on the order blocks are indexed in. It
+// runs with the other main chain indexes.
+func indexControl(txn StoreTxn, tx *Transaction, sign int) error {
+	key := controlsKey(tx.ControlledAsset())
+
+	controls, err := getControls(txn, tx.ControlledAsset())
+	if err != nil {
+		return err
+	}
+
+	controls += int64(sign)
+	if controls == 0 {
+		return txn.Delete(key)
+	}
+
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], uint64(controls))
+
+	return txn.Set(key, val[:])
+}
+
+// FindHolders returns every lock holding unspent outputs of asset, expired or
+// not, largest holding first, so that a recall can reach them all.
+func (u UTXOSet) FindHolders(asset []byte) ([]AssetHolder, error) {
+	holdings := make(map[string]*AssetHolder)
+
+	err := u.Blockchain.Database.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(utxoPrefix, func(k, v []byte) error {
+			if isUTXOMeta(k) {
+				return nil
+			}
+
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			for _, out := range outs.Outputs {
+				if !bytes.Equal(out.Asset, asset) {
+					continue
+				}
+
+				lock := fmt.Sprintf("%x:%x", out.PubKeyHash, out.Script)
+
+				holder, ok := holdings[lock]
+				if !ok {
+					holder = &AssetHolder{PubKeyHash: out.PubKeyHash, Script: out.Script}
+					holdings[lock] = holder
+				}
+
+				holder.Value += out.Value
+				holder.Outputs++
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	holders := make([]AssetHolder, 0, len(holdings))
+	for _, holder := range holdings {
+		holders = append(holders, *holder)
+	}
+
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].Value != holders[j].Value {
+			return holders[i].Value > holders[j].Value
+		}
+
+		if c := bytes.Compare(holders[i].PubKeyHash, holders[j].PubKeyHash); c != 0 {
+			return c < 0
+		}
+
+		return bytes.Compare(holders[i].Script, holders[j].Script) < 0
+	})
+
+	return holders, nil
+}
@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestFreeze(t *testing.T) {
+	maker := wallet.MakeWallet()
+	regulator := wallet.MakeWallet()
+	clinic := wallet.MakeWallet()
+	citizen := wallet.MakeWallet()
+	quarantine := wallet.MakeWallet()
+
+	params := DefaultChainParams
+	params.Issuers = []string{string(maker.Address())}
+	params.Regulators = []string{string(regulator.Address())}
+	params.QuarantineAddress = string(quarantine.Address())
+
+	chain := newTestChain(t, params, maker)
+
+	lotA := Asset{Product: "Covaxin", Manufacturer: "Bharat Biotech", Lot: "A"}
+	lotB := Asset{Product: "Covaxin", Manufacturer: "Bharat Biotech", Lot: "B"}
+	idA := AssetID(wallet.PublicKeyToHash(maker.PublicKey), lotA)
+	idB := AssetID(wallet.PublicKeyToHash(maker.PublicKey), lotB)
+
+	mint, err := NewMintTx(maker, []Payment{
+		{To: string(clinic.Address()), Amount: 10, Asset: idA},
+		{To: string(clinic.Address()), Amount: 10, Asset: idB},
+		{To: string(citizen.Address()), Amount: 2, Asset: idA},
+	}, "order-1", lotA, lotB)
+	assert.NoError(t, err)
+
+	mineBlock(t, chain, maker, mint)
+
+	forged, err := NewFreezeTx(maker, idA, chain)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, chain.VerifyTransaction(forged), ErrNotRegulator)
+
+	unregistered, err := NewFreezeTx(regulator, AssetID(nil, lotA), chain)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, chain.VerifyTransaction(unregistered), ErrUnknownAsset)
+
+	_, err = NewUnfreezeTx(regulator, idA, chain)
+	assert.ErrorIs(t, err, ErrNotFrozen)
+
+	freeze, err := NewFreezeTx(regulator, idA, chain)
+	assert.NoError(t, err)
+	assert.NoError(t, chain.VerifyTransaction(freeze))
+
+	mineBlock(t, chain, maker, freeze)
+
+	frozen, err := chain.IsFrozen(idA)
+	assert.NoError(t, err)
+	assert.True(t, frozen)
+
+	utxo := UTXOSet{chain}
+
+	// the frozen lot only moves to quarantine, the other lot is unaffected
+	recalled, err := NewTransaction(clinic, []Payment{{To: string(citizen.Address()), Amount: 1, Asset: idA}}, 0, &utxo)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, chain.VerifyTransaction(recalled), ErrFrozen)
+
+	other, err := NewTransaction(clinic, []Payment{{To: string(citizen.Address()), Amount: 1, Asset: idB}}, 0, &utxo)
+	assert.NoError(t, err)
+	assert.NoError(t, chain.VerifyTransaction(other))
+
+	returned, err := NewTransaction(clinic, []Payment{{To: string(quarantine.Address()), Amount: 10, Asset: idA}}, 0, &utxo)
+	assert.NoError(t, err)
+	assert.NoError(t, chain.VerifyTransaction(returned))
+
+	holders, err := utxo.FindHolders(idA)
+	assert.NoError(t, err)
+	assert.Len(t, holders, 2)
+	assert.Equal(t, wallet.PublicKeyToHash(clinic.PublicKey), holders[0].PubKeyHash)
+	assert.Equal(t, 10, holders[0].Value)
+
+	mineBlock(t, chain, maker, returned, other)
+
+	unfreeze, err := NewUnfreezeTx(regulator, idA, chain)
+	assert.NoError(t, err)
+	mineBlock(t, chain, maker, unfreeze)
+
+	refreeze, err := NewFreezeTx(regulator, idA, chain)
+	assert.NoError(t, err)
+	mineBlock(t, chain, maker, refreeze)
+
+	// a signed unfreeze cannot be replayed to lift a later freeze, nor can
+	// its number be reused
+	assert.ErrorIs(t, chain.VerifyTransaction(unfreeze), ErrDuplicateTxID)
+
+	stale := *unfreeze
+	stale.Inputs = append([]TxInput{}, unfreeze.Inputs...)
+	stale.LockTime--
+	stale.ID = stale.Hash()
+	assert.NoError(t, stale.SignInput(0, regulator.PrivateKey, stale.Issuer(), SigHashAll))
+	assert.ErrorIs(t, chain.VerifyTransaction(&stale), ErrControlSequence)
+
+	frozen, err = chain.IsFrozen(idA)
+	assert.NoError(t, err)
+	assert.True(t, frozen)
+
+	decoded, err := DeserializeTransaction(refreeze.Serialize())
+	assert.NoError(t, err)
+	assert.True(t, decoded.IsFreeze())
+	assert.Equal(t, idA, decoded.ControlledAsset())
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
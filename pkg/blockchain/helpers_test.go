@@ -0,0 +1,38 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+// newTestChain creates a chain on a memory store at the lowest difficulty,
+// with the genesis reward paid to miner.
+func newTestChain(t *testing.T, params ChainParams, miner *wallet.Wallet) *BlockChain {
+	t.Helper()
+
+	params.InitialDifficulty = params.MinDifficulty
+
+	chain, err := InitBlockChain(NewMemoryStore(), string(miner.Address()), params)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return chain
+}
+
+// mineBlock mines txs on the tip of chain after a coinbase paying miner.
+func mineBlock(t *testing.T, chain *BlockChain, miner *wallet.Wallet, txs ...*Transaction) *Block {
+	t.Helper()
+
+	coinbase, err := CoinbaseTx(string(miner.Address()), "")
+	assert.NoError(t, err)
+
+	block, err := chain.MineBlock(context.Background(), append([]*Transaction{coinbase}, txs...))
+	assert.NoError(t, err)
+
+	return block
+}
@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	heightPrefix  = []byte("hgt-")
+	txIndexPrefix = []byte("txi-")
+	// indexedKey is set once the height and transaction indexes cover the main chain.
+	indexedKey = []byte("indexed")
+)
+
+// TxLocation is the position of a transaction in a main chain block.
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+func heightKey(height int) []byte {
+	key := make([]byte, len(heightPrefix)+8)
+	copy(key, heightPrefix)
+	binary.BigEndian.PutUint64(key[len(heightPrefix):], uint64(height))
+
+	return key
+}
+
+func txIndexKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+// indexBlock records a block that joins the main chain in the height and
+// transaction indexes. It runs in the transaction that moves the tip.
+func indexBlock(txn StoreTxn, block *Block) error {
+	if err := txn.Set(heightKey(block.Height), block.Hash); err != nil {
+		return err
+	}
+
+	for i, tx := range block.Transactions {
+		loc := TxLocation{BlockHash: block.Hash, Position: i}
+		if err := txn.Set(txIndexKey(tx.ID), encodeGob(loc)); err != nil {
+			return err
+		}
+
+		if tx.IsMint() {
+			if err := indexMint(txn, tx, 1); err != nil {
+				return err
+			}
+		}
+
+		if tx.isControl() {
+			if err := indexControl(txn, tx, 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unindexBlock removes a block that leaves the main chain from the indexes.
+func unindexBlock(txn StoreTxn, block *Block) error {
+	if err := txn.Delete(heightKey(block.Height)); err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(txIndexKey(tx.ID)); err != nil {
+			return err
+		}
+
+		if tx.IsMint() {
+			if err := indexMint(txn, tx, -1); err != nil {
+				return err
+			}
+		}
+
+		if tx.isControl() {
+			if err := indexControl(txn, tx, -1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ensureIndexes builds the indexes of chains created before they existed.
+func (chain *BlockChain) ensureIndexes() error {
+	err := chain.Database.View(func(txn StoreTxn) error {
+		_, err := txn.Get(indexedKey)
+
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+	if err != ErrKeyNotFound {
+		return err
+	}
+
+	// supply and freeze counts are not idempotent, start over after an
+	// interruption
+	utxo := UTXOSet{chain}
+	if err := utxo.DeleteByPrefix(supplyPrefix); err != nil {
+		return err
+	}
+
+	if err := utxo.DeleteByPrefix(controlsPrefix); err != nil {
+		return err
+	}
+
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		err = chain.Database.Update(func(txn StoreTxn) error {
+			return indexBlock(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return chain.Database.Update(func(txn StoreTxn) error {
+		return txn.Set(indexedKey, []byte{})
+	})
+}
+
+// GetBlockByHeight returns the main chain block at the given height.
+func (chain *BlockChain) GetBlockByHeight(height int) (*Block, error) {
+	var hash []byte
+
+	err := chain.Database.View(func(txn StoreTxn) error {
+		var err error
+
+		hash, err = txn.Get(heightKey(height))
+
+		return err
+	})
+	if err == ErrKeyNotFound {
+		return nil, fmt.Errorf("height %d: %w", height, ErrBlockNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := chain.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
+// GetBlocksRange returns the main chain blocks from height start to end
+// inclusive, lowest first. The range is clamped to the current chain.
+func (chain *BlockChain) GetBlocksRange(start, end int) ([]*Block, error) {
+	if start < 0 {
+		start = 0
+	}
+
+	best, err := chain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	if end > best {
+		end = best
+	}
+
+	blocks := make([]*Block, 0)
+
+	for height := start; height <= end; height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// lookupTransaction returns the location of a main chain transaction.
+func (chain *BlockChain) lookupTransaction(ID []byte) (*TxLocation, error) {
+	var loc TxLocation
+
+	err := chain.Database.View(func(txn StoreTxn) error {
+		val, err := txn.Get(txIndexKey(ID))
+		if err != nil {
+			return err
+		}
+
+		if err := decodeGob(val, &loc); err != nil {
+			return corrupt("tx index", err)
+		}
+
+		return nil
+	})
+	if err == ErrKeyNotFound {
+		return nil, fmt.Errorf("tx %x: %w", ID, ErrTxNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &loc, nil
+}
@@ -1,12 +1,27 @@
 package blockchain
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
 	"log"
 )
 
+var ErrProofIndex = errors.New("merkle proof index out of range")
+
 type MerkleTree struct {
 	RootNode *MerkleNode
+	// levels holds every level of the tree from the leaves up, each padded to
+	// an even length the way it was hashed.
+	levels [][]MerkleNode
+	leaves int
+}
+
+// MerkleProofStep is one sibling hash on the path from a leaf to the root.
+type MerkleProofStep struct {
+	Hash []byte
+	// Left is true when the sibling is the left child of their parent.
+	Left bool
 }
 
 type MerkleNode struct {
@@ -45,11 +60,15 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		log.Panic("No merkel nodes")
 	}
 
+	var levels [][]MerkleNode
+
 	for len(nodes) > 1 {
 		if len(nodes)%2 != 0 {
 			nodes = append(nodes, nodes[len(nodes)-1])
 		}
 
+		levels = append(levels, nodes)
+
 		var level []MerkleNode
 		for i := 0; i < len(nodes); i += 2 {
 			node := NewMerkleNode(&nodes[i], &nodes[i+1], nil)
@@ -59,7 +78,43 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = level
 	}
 
-	tree := MerkleTree{&nodes[0]}
+	tree := MerkleTree{RootNode: &nodes[0], levels: levels, leaves: len(data)}
 
 	return &tree
 }
+
+// Proof returns the sibling hashes needed to recompute the root from the leaf at index.
+func (t *MerkleTree) Proof(index int) ([]MerkleProofStep, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, ErrProofIndex
+	}
+
+	path := make([]MerkleProofStep, 0, len(t.levels))
+
+	for _, level := range t.levels {
+		sibling := index ^ 1
+		path = append(path, MerkleProofStep{Hash: level[sibling].Data, Left: sibling < index})
+		index /= 2
+	}
+
+	return path, nil
+}
+
+// VerifyProof reports whether leaf, the raw data of a tree leaf, is included
+// under root according to path.
+func VerifyProof(root, leaf []byte, path []MerkleProofStep) bool {
+	hash := sha256.Sum256(leaf)
+	current := hash[:]
+
+	for _, step := range path {
+		if step.Left {
+			hash = sha256.Sum256(append(append([]byte{}, step.Hash...), current...))
+		} else {
+			hash = sha256.Sum256(append(append([]byte{}, current...), step.Hash...))
+		}
+
+		current = hash[:]
+	}
+
+	return bytes.Equal(current, root)
+}
@@ -49,3 +49,24 @@ func TestNewMerkleNode(t *testing.T) {
 	assert.Equal(t, root, fmt.Sprintf("%x", tree.RootNode.Data), "Merkle node root has is equal")
 
 }
+
+func TestMerkleProof(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		var data [][]byte
+		for i := 0; i < size; i++ {
+			data = append(data, []byte(fmt.Sprintf("node%d", i+1)))
+		}
+
+		tree := NewMerkleTree(data)
+
+		for i, leaf := range data {
+			path, err := tree.Proof(i)
+			assert.NoError(t, err)
+			assert.True(t, VerifyProof(tree.RootNode.Data, leaf, path), "leaf %d of %d is proven", i, size)
+			assert.False(t, VerifyProof(tree.RootNode.Data, []byte("forged"), path), "forged leaf is rejected")
+		}
+
+		_, err := tree.Proof(size)
+		assert.ErrorIs(t, err, ErrProofIndex)
+	}
+}
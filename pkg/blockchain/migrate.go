@@ -0,0 +1,241 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// migrateBatch is the number of records rewritten per store transaction.
+const migrateBatch = 1000
+
+// MigrateEncoding rewrites the blocks, headers and UTXO entries in store that
+// still use the legacy gob encoding into the canonical encoding. The main
+// chain is checked to link from the tip back to genesis before and after the
+// rewrite. It returns the number of records rewritten; running it again on a
+// migrated store rewrites nothing.
+func MigrateEncoding(store ChainStore) (int, error) {
+	if err := checkLinks(store); err != nil {
+		return 0, err
+	}
+
+	var blocks, outputs [][]byte
+
+	err := store.View(func(txn StoreTxn) error {
+		hashes, err := mainChainHashes(txn)
+		if err != nil {
+			return err
+		}
+
+		seen := make(map[string]bool)
+		for _, hash := range hashes {
+			seen[string(hash)] = true
+		}
+		blocks = hashes
+
+		// side branch blocks are only reachable through their headers
+		err = txn.IteratePrefix(headerPrefix, func(key, _ []byte) error {
+			hash := bytes.TrimPrefix(key, headerPrefix)
+			if !seen[string(hash)] {
+				seen[string(hash)] = true
+				blocks = append(blocks, hash)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return txn.IteratePrefix(utxoPrefix, func(key, value []byte) error {
+			if !isUTXOMeta(key) && !isCanonical(value) {
+				outputs = append(outputs, key)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten, err := migrateInBatches(store, blocks, migrateBlock)
+	if err != nil {
+		return rewritten, err
+	}
+
+	n, err := migrateInBatches(store, outputs, migrateOutputs)
+	rewritten += n
+	if err != nil {
+		return rewritten, err
+	}
+
+	return rewritten, checkLinks(store)
+}
+
+// migrateInBatches calls migrate for every key, committing every
+// migrateBatch keys, and returns the total number of records rewritten.
+func migrateInBatches(store ChainStore, keys [][]byte, migrate func(txn StoreTxn, key []byte) (int, error)) (int, error) {
+	rewritten := 0
+
+	for start := 0; start < len(keys); start += migrateBatch {
+		end := start + migrateBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		err := store.Update(func(txn StoreTxn) error {
+			for _, key := range keys[start:end] {
+				n, err := migrate(txn, key)
+				if err != nil {
+					return err
+				}
+				rewritten += n
+			}
+
+			return nil
+		})
+		if err != nil {
+			return rewritten, err
+		}
+	}
+
+	return rewritten, nil
+}
+
+func migrateOutputs(txn StoreTxn, key []byte) (int, error) {
+	value, err := txn.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	outs, err := DeserializeOutputs(value)
+	if err != nil {
+		return 0, fmt.Errorf("utxo %x: %w", key, err)
+	}
+
+	return 1, txn.Set(key, outs.Serialize())
+}
+
+// migrateBlock rewrites the block and header records of the block with the
+// given hash, returning how many of them were in the legacy encoding.
+func migrateBlock(txn StoreTxn, hash []byte) (int, error) {
+	data, err := txn.Get(hash)
+	if err == ErrKeyNotFound {
+		// a header whose block was never stored
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+
+	block, err := Deserialize(data)
+	if err != nil {
+		return 0, fmt.Errorf("block %x: %w", hash, err)
+	}
+
+	if !isCanonical(data) {
+		if err := txn.Set(hash, block.Serialize()); err != nil {
+			return 0, err
+		}
+		rewritten++
+	}
+
+	header, err := txn.Get(headerKey(hash))
+	if err != nil && err != ErrKeyNotFound {
+		return 0, err
+	}
+
+	if err == ErrKeyNotFound || !isCanonical(header) {
+		if err := txn.Set(headerKey(hash), block.BlockHeader.Serialize()); err != nil {
+			return 0, err
+		}
+		rewritten++
+	}
+
+	return rewritten, nil
+}
+
+// mainChainHashes returns the hashes of the main chain from the tip down to genesis.
+func mainChainHashes(txn StoreTxn) ([][]byte, error) {
+	hash, err := txn.Get([]byte("lh"))
+	if err == ErrKeyNotFound {
+		return nil, ErrNoChain
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes [][]byte
+
+	for {
+		data, err := txn.Get(hash)
+		if err == ErrKeyNotFound {
+			return nil, fmt.Errorf("block %x: %w", hash, ErrBlockNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := Deserialize(data)
+		if err != nil {
+			return nil, fmt.Errorf("block %x: %w", hash, err)
+		}
+
+		hashes = append(hashes, hash)
+
+		if len(block.PrevHash) == 0 {
+			return hashes, nil
+		}
+		hash = block.PrevHash
+	}
+}
+
+// checkLinks verifies that every main chain block is stored under its own
+// hash, re-encodes to the same record and sits one height above its parent,
+// ending at a genesis block of height 0.
+func checkLinks(store ChainStore) error {
+	return store.View(func(txn StoreTxn) error {
+		hashes, err := mainChainHashes(txn)
+		if err != nil {
+			return err
+		}
+
+		var child *Block
+
+		for _, hash := range hashes {
+			data, err := txn.Get(hash)
+			if err != nil {
+				return err
+			}
+
+			block, err := Deserialize(data)
+			if err != nil {
+				return fmt.Errorf("block %x: %w", hash, err)
+			}
+
+			if !bytes.Equal(block.Hash, hash) {
+				return corrupt(fmt.Sprintf("block %x", hash), errors.New("stored under another hash"))
+			}
+
+			encoded := block.Serialize()
+			if decoded, err := Deserialize(encoded); err != nil || !bytes.Equal(decoded.Serialize(), encoded) {
+				return corrupt(fmt.Sprintf("block %x", hash), errors.New("does not re-encode"))
+			}
+
+			if child != nil && child.Height != block.Height+1 {
+				return corrupt(fmt.Sprintf("block %x", child.Hash), errors.New("height does not follow its parent"))
+			}
+
+			child = block
+		}
+
+		if child.Height != 0 {
+			return corrupt(fmt.Sprintf("block %x", child.Hash), errors.New("chain does not end at genesis"))
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,198 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+var (
+	ErrInvalidReference  = errors.New("mint reference is empty or too long")
+	ErrNotIssuer         = errors.New("mint is not signed by an issuer")
+	ErrDuplicateIssuance = errors.New("issuance reference is already used")
+
+	supplyPrefix   = []byte("supply-")
+	issuancePrefix = []byte("issue-")
+)
+
+// supplyKey is the key of the supply counter of an issuer for one asset. A
+// public key hash has a fixed size, so the asset ID follows it directly.
+func supplyKey(pubKeyHash, asset []byte) []byte {
+	key := append(append([]byte{}, supplyPrefix...), pubKeyHash...)
+
+	return append(key, asset...)
+}
+
+func issuanceKey(reference []byte) []byte {
+	return append(append([]byte{}, issuancePrefix...), reference...)
+}
+
+// addressHash returns the public key hash of a valid address.
+func addressHash(address string) []byte {
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+
+	return pubKeyHash[1 : len(pubKeyHash)-4]
+}
+
+// IsIssuer reports whether the key with pubKeyHash may sign mints.
+func (chain *BlockChain) IsIssuer(pubKeyHash []byte) bool {
+	for _, issuer := range chain.Params.Issuers {
+		if wallet.ValidateAddress(issuer) && bytes.Equal(addressHash(issuer), pubKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IssuedSupply returns the number of units of asset minted on the main chain
+// by the issuer with pubKeyHash. A nil asset is the native token.
+func (chain *BlockChain) IssuedSupply(pubKeyHash, asset []byte) (int, error) {
+	supply := 0
+
+	err := chain.Database.View(func(txn StoreTxn) error {
+		var err error
+
+		supply, err = getSupply(txn, pubKeyHash, asset)
+
+		return err
+	})
+
+	return supply, err
+}
+
+// IssuedSupplies returns what the issuer with pubKeyHash minted on the main
+// chain per asset, the native token first and the others ordered by ID.
+func (chain *BlockChain) IssuedSupplies(pubKeyHash []byte) ([]AssetBalance, error) {
+	var supplies []AssetBalance
+
+	prefix := supplyKey(pubKeyHash, nil)
+
+	err := chain.Database.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(prefix, func(key, value []byte) error {
+			if len(value) != 8 {
+				return corrupt("supply counter", errTruncated)
+			}
+
+			supply := AssetBalance{Value: int(binary.BigEndian.Uint64(value))}
+			if asset := bytes.TrimPrefix(key, prefix); len(asset) > 0 {
+				supply.Asset = asset
+			}
+
+			supplies = append(supplies, supply)
+
+			return nil
+		})
+	})
+
+	return supplies, err
+}
+
+// validateMint checks a mint against the issuer set and the issuances and
+// assets of the main chain and of spent, which holds those of earlier
+// transactions of the block, and records its reference and assets in spent.
+func (chain *BlockChain) validateMint(tx *Transaction, spent map[string]bool) error {
+	if err := tx.Validate(nil); err != nil {
+		return err
+	}
+
+	if !chain.IsIssuer(tx.Issuer()) {
+		return ErrNotIssuer
+	}
+
+	outpoint := fmt.Sprintf("%x:%d", tx.Reference(), MintInput)
+	if spent[outpoint] {
+		return ErrDuplicateIssuance
+	}
+
+	err := chain.Database.View(func(txn StoreTxn) error {
+		_, err := txn.Get(issuanceKey(tx.Reference()))
+
+		return err
+	})
+	if err == nil {
+		return ErrDuplicateIssuance
+	}
+	if err != ErrKeyNotFound {
+		return err
+	}
+
+	if err := chain.validateMintAssets(tx, spent, true); err != nil {
+		return err
+	}
+
+	spent[outpoint] = true
+
+	return nil
+}
+
+func getSupply(txn StoreTxn, pubKeyHash, asset []byte) (int, error) {
+	val, err := txn.Get(supplyKey(pubKeyHash, asset))
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(val) != 8 {
+		return 0, corrupt("supply counter", errTruncated)
+	}
+
+	return int(binary.BigEndian.Uint64(val)), nil
+}
+
+// indexMint records the issuance of tx and the assets it registers, and adds
+// its outputs, multiplied by sign, to the issuer's supply of their asset. It
+// runs with the other main chain indexes.
+func indexMint(txn StoreTxn, tx *Transaction, sign int) error {
+	if err := indexAssets(txn, tx, sign); err != nil {
+		return err
+	}
+
+	if sign > 0 {
+		if err := txn.Set(issuanceKey(tx.Reference()), tx.ID); err != nil {
+			return err
+		}
+	} else if err := txn.Delete(issuanceKey(tx.Reference())); err != nil {
+		return err
+	}
+
+	minted := make(map[string]int)
+	for _, out := range tx.Outputs {
+		minted[string(out.Asset)] += out.Value
+	}
+
+	for asset, value := range minted {
+		key := supplyKey(tx.Issuer(), []byte(asset))
+
+		supply, err := getSupply(txn, tx.Issuer(), []byte(asset))
+		if err != nil {
+			return err
+		}
+
+		supply += sign * value
+
+		// a counter back at zero is removed, so that only assets the issuer
+		// minted are listed
+		if supply == 0 {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		var val [8]byte
+		binary.BigEndian.PutUint64(val[:], uint64(supply))
+
+		if err := txn.Set(key, val[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
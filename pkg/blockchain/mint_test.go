@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestMint(t *testing.T) {
+	issuer := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+
+	params := DefaultChainParams
+	params.Issuers = []string{string(issuer.Address())}
+
+	chain := newTestChain(t, params, issuer)
+
+	_, err := NewMintTx(issuer, []Payment{{To: string(to.Address()), Amount: 100}}, "")
+	assert.ErrorIs(t, err, ErrInvalidReference)
+
+	mint, err := NewMintTx(issuer, []Payment{{To: string(to.Address()), Amount: 100}}, "order-1")
+	assert.NoError(t, err)
+	assert.True(t, mint.IsMint())
+	assert.NoError(t, chain.VerifyTransaction(mint))
+
+	forged, err := NewMintTx(to, []Payment{{To: string(to.Address()), Amount: 100}}, "order-2")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, chain.VerifyTransaction(forged), ErrNotIssuer)
+
+	altered := *mint
+	altered.Outputs = []TxOutput{*NewTXOutput(1000, string(to.Address()))}
+	altered.ID = altered.Hash()
+	assert.ErrorIs(t, chain.VerifyTransaction(&altered), ErrInvalidSignature)
+
+	mineBlock(t, chain, issuer, mint)
+
+	issuerHash := wallet.PublicKeyToHash(issuer.PublicKey)
+
+	supply, err := chain.IssuedSupply(issuerHash, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, supply)
+
+	// each asset of an issuer has its own supply
+	lot := Asset{Product: "Covaxin", Manufacturer: "Bharat Biotech", Lot: "A"}
+	lotID := AssetID(issuerHash, lot)
+
+	lotMint, err := NewMintTx(issuer, []Payment{{To: string(to.Address()), Amount: 20, Asset: lotID}}, "order-lot", lot)
+	assert.NoError(t, err)
+
+	mineBlock(t, chain, issuer, lotMint)
+
+	supply, err = chain.IssuedSupply(issuerHash, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, supply)
+
+	supply, err = chain.IssuedSupply(issuerHash, lotID)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, supply)
+
+	supplies, err := chain.IssuedSupplies(issuerHash)
+	assert.NoError(t, err)
+	assert.Equal(t, []AssetBalance{{Value: 100}, {Asset: lotID, Value: 20}}, supplies)
+
+	unspent, err := UTXOSet{chain}.FindUnspentTransactions(wallet.PublicKeyToHash(to.PublicKey), nil)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 1)
+
+	again, err := NewMintTx(issuer, []Payment{{To: string(to.Address()), Amount: 5}}, "order-1")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, chain.VerifyTransaction(again), ErrDuplicateIssuance)
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
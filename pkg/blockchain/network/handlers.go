@@ -1,10 +1,13 @@
 package network
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
@@ -14,6 +17,69 @@ import (
 	"github.com/swagftw/covax19-blockchain/utl/server/fault"
 )
 
+var (
+	errInvalidAddress = fault.New("ERROR_INVALID_ADDRESS", "address is not valid", http.StatusBadRequest)
+	errInvalidAssetID = fault.New("ERROR_INVALID_ASSET", "asset is not a hex asset ID", http.StatusBadRequest)
+)
+
+// chainError maps blockchain errors caused by the request to 4xx responses.
+func chainError(err error) error {
+	switch {
+	case errors.Is(err, types.ErrNotEnoughFunds):
+		return fault.New("ERROR_NOT_ENOUGH_FUNDS", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrTxNotFound):
+		return fault.New("ERROR_TX_NOT_FOUND", err.Error(), http.StatusNotFound)
+	case errors.Is(err, blockchain2.ErrBlockNotFound):
+		return fault.New("ERROR_BLOCK_NOT_FOUND", err.Error(), http.StatusNotFound)
+	case errors.Is(err, blockchain2.ErrInvalidSignature):
+		return fault.New("ERROR_INVALID_SIGNATURE", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrInvalidOutputValue):
+		return fault.New("ERROR_INVALID_AMOUNT", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrInvalidFee):
+		return fault.New("ERROR_INVALID_FEE", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrDoubleSpend):
+		return fault.New("ERROR_DOUBLE_SPEND", err.Error(), http.StatusConflict)
+	case errors.Is(err, blockchain2.ErrInvalidTxID):
+		return fault.New("ERROR_INVALID_TX_ID", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrDuplicateTxID):
+		return fault.New("ERROR_DUPLICATE_TX", err.Error(), http.StatusConflict)
+	case errors.Is(err, blockchain2.ErrInvalidReference):
+		return fault.New("ERROR_INVALID_REFERENCE", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrNotIssuer):
+		return fault.New("ERROR_NOT_ISSUER", err.Error(), http.StatusForbidden)
+	case errors.Is(err, blockchain2.ErrDuplicateIssuance):
+		return fault.New("ERROR_DUPLICATE_ISSUANCE", err.Error(), http.StatusConflict)
+	case errors.Is(err, blockchain2.ErrExpiredOutput), errors.Is(err, blockchain2.ErrExpiryExtended):
+		return fault.New("ERROR_EXPIRED", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrInvalidExpiry):
+		return fault.New("ERROR_INVALID_EXPIRY", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrNothingToDispose):
+		return fault.New("ERROR_NOTHING_TO_DISPOSE", err.Error(), http.StatusNotFound)
+	case errors.Is(err, blockchain2.ErrUnknownAsset):
+		return fault.New("ERROR_UNKNOWN_ASSET", err.Error(), http.StatusNotFound)
+	case errors.Is(err, blockchain2.ErrInvalidAsset):
+		return fault.New("ERROR_INVALID_ASSET", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrDuplicateAsset):
+		return fault.New("ERROR_DUPLICATE_ASSET", err.Error(), http.StatusConflict)
+	case errors.Is(err, blockchain2.ErrAssetImbalance):
+		return fault.New("ERROR_ASSET_IMBALANCE", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrNotRegulator):
+		return fault.New("ERROR_NOT_REGULATOR", err.Error(), http.StatusForbidden)
+	case errors.Is(err, blockchain2.ErrFrozen):
+		return fault.New("ERROR_FROZEN", err.Error(), http.StatusConflict)
+	case errors.Is(err, blockchain2.ErrNotFrozen):
+		return fault.New("ERROR_NOT_FROZEN", err.Error(), http.StatusConflict)
+	case errors.Is(err, blockchain2.ErrControlSequence):
+		return fault.New("ERROR_FREEZE_SEQUENCE", err.Error(), http.StatusConflict)
+	case errors.Is(err, blockchain2.ErrInvalidControl):
+		return fault.New("ERROR_INVALID_FREEZE", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, blockchain2.ErrTraceTooLong):
+		return fault.New("ERROR_TRACE_TOO_LONG", err.Error(), http.StatusUnprocessableEntity)
+	default:
+		return err
+	}
+}
+
 func (h HTTP) createWallet(c echo.Context) error {
 	wallets, _ := wallet2.CreateWallets()
 	wlt := wallets.AddWallet()
@@ -38,10 +104,17 @@ func (h HTTP) getWallets(c echo.Context) error {
 	})
 }
 
+// getBalance returns the balance of an address in the asset named by the
+// optional asset query parameter, or in the native token.
 func (h HTTP) getBalance(c echo.Context) error {
 	address := c.Param("address")
 	if !wallet2.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
+		return errInvalidAddress
+	}
+
+	asset, err := assetID(c.QueryParam("asset"))
+	if err != nil {
+		return err
 	}
 	chain := h.chain
 	UTXOSet := blockchain2.UTXOSet{Blockchain: chain}
@@ -49,7 +122,10 @@ func (h HTTP) getBalance(c echo.Context) error {
 	balance := 0
 	pubKeyHash := wallet2.Base58Decode([]byte(address))
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
+	UTXOs, err := UTXOSet.FindUnspentTransactions(pubKeyHash, asset)
+	if err != nil {
+		return chainError(err)
+	}
 
 	for _, out := range UTXOs {
 		balance += out.Value
@@ -60,68 +136,600 @@ func (h HTTP) getBalance(c echo.Context) error {
 	})
 }
 
+// getBalances returns the balance of an address in every asset it holds.
+func (h HTTP) getBalances(c echo.Context) error {
+	address := c.Param("address")
+	if !wallet2.ValidateAddress(address) {
+		return errInvalidAddress
+	}
+
+	pubKeyHash := wallet2.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	balances, err := blockchain2.UTXOSet{Blockchain: h.chain}.Balances(pubKeyHash)
+	if err != nil {
+		return chainError(err)
+	}
+
+	resp := make([]*types.AssetBalance, 0, len(balances))
+	for _, balance := range balances {
+		resp = append(resp, &types.AssetBalance{Asset: hex.EncodeToString(balance.Asset), Balance: balance.Value})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"address":  address,
+		"balances": resp,
+	})
+}
+
+// getAsset returns the definition and registration of an asset.
+func (h HTTP) getAsset(c echo.Context) error {
+	id, err := assetID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	if id == nil {
+		return errInvalidAssetID
+	}
+
+	asset, err := h.chain.GetAsset(id)
+	if err != nil {
+		return chainError(err)
+	}
+
+	frozen, err := h.chain.IsFrozen(id)
+	if err != nil {
+		return chainError(err)
+	}
+
+	return c.JSON(http.StatusOK, &types.Asset{
+		ID:           hex.EncodeToString(asset.ID),
+		Issuer:       string(wallet2.HashToAddress(asset.Issuer)),
+		TxID:         hex.EncodeToString(asset.TxID),
+		Product:      asset.Product,
+		Manufacturer: asset.Manufacturer,
+		Lot:          asset.Lot,
+		Frozen:       frozen,
+	})
+}
+
+// getHolders lists every holder of an asset by walking the UTXO set, so that
+// a recall of a frozen lot can reach them all.
+func (h HTTP) getHolders(c echo.Context) error {
+	id, err := assetID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	if id == nil {
+		return errInvalidAssetID
+	}
+
+	if _, err := h.chain.GetAsset(id); err != nil {
+		return chainError(err)
+	}
+
+	frozen, err := h.chain.IsFrozen(id)
+	if err != nil {
+		return chainError(err)
+	}
+
+	holders, err := blockchain2.UTXOSet{Blockchain: h.chain}.FindHolders(id)
+	if err != nil {
+		return chainError(err)
+	}
+
+	resp := &types.AssetHolders{
+		Asset:   hex.EncodeToString(id),
+		Frozen:  frozen,
+		Holders: make([]*types.AssetHolder, 0, len(holders)),
+	}
+
+	for _, holder := range holders {
+		entry := &types.AssetHolder{Amount: holder.Value, Outputs: holder.Outputs}
+		if len(holder.Script) > 0 {
+			entry.Script = hex.EncodeToString(holder.Script)
+		} else {
+			entry.Address = string(wallet2.HashToAddress(holder.PubKeyHash))
+		}
+
+		resp.Total += holder.Value
+		resp.Holders = append(resp.Holders, entry)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// handleFreeze freezes an asset, signed by a regulator wallet held by this
+// node. The request must carry the token of the government user of that wallet.
+func (h HTTP) handleFreeze(c echo.Context) error {
+	return h.control(c, blockchain2.NewFreezeTx)
+}
+
+// handleUnfreeze lifts the freeze of an asset, signed by a regulator wallet
+// held by this node. The request must carry the token of the government user
+// of that wallet.
+func (h HTTP) handleUnfreeze(c echo.Context) error {
+	return h.control(c, blockchain2.NewUnfreezeTx)
+}
+
+func (h HTTP) control(c echo.Context, newTx func(*wallet2.Wallet, []byte, *blockchain2.BlockChain) (*blockchain2.Transaction, error)) error {
+	freezeDTO := new(types.FreezeAsset)
+	if err := c.Bind(freezeDTO); err != nil {
+		return err
+	}
+
+	if !wallet2.ValidateAddress(freezeDTO.Regulator) {
+		return errInvalidAddress
+	}
+
+	if c.Get("type") != string(types.UserTypeGovernment) || c.Get("wallet") != freezeDTO.Regulator {
+		return fault.New("ERROR_NOT_AUTHORISED", "only the government user of the regulator wallet may freeze or unfreeze", http.StatusForbidden)
+	}
+
+	id, err := assetID(freezeDTO.Asset)
+	if err != nil {
+		return err
+	}
+
+	if id == nil {
+		return errInvalidAssetID
+	}
+	chain := h.chain
+
+	wallet, err := nodeWallet(freezeDTO.Regulator)
+	if err != nil {
+		return err
+	}
+
+	tx, err := newTx(wallet, id, chain)
+	if err != nil {
+		return chainError(err)
+	}
+
+	if err := chain.VerifyTransaction(tx); err != nil {
+		return chainError(err)
+	}
+
+	mineInBackground(chain, freezeDTO.Regulator, tx)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Success!",
+		"txId":    hex.EncodeToString(tx.ID),
+	})
+}
+
+// assetID decodes a hex asset ID. The empty string is the native token.
+func assetID(s string) ([]byte, error) {
+	id, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidAssetID
+	}
+
+	if len(id) == 0 {
+		return nil, nil
+	}
+
+	return id, nil
+}
+
 func (h HTTP) handleSend(c echo.Context) error {
 	sendDTO := new(types.SendTokens)
 	if err := c.Bind(sendDTO); err != nil {
 		return err
 	}
 
-	if !wallet2.ValidateAddress(sendDTO.To) {
-		log.Panic("Address is not Valid")
+	payment := &types.Payment{To: sendDTO.To, Amount: sendDTO.Amount, Expiry: sendDTO.Expiry, Asset: sendDTO.Asset}
+	if _, err := h.send(sendDTO.From, []*types.Payment{payment}, sendDTO.Fee); err != nil {
+		return err
+	}
+
+	log.Println("Success!")
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Success!",
+	})
+}
+
+// handleSendBatch makes all the payments in a single transaction.
+func (h HTTP) handleSendBatch(c echo.Context) error {
+	batchDTO := new(types.SendBatch)
+	if err := c.Bind(batchDTO); err != nil {
+		return err
 	}
-	if !wallet2.ValidateAddress(sendDTO.From) {
-		log.Panic("Address is not Valid")
+
+	tx, err := h.send(batchDTO.From, batchDTO.Payments, batchDTO.Fee)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Success!",
+		"txId":    hex.EncodeToString(tx.ID),
+	})
+}
+
+// send pays from a wallet held by this node, leaving fee to the miner, and
+// mines the transaction.
+func (h HTTP) send(from string, payments []*types.Payment, fee int) (*blockchain2.Transaction, error) {
+	if !wallet2.ValidateAddress(from) {
+		return nil, errInvalidAddress
+	}
+
+	outputs, err := toPayments(payments)
+	if err != nil {
+		return nil, err
 	}
 	chain := h.chain
 	UTXOSet := blockchain2.UTXOSet{Blockchain: chain}
 
-	wallets, err := wallet2.CreateWallets()
+	wallet, err := nodeWallet(from)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	wallet := wallets.GetWallet(sendDTO.From)
+	tx, err := blockchain2.NewTransaction(wallet, outputs, fee, &UTXOSet)
+	if err != nil {
+		return nil, chainError(err)
+	}
 
-	wallet2.DeleteWalletLock()
+	mineInBackground(chain, from, tx)
+
+	return tx, nil
+}
+
+// handleMint issues new tokens signed by an issuer wallet held by this node,
+// registering the asset of the request if the issuer has not yet. The request
+// must carry the token of the government user owning the issuer wallet.
+func (h HTTP) handleMint(c echo.Context) error {
+	mintDTO := new(types.MintTokens)
+	if err := c.Bind(mintDTO); err != nil {
+		return err
+	}
+
+	if !wallet2.ValidateAddress(mintDTO.Issuer) {
+		return errInvalidAddress
+	}
+
+	if c.Get("type") != string(types.UserTypeGovernment) || c.Get("wallet") != mintDTO.Issuer {
+		return fault.New("ERROR_NOT_AUTHORISED", "only the government user of the issuer wallet may mint", http.StatusForbidden)
+	}
 
-	tx, err := blockchain2.NewTransaction(wallet, sendDTO.To, sendDTO.Amount, &UTXOSet, sendDTO.SkipBalanceCheck)
+	outputs, err := toPayments(mintDTO.Payments)
 	if err != nil {
-		if err == types.ErrNotEnoughFunds {
-			return fault.New("ERROR_NOT_ENOUGH_FUNDS", err.Error(), http.StatusBadRequest)
+		return err
+	}
+	chain := h.chain
+
+	wallet, err := nodeWallet(mintDTO.Issuer)
+	if err != nil {
+		return err
+	}
+
+	var assets []blockchain2.Asset
+
+	if mintDTO.Asset != nil {
+		asset := blockchain2.Asset{
+			Product:      mintDTO.Asset.Product,
+			Manufacturer: mintDTO.Asset.Manufacturer,
+			Lot:          mintDTO.Asset.Lot,
+		}
+		id := blockchain2.AssetID(wallet2.PublicKeyToHash(wallet.PublicKey), asset)
+
+		_, err := chain.GetAsset(id)
+		if errors.Is(err, blockchain2.ErrUnknownAsset) {
+			assets = append(assets, asset)
+		} else if err != nil {
+			return chainError(err)
+		}
+
+		for i := range outputs {
+			if outputs[i].Asset == nil {
+				outputs[i].Asset = id
+			}
 		}
+	}
+
+	tx, err := blockchain2.NewMintTx(wallet, outputs, mintDTO.Reference, assets...)
+	if err != nil {
+		return chainError(err)
+	}
+
+	if err := chain.VerifyTransaction(tx); err != nil {
+		return chainError(err)
+	}
+
+	mineInBackground(chain, mintDTO.Issuer, tx)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Success!",
+		"txId":    hex.EncodeToString(tx.ID),
+	})
+}
+
+// toPayments checks the recipient addresses and asset IDs of payments.
+func toPayments(payments []*types.Payment) ([]blockchain2.Payment, error) {
+	if len(payments) == 0 {
+		return nil, fault.New("ERROR_NO_PAYMENTS", "at least one payment is required", http.StatusBadRequest)
+	}
+
+	outputs := make([]blockchain2.Payment, 0, len(payments))
+
+	for _, p := range payments {
+		if p == nil || !wallet2.ValidateAddress(p.To) {
+			return nil, errInvalidAddress
+		}
+
+		asset, err := assetID(p.Asset)
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, blockchain2.Payment{To: p.To, Amount: p.Amount, Expiry: p.Expiry, Asset: asset})
+	}
+
+	return outputs, nil
+}
+
+// nodeWallet returns the wallet for address from the wallets of this node.
+func nodeWallet(address string) (*wallet2.Wallet, error) {
+	wallets, err := wallet2.CreateWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	wallet := wallets.GetWallet(address)
+
+	wallet2.DeleteWalletLock()
+
+	if wallet == nil {
+		return nil, fault.New("ERROR_WALLET_NOT_FOUND", "no wallet for the address on this node", http.StatusNotFound)
+	}
+
+	return wallet, nil
+}
 
+// handleDispose spends the expired outputs of a wallet held by this node to
+// the disposal address.
+func (h HTTP) handleDispose(c echo.Context) error {
+	disposeDTO := new(types.DisposeTokens)
+	if err := c.Bind(disposeDTO); err != nil {
 		return err
 	}
 
-	// cbTx := blockchain2.CoinbaseTx(sendDTO.From, "")
-	go func(UTXOSet blockchain2.UTXOSet, tx *blockchain2.Transaction) {
-		memoryPool.mutex.Lock()
-		txs := []*blockchain2.Transaction{tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
-		memoryPool.mutex.Unlock()
-	}(UTXOSet, tx)
+	if !wallet2.ValidateAddress(disposeDTO.From) {
+		return errInvalidAddress
+	}
+	chain := h.chain
+	UTXOSet := blockchain2.UTXOSet{Blockchain: chain}
 
-	log.Println("Success!")
+	wallet, err := nodeWallet(disposeDTO.From)
+	if err != nil {
+		return err
+	}
+
+	tx, err := blockchain2.NewDisposalTx(wallet, time.Now().Unix(), &UTXOSet)
+	if err != nil {
+		return chainError(err)
+	}
+
+	mineInBackground(chain, disposeDTO.From, tx)
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "Success!",
+		"txId":    hex.EncodeToString(tx.ID),
+	})
+}
+
+// getExpiring reports the outputs of an address that have expired or expire
+// within the optional days query parameter, 30 by default.
+func (h HTTP) getExpiring(c echo.Context) error {
+	address := c.Param("address")
+	if !wallet2.ValidateAddress(address) {
+		return errInvalidAddress
+	}
+
+	days, err := countParam(c, "days", 30)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	stock := &types.ExpiringStock{
+		Address: address,
+		Before:  now.AddDate(0, 0, days).Unix(),
+		Outputs: make([]*types.ExpiringOutput, 0),
+	}
+
+	pubKeyHash := wallet2.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	outputs, err := blockchain2.UTXOSet{Blockchain: h.chain}.FindExpiringOutputs(pubKeyHash)
+	if err != nil {
+		return chainError(err)
+	}
+
+	for _, out := range outputs {
+		if out.Expiry >= stock.Before {
+			break
+		}
+
+		expired := out.Expiry <= now.Unix()
+		if expired {
+			stock.Expired += out.Value
+		} else {
+			stock.Expiring += out.Value
+		}
+
+		stock.Outputs = append(stock.Outputs, &types.ExpiringOutput{
+			TxID:    hex.EncodeToString(out.TxID),
+			Index:   out.Index,
+			Amount:  out.Value,
+			Expiry:  out.Expiry,
+			Expired: expired,
+			Asset:   hex.EncodeToString(out.Asset),
+		})
+	}
+
+	return c.JSON(http.StatusOK, stock)
+}
+
+// mineInBackground adds tx to the memory pool and mines the pool the way
+// MineTx does, paying the subsidy and the fees to miner.
+func mineInBackground(chain *blockchain2.BlockChain, miner string, tx *blockchain2.Transaction) {
+	memoryPool.add(*tx)
+
+	go func() {
+		currentMining.running.Lock()
+		defer currentMining.running.Unlock()
+
+		for mineNext(chain, miner) {
+		}
+	}()
+}
+
+// getSupply returns the tokens minted by each issuer of the chain, per asset.
+func (h HTTP) getSupply(c echo.Context) error {
+	resp := make([]*types.IssuerSupply, 0, len(h.chain.Params.Issuers))
+
+	for _, issuer := range h.chain.Params.Issuers {
+		supply, err := h.issuerSupply(issuer)
+		if err != nil {
+			return err
+		}
+
+		resp = append(resp, supply)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"issuers": resp,
 	})
 }
 
+// getIssuerSupply returns the tokens minted by one issuer, per asset.
+func (h HTTP) getIssuerSupply(c echo.Context) error {
+	address := c.Param("address")
+	if !wallet2.ValidateAddress(address) {
+		return errInvalidAddress
+	}
+
+	supply, err := h.issuerSupply(address)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, supply)
+}
+
+func (h HTTP) issuerSupply(address string) (*types.IssuerSupply, error) {
+	pubKeyHash := wallet2.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	if !h.chain.IsIssuer(pubKeyHash) {
+		return nil, fault.New("ERROR_NOT_ISSUER", "address is not an issuer of this chain", http.StatusNotFound)
+	}
+
+	supplies, err := h.chain.IssuedSupplies(pubKeyHash)
+	if err != nil {
+		return nil, chainError(err)
+	}
+
+	resp := &types.IssuerSupply{Issuer: address, Assets: make([]*types.AssetSupply, 0, len(supplies))}
+	for _, supply := range supplies {
+		resp.Assets = append(resp.Assets, &types.AssetSupply{Asset: hex.EncodeToString(supply.Asset), Supply: supply.Value})
+	}
+
+	return resp, nil
+}
+
+// estimateFee suggests fees from the fee rates paid in recent blocks. The
+// blocks query parameter sets how many, inputs and outputs the shape of the
+// transaction to price.
+func (h HTTP) estimateFee(c echo.Context) error {
+	blocks, err := countParam(c, "blocks", blockchain2.DefaultFeeEstimateBlocks)
+	if err != nil {
+		return err
+	}
+
+	inputs, err := countParam(c, "inputs", 1)
+	if err != nil {
+		return err
+	}
+
+	outputs, err := countParam(c, "outputs", 2)
+	if err != nil {
+		return err
+	}
+
+	estimate, err := h.chain.EstimateFee(blocks)
+	if err != nil {
+		return chainError(err)
+	}
+
+	size := blockchain2.PaymentTxSize(inputs, outputs)
+
+	return c.JSON(http.StatusOK, &types.FeeEstimate{
+		Blocks:       estimate.Blocks,
+		Transactions: estimate.Transactions,
+		TxSize:       size,
+		Rates:        &types.FeeLevels{Low: estimate.Low, Medium: estimate.Medium, High: estimate.High},
+		Fees: &types.FeeLevels{
+			Low:    blockchain2.Fee(estimate.Low, size),
+			Medium: blockchain2.Fee(estimate.Medium, size),
+			High:   blockchain2.Fee(estimate.High, size),
+		},
+	})
+}
+
+func countParam(c echo.Context, name string, def int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 || count > 1000 {
+		return 0, fault.New("ERROR_INVALID_COUNT", name+" must be between 1 and 1000", http.StatusBadRequest)
+	}
+
+	return count, nil
+}
+
+// getChain returns the main chain blocks, tip first. The optional start and
+// end query parameters select a range of heights.
 func (h HTTP) getChain(c echo.Context) error {
 	chain := h.chain
-	iter := chain.Iterator()
 
-	resp := make([]*types.Block, 0)
+	start, err := heightParam(c, "start", 0)
+	if err != nil {
+		return err
+	}
+
+	best, err := chain.GetBestHeight()
+	if err != nil {
+		return chainError(err)
+	}
+
+	end, err := heightParam(c, "end", best)
+	if err != nil {
+		return err
+	}
+
+	blocks, err := chain.GetBlocksRange(start, end)
+	if err != nil {
+		return chainError(err)
+	}
+
+	resp := make([]*types.Block, 0, len(blocks))
 
-	for {
-		block := iter.Next()
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
 
 		log.Printf("Hash: %x\n", block.Hash)
 		log.Printf("Prev. hash: %x\n", block.PrevHash)
-		pow := blockchain2.NewProof(block)
-		log.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		sealed := chain.SealIsValid(block)
+		log.Printf("Seal: %s\n", strconv.FormatBool(sealed))
 
 		for _, tx := range block.Transactions {
 			log.Println(tx)
@@ -132,16 +740,195 @@ func (h HTTP) getChain(c echo.Context) error {
 		resp = append(resp, &types.Block{
 			PrevHash:  fmt.Sprintf("%x", block.PrevHash),
 			Hash:      fmt.Sprintf("%x", block.Hash),
-			PoW:       pow.Validate(),
+			PoW:       sealed,
 			Timestamp: block.Timestamp,
 		})
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"blocks": resp,
 	})
 }
+
+func heightParam(c echo.Context, name string, def int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+
+	height, err := strconv.Atoi(value)
+	if err != nil || height < 0 {
+		return 0, fault.New("ERROR_INVALID_HEIGHT", name+" must be a non-negative block height", http.StatusBadRequest)
+	}
+
+	return height, nil
+}
+
+func (h HTTP) getTxProof(c echo.Context) error {
+	txID, err := hex.DecodeString(c.Param("id"))
+	if err != nil {
+		return fault.New("ERROR_INVALID_TX_ID", "transaction id must be hex encoded", http.StatusBadRequest)
+	}
+
+	block, index, err := h.chain.FindTransactionBlock(txID)
+	if err != nil {
+		return chainError(err)
+	}
+
+	path, err := block.TransactionProof(index)
+	if err != nil {
+		return err
+	}
+
+	proof := &types.TxProof{
+		TxID:   c.Param("id"),
+		Index:  index,
+		Leaf:   hex.EncodeToString(block.Transactions[index].Serialize()),
+		Header: toHeaderDTO(block),
+		Path:   make([]*types.MerkleProofStep, 0, len(path)),
+	}
+
+	for _, step := range path {
+		proof.Path = append(proof.Path, &types.MerkleProofStep{Hash: hex.EncodeToString(step.Hash), Left: step.Left})
+	}
+
+	return c.JSON(http.StatusOK, proof)
+}
+
+// getTrace returns the custody chain of output out of a transaction, back to
+// its mint or, with direction=forward, on to its current holders.
+func (h HTTP) getTrace(c echo.Context) error {
+	txID, err := hex.DecodeString(c.Param("id"))
+	if err != nil {
+		return fault.New("ERROR_INVALID_TX_ID", "transaction id must be hex encoded", http.StatusBadRequest)
+	}
+
+	out, err := strconv.Atoi(c.Param("out"))
+	if err != nil || out < 0 {
+		return fault.New("ERROR_INVALID_OUTPUT", "out must be an output index", http.StatusBadRequest)
+	}
+
+	direction := c.QueryParam("direction")
+	if direction == "" {
+		direction = "back"
+	}
+
+	var steps []blockchain2.TraceStep
+
+	switch direction {
+	case "back":
+		steps, err = h.chain.TraceBack(txID, out)
+	case "forward":
+		steps, err = h.chain.TraceForward(txID, out)
+	default:
+		return fault.New("ERROR_INVALID_DIRECTION", "direction must be back or forward", http.StatusBadRequest)
+	}
+	if err != nil {
+		return chainError(err)
+	}
+
+	trace := &types.Trace{
+		TxID:      c.Param("id"),
+		Out:       out,
+		Direction: direction,
+		Steps:     traceSteps(steps),
+	}
+
+	return c.JSON(http.StatusOK, trace)
+}
+
+// getLotTrace returns every output the mints of an asset issued and the
+// outputs its tokens moved to since, on to the current holders of the lot.
+func (h HTTP) getLotTrace(c echo.Context) error {
+	id, err := assetID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	if id == nil {
+		return errInvalidAssetID
+	}
+
+	steps, err := h.chain.TraceLot(id)
+	if err != nil {
+		return chainError(err)
+	}
+
+	trace := &types.Trace{
+		Asset:     hex.EncodeToString(id),
+		Direction: "forward",
+		Steps:     traceSteps(steps),
+	}
+
+	return c.JSON(http.StatusOK, trace)
+}
+
+func traceSteps(steps []blockchain2.TraceStep) []*types.TraceStep {
+	resp := make([]*types.TraceStep, 0, len(steps))
+
+	for _, step := range steps {
+		entry := &types.TraceStep{
+			TxID:      hex.EncodeToString(step.TxID),
+			Out:       step.Out,
+			Amount:    step.Output.Value,
+			Asset:     hex.EncodeToString(step.Output.Asset),
+			Height:    step.Height,
+			Timestamp: step.Timestamp,
+			Origin:    step.Origin,
+			Unspent:   step.Unspent,
+		}
+		if len(step.Output.Script) > 0 {
+			entry.Script = hex.EncodeToString(step.Output.Script)
+		} else {
+			entry.Address = string(wallet2.HashToAddress(step.Output.PubKeyHash))
+		}
+
+		resp = append(resp, entry)
+	}
+
+	return resp
+}
+
+// verifyChain audits the whole chain. A divergence is reported in the body,
+// not as an error status.
+func (h HTTP) verifyChain(c echo.Context) error {
+	report, err := h.chain.VerifyChain()
+	if err != nil {
+		return err
+	}
+
+	resp := &types.ChainReport{
+		Valid:        report.Valid(),
+		Tip:          hex.EncodeToString(report.Tip),
+		Height:       report.Height,
+		Blocks:       report.Blocks,
+		Transactions: report.Transactions,
+		LegacyBlocks: report.LegacyBlocks,
+	}
+
+	if d := report.Divergence; d != nil {
+		resp.Divergence = &types.Divergence{
+			Height:    d.Height,
+			BlockHash: hex.EncodeToString(d.BlockHash),
+			TxID:      hex.EncodeToString(d.TxID),
+			Reason:    d.Err.Error(),
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func toHeaderDTO(block *blockchain2.Block) *types.BlockHeader {
+	return &types.BlockHeader{
+		Version:    block.Version,
+		Hash:       hex.EncodeToString(block.Hash),
+		PrevHash:   hex.EncodeToString(block.PrevHash),
+		MerkleRoot: hex.EncodeToString(block.MerkleRoot),
+		Timestamp:  block.Timestamp,
+		Difficulty: block.Difficulty,
+		Nonce:      block.Nonce,
+		Height:     block.Height,
+		Signer:     hex.EncodeToString(block.Signer),
+		Signature:  hex.EncodeToString(block.Signature),
+	}
+}
@@ -2,8 +2,10 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,12 +15,16 @@ import (
 	"runtime"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/vrecan/death/v3"
 
 	blockchain2 "github.com/swagftw/covax19-blockchain/pkg/blockchain"
+	wallet2 "github.com/swagftw/covax19-blockchain/pkg/wallet"
+	"github.com/swagftw/covax19-blockchain/utl/jwt"
+	middleware2 "github.com/swagftw/covax19-blockchain/utl/middleware"
 	"github.com/swagftw/covax19-blockchain/utl/server/fault"
 )
 
@@ -33,6 +39,17 @@ type memPool struct {
 	mutex        *sync.Mutex
 }
 
+// miningJob tracks the block this node is currently mining so that the work
+// can be abandoned once a peer delivers a block for the same height.
+type miningJob struct {
+	mutex  sync.Mutex
+	height int
+	cancel context.CancelFunc
+	// running is held while a block is mined locally, so that one block is
+	// mined at a time.
+	running sync.Mutex
+}
+
 var (
 	nodeAddress     string
 	mineAddress     string
@@ -42,6 +59,7 @@ var (
 		transactions: make(map[string]blockchain2.Transaction),
 		mutex:        &sync.Mutex{},
 	}
+	currentMining = &miningJob{}
 )
 
 type Addr struct {
@@ -106,6 +124,108 @@ func ExtractCmd(request []byte) []byte {
 	return request[:commandLength]
 }
 
+// applyChainUpdate drops transactions that were mined into the new main chain
+// and returns those of disconnected blocks to the pool so they can be mined again.
+func (pool *memPool) applyChainUpdate(update *blockchain2.ChainUpdate) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for _, block := range update.Disconnected {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				pool.transactions[hex.EncodeToString(tx.ID)] = *tx
+			}
+		}
+	}
+
+	for _, block := range update.Connected {
+		for _, tx := range block.Transactions {
+			delete(pool.transactions, hex.EncodeToString(tx.ID))
+		}
+	}
+}
+
+// add puts tx in the pool and returns the number of pending transactions.
+func (pool *memPool) add(tx blockchain2.Transaction) int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	pool.transactions[hex.EncodeToString(tx.ID)] = tx
+
+	return len(pool.transactions)
+}
+
+// get returns the pending transaction with the hex encoded txID.
+func (pool *memPool) get(txID string) (blockchain2.Transaction, bool) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	tx, ok := pool.transactions[txID]
+
+	return tx, ok
+}
+
+// pending returns a copy of every pending transaction.
+func (pool *memPool) pending() []*blockchain2.Transaction {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	txs := make([]*blockchain2.Transaction, 0, len(pool.transactions))
+
+	for id := range pool.transactions {
+		tx := pool.transactions[id]
+		txs = append(txs, &tx)
+	}
+
+	return txs
+}
+
+// remove drops txs from the pool and returns the number left pending.
+func (pool *memPool) remove(txs []*blockchain2.Transaction) int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for _, tx := range txs {
+		delete(pool.transactions, hex.EncodeToString(tx.ID))
+	}
+
+	return len(pool.transactions)
+}
+
+// start registers a mining job for height and returns its context. The
+// returned func must be called when mining is over.
+func (job *miningJob) start(height int) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job.mutex.Lock()
+	job.height = height
+	job.cancel = cancel
+	job.mutex.Unlock()
+
+	return ctx, func() {
+		job.mutex.Lock()
+		job.cancel = nil
+		job.mutex.Unlock()
+		cancel()
+	}
+}
+
+// abort cancels the running job when the chain reached its height without it.
+func (job *miningJob) abort(height int) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	if job.cancel != nil && job.height <= height {
+		fmt.Printf("Aborting mining of block %d, the chain moved on\n", job.height)
+		job.cancel()
+		job.cancel = nil
+	}
+}
+
+func logMiningProgress(stats blockchain2.MiningStats) {
+	log.Printf("mining block %d: %d hashes in %s (%.0f H/s)", stats.Height, stats.Hashes, stats.Elapsed.Round(time.Second), stats.HashRate)
+}
+
 func RequestBlocks() {
 	for _, node := range KnownNodes {
 		SendGetBlocks(node)
@@ -186,7 +306,11 @@ func SendTx(addr string, tnx *blockchain2.Transaction) {
 }
 
 func SendVersion(addr string, chain *blockchain2.BlockChain) {
-	bestHeight := chain.GetBestHeight()
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		log.Printf("sending version to %s: %v", addr, err)
+		return
+	}
 	payload := GobEncode(Version{version, bestHeight, nodeAddress})
 
 	request := append(CmdToBytes("version"), payload...)
@@ -194,39 +318,46 @@ func SendVersion(addr string, chain *blockchain2.BlockChain) {
 	SendData(addr, request)
 }
 
-func HandleAddr(request []byte) {
-	var buff bytes.Buffer
+func HandleAddr(request []byte) error {
 	var payload Addr
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 
 	KnownNodes = append(KnownNodes, payload.AddrList...)
 	fmt.Printf("there are %d known nodes\n", len(KnownNodes))
 	RequestBlocks()
+
+	return nil
 }
 
-func HandleBlock(request []byte, chain *blockchain2.BlockChain) {
-	var buff bytes.Buffer
+func HandleBlock(request []byte, chain *blockchain2.BlockChain) error {
 	var payload Block
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 
 	blockData := payload.Block
-	block := blockchain2.Deserialize(blockData)
+	block, err := blockchain2.Deserialize(blockData)
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("Recevied a new block!")
-	chain.AddBlock(block)
+	update, err := chain.AddBlock(block)
+	if err != nil {
+		blocksInTransit = [][]byte{}
+
+		return fmt.Errorf("rejected block from %s: %w", payload.AddrFrom, err)
+	}
+
+	memoryPool.applyChainUpdate(update)
+
+	if n := len(update.Connected); n > 0 {
+		currentMining.abort(update.Connected[n-1].Height)
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
 
@@ -235,25 +366,24 @@ func HandleBlock(request []byte, chain *blockchain2.BlockChain) {
 		SendGetData(payload.AddrFrom, "block", blockHash)
 
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain2.UTXOSet{chain}
-		UTXOSet.Reindex()
 	}
+
+	return nil
 }
 
-func HandleInv(request []byte, chain *blockchain2.BlockChain) {
-	var buff bytes.Buffer
+func HandleInv(request []byte, chain *blockchain2.BlockChain) error {
 	var payload Inv
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
+	if len(payload.Items) == 0 {
+		return errors.New("empty inventory")
+	}
+
 	if payload.Type == "block" {
 		blocksInTransit = payload.Items
 
@@ -272,42 +402,47 @@ func HandleInv(request []byte, chain *blockchain2.BlockChain) {
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if memoryPool.transactions[hex.EncodeToString(txID)].ID == nil {
+		if _, ok := memoryPool.get(hex.EncodeToString(txID)); !ok {
 			SendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
+
+	return nil
 }
 
-func HandleGetBlocks(request []byte, chain *blockchain2.BlockChain) {
-	var buff bytes.Buffer
+func HandleGetBlocks(request []byte, chain *blockchain2.BlockChain) error {
 	var payload GetBlocks
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	blocks, err := chain.GetBlockHashes()
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+
+	// peers only accept blocks whose parent they know, so announce genesis first.
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
 	}
 
-	blocks := chain.GetBlockHashes()
 	SendInv(payload.AddrFrom, "block", blocks)
+
+	return nil
 }
 
-func HandleGetData(request []byte, chain *blockchain2.BlockChain) {
-	var buff bytes.Buffer
+func HandleGetData(request []byte, chain *blockchain2.BlockChain) error {
 	var payload GetData
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 
 	if payload.Type == "block" {
 		block, err := chain.GetBlock([]byte(payload.ID))
 		if err != nil {
-			return
+			return err
 		}
 
 		SendBlock(payload.AddrFrom, &block)
@@ -315,28 +450,37 @@ func HandleGetData(request []byte, chain *blockchain2.BlockChain) {
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool.transactions[txID]
+		tx, ok := memoryPool.get(txID)
+		if !ok {
+			return fmt.Errorf("tx %s: %w", txID, blockchain2.ErrTxNotFound)
+		}
 
 		SendTx(payload.AddrFrom, &tx)
 	}
+
+	return nil
 }
 
-func HandleTx(request []byte, chain *blockchain2.BlockChain) {
-	var buff bytes.Buffer
+func HandleTx(request []byte, chain *blockchain2.BlockChain) error {
 	var payload Tx
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 
 	txData := payload.Transaction
-	tx := blockchain2.DeserializeTransaction(txData)
-	memoryPool.transactions[hex.EncodeToString(tx.ID)] = tx
+	tx, err := blockchain2.DeserializeTransaction(txData)
+	if err != nil {
+		return err
+	}
+
+	if tx.Version < blockchain2.MinTxVersion {
+		return fmt.Errorf("tx %x: %w", tx.ID, blockchain2.ErrLegacyTransaction)
+	}
+
+	pending := memoryPool.add(tx)
 
-	fmt.Printf("%s, %d\n", nodeAddress, len(memoryPool.transactions))
+	fmt.Printf("%s, %d\n", nodeAddress, pending)
 
 	if nodeAddress == KnownNodes[0] {
 		for _, node := range KnownNodes {
@@ -345,41 +489,63 @@ func HandleTx(request []byte, chain *blockchain2.BlockChain) {
 			}
 		}
 	} else {
-		if len(memoryPool.transactions) >= 2 && len(mineAddress) > 0 {
+		if pending >= 2 && len(mineAddress) > 0 {
 			MineTx(chain)
 		}
 	}
+
+	return nil
 }
 
+// MineTx mines the pending transactions into blocks, those that pay the
+// highest fee rates first, until none are left or mining fails.
 func MineTx(chain *blockchain2.BlockChain) {
-	var txs []*blockchain2.Transaction
+	currentMining.running.Lock()
+	defer currentMining.running.Unlock()
 
-	for id := range memoryPool.transactions {
-		fmt.Printf("tx: %s\n", memoryPool.transactions[id].ID)
-		tx := memoryPool.transactions[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
+	// transactions that did not fit stay for the next block
+	for mineNext(chain, mineAddress) {
 	}
+}
 
-	if len(txs) == 0 {
+// mineNext mines the pending transactions that pay the highest fee rates and
+// fit in one block, paying the subsidy and the fees to miner. It reports
+// whether transactions are left for another block.
+func mineNext(chain *blockchain2.BlockChain, miner string) bool {
+	txs, err := chain.AssembleBlock(memoryPool.pending(), miner)
+	if errors.Is(err, blockchain2.ErrNoTransactions) {
 		fmt.Println("All Transactions are invalid")
-		return
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Mining failed: %v\n", err)
+		return false
 	}
 
-	cbTx := blockchain2.CoinbaseTx(mineAddress, "")
-	txs = append(txs, cbTx)
-
-	newBlock := chain.MineBlock(txs)
-	UTXOSet := blockchain2.UTXOSet{chain}
-	UTXOSet.Reindex()
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		fmt.Printf("Mining failed: %v\n", err)
+		return false
+	}
 
+	ctx, done := currentMining.start(bestHeight + 1)
+	newBlock, err := chain.MineBlock(ctx, txs)
+	done()
+	if errors.Is(err, context.Canceled) || errors.Is(err, blockchain2.ErrStaleTip) {
+		fmt.Println("Mining aborted, a block for this height arrived first")
+		return false
+	}
+	if errors.Is(err, blockchain2.ErrRecentSigner) {
+		fmt.Println("Not sealing, this authority signed a recent block")
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Mining failed: %v\n", err)
+		return false
+	}
 	fmt.Println("New Block mined")
 
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool.transactions, txID)
-	}
+	pending := memoryPool.remove(txs)
 
 	for _, node := range KnownNodes {
 		if node != nodeAddress {
@@ -387,23 +553,20 @@ func MineTx(chain *blockchain2.BlockChain) {
 		}
 	}
 
-	if len(memoryPool.transactions) > 0 {
-		MineTx(chain)
-	}
+	return pending > 0
 }
 
-func HandleVersion(request []byte, chain *blockchain2.BlockChain) {
-	var buff bytes.Buffer
+func HandleVersion(request []byte, chain *blockchain2.BlockChain) error {
 	var payload Version
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(request, &payload); err != nil {
+		return err
 	}
 
-	bestHeight := chain.GetBestHeight()
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
 	otherHeight := payload.BestHeight
 
 	if bestHeight < otherHeight {
@@ -415,37 +578,60 @@ func HandleVersion(request []byte, chain *blockchain2.BlockChain) {
 	if !NodeIsKnown(payload.AddrFrom) {
 		KnownNodes = append(KnownNodes, payload.AddrFrom)
 	}
+
+	return nil
 }
 
+// HandleConnection dispatches a peer message. Malformed or rejected messages
+// are logged and dropped.
 func HandleConnection(conn net.Conn, chain *blockchain2.BlockChain) {
 	req, err := ioutil.ReadAll(conn)
 	defer conn.Close()
 
 	if err != nil {
-		log.Panic(err)
+		log.Printf("reading from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	if len(req) < commandLength {
+		log.Printf("short message from %s", conn.RemoteAddr())
+		return
 	}
+
 	command := BytesToCmd(req[:commandLength])
 	fmt.Printf("Received %s command\n", command)
 
 	switch command {
 	case "addr":
-		HandleAddr(req)
+		err = HandleAddr(req)
 	case "block":
-		HandleBlock(req, chain)
+		err = HandleBlock(req, chain)
 	case "inv":
-		HandleInv(req, chain)
+		err = HandleInv(req, chain)
 	case "getblocks":
-		HandleGetBlocks(req, chain)
+		err = HandleGetBlocks(req, chain)
 	case "getdata":
-		HandleGetData(req, chain)
+		err = HandleGetData(req, chain)
 	case "tx":
-		HandleTx(req, chain)
+		err = HandleTx(req, chain)
 	case "version":
-		HandleVersion(req, chain)
+		err = HandleVersion(req, chain)
 	default:
 		fmt.Println("Unknown command")
 	}
 
+	if err != nil {
+		log.Printf("%s from %s: %v", command, conn.RemoteAddr(), err)
+	}
+}
+
+// decodePayload decodes the gob payload following the command of a message.
+func decodePayload(request []byte, payload interface{}) error {
+	if err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(payload); err != nil {
+		return fmt.Errorf("decoding payload: %w: %v", blockchain2.ErrCorruptData, err)
+	}
+
+	return nil
 }
 
 type HTTP struct {
@@ -460,10 +646,34 @@ func StartServer(nodeID, minerAddress string) {
 	ech.Use(middleware.Logger(), middleware.Recover())
 	ech.HTTPErrorHandler = fault.ErrorHandler
 
-	chain := blockchain2.ContinueBlockChain()
+	store, err := blockchain2.OpenBadgerStore(blockchain2.DefaultDataDir)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain, err := blockchain2.ContinueBlockChain(store)
+	if err != nil {
+		store.Close()
+		log.Panic(err)
+	}
 	defer chain.Database.Close()
+
+	switch engine := chain.Engine.(type) {
+	case *blockchain2.PoWConsensus:
+		engine.Progress = logMiningProgress
+	case *blockchain2.PoAConsensus:
+		if len(minerAddress) > 0 {
+			authorize(engine, minerAddress)
+		}
+	}
+
 	go CloseDB(chain)
 
+	jwtService, err := jwt.New()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	handler := HTTP{chain: chain, nodeID: nodeID}
 	v1Group := ech.Group("/v1")
 	// v1Group.POST("/cmd", handler.handleCmd)
@@ -473,9 +683,25 @@ func StartServer(nodeID, minerAddress string) {
 	chainGroup.POST("/wallets", handler.createWallet)
 	chainGroup.GET("/wallets", handler.getWallets)
 	chainGroup.GET("/wallets/balance/:address", handler.getBalance)
+	chainGroup.GET("/wallets/expiring/:address", handler.getExpiring)
+	chainGroup.GET("/wallets/assets/:address", handler.getBalances)
+	chainGroup.GET("/assets/:id", handler.getAsset)
+	chainGroup.GET("/assets/:id/holders", handler.getHolders)
+	chainGroup.GET("/assets/:id/trace", handler.getLotTrace)
+	chainGroup.GET("/tx/:id/proof", handler.getTxProof)
+	chainGroup.GET("/tx/:id/trace/:out", handler.getTrace)
+	chainGroup.GET("/verify", handler.verifyChain)
+	chainGroup.GET("/supply", handler.getSupply)
+	chainGroup.GET("/supply/:address", handler.getIssuerSupply)
+	chainGroup.GET("/estimatefee", handler.estimateFee)
 
 	txGroup := v1Group.Group("/transactions")
 	txGroup.POST("/send", handler.handleSend)
+	txGroup.POST("/send-batch", handler.handleSendBatch)
+	txGroup.POST("/mint", handler.handleMint, middleware2.JwtMiddleware(jwtService))
+	txGroup.POST("/dispose", handler.handleDispose)
+	txGroup.POST("/freeze", handler.handleFreeze, middleware2.JwtMiddleware(jwtService))
+	txGroup.POST("/unfreeze", handler.handleUnfreeze, middleware2.JwtMiddleware(jwtService))
 
 	errChan := make(chan error)
 
@@ -491,6 +717,24 @@ func StartServer(nodeID, minerAddress string) {
 	log.Printf("%v", <-errChan)
 }
 
+// authorize lets the node sign blocks with the key of the miner wallet.
+func authorize(engine *blockchain2.PoAConsensus, minerAddress string) {
+	wallets, err := wallet2.CreateWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet2.DeleteWalletLock()
+
+	wlt := wallets.GetWallet(minerAddress)
+	if wlt == nil {
+		log.Panicf("no wallet for authority %s", minerAddress)
+	}
+
+	if err := engine.Authorize(wlt.PrivateKey, wlt.PublicKey); err != nil {
+		log.Panic(err)
+	}
+}
+
 func GobEncode(data interface{}) []byte {
 	var buff bytes.Buffer
 
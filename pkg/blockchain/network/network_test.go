@@ -0,0 +1,220 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	blockchain2 "github.com/swagftw/covax19-blockchain/pkg/blockchain"
+	wallet2 "github.com/swagftw/covax19-blockchain/pkg/wallet"
+	"github.com/swagftw/covax19-blockchain/types"
+	"github.com/swagftw/covax19-blockchain/utl/server/fault"
+)
+
+// newTestNode makes this node a miner paying miner, next to a peer that
+// discards every message, and returns a chain whose genesis pays miner.
+func newTestNode(t *testing.T, params blockchain2.ChainParams, miner string) *blockchain2.BlockChain {
+	listener, err := net.Listen(protocol, "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			_, _ = io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+
+	knownNodes, node, mine := KnownNodes, nodeAddress, mineAddress
+	t.Cleanup(func() {
+		// background mining reads the node addresses until it is done
+		currentMining.running.Lock()
+		currentMining.running.Unlock()
+
+		listener.Close()
+		KnownNodes, nodeAddress, mineAddress = knownNodes, node, mine
+	})
+
+	nodeAddress = "localhost:3001"
+	mineAddress = miner
+	KnownNodes = []string{listener.Addr().String(), nodeAddress}
+
+	params.InitialDifficulty = params.MinDifficulty
+
+	chain, err := blockchain2.InitBlockChain(blockchain2.NewMemoryStore(), miner, params)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return chain
+}
+
+func TestAbortMining(t *testing.T) {
+	miner := string(wallet2.MakeWallet().Address())
+	chain := newTestNode(t, blockchain2.DefaultChainParams, miner)
+
+	parent, err := chain.GetBlockHeader(chain.LastHash)
+	assert.NoError(t, err)
+
+	ctx, done := currentMining.start(parent.Height + 1)
+	defer done()
+
+	// a block at a lower height does not abort the job
+	currentMining.abort(parent.Height)
+	assert.NoError(t, ctx.Err())
+
+	// only a zero hash meets this difficulty, so the job runs until aborted
+	job := &blockchain2.Block{BlockHeader: blockchain2.BlockHeader{Height: parent.Height + 1, Difficulty: 256}}
+	pow, err := blockchain2.NewProof(job)
+	assert.NoError(t, err)
+
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := pow.Run(ctx)
+		result <- err
+	}()
+
+	coinbase, err := blockchain2.CoinbaseTx(miner, "")
+	assert.NoError(t, err)
+
+	block := blockchain2.NewBlock([]*blockchain2.Transaction{coinbase}, chain.LastHash, parent.Height+1)
+	assert.NoError(t, chain.Engine.Prepare(chain, parent, block))
+	assert.NoError(t, chain.Engine.Seal(context.Background(), block))
+
+	request := append(CmdToBytes("block"), GobEncode(Block{KnownNodes[0], block.Serialize()})...)
+	assert.NoError(t, HandleBlock(request, chain))
+	assert.Equal(t, block.Hash, chain.LastHash)
+
+	select {
+	case err := <-result:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(10 * time.Second):
+		t.Fatal("mining was not aborted")
+	}
+}
+
+func TestMineConcurrently(t *testing.T) {
+	issuer := wallet2.MakeWallet()
+	to := string(wallet2.MakeWallet().Address())
+
+	params := blockchain2.DefaultChainParams
+	params.Issuers = []string{string(issuer.Address())}
+
+	chain := newTestNode(t, params, string(issuer.Address()))
+
+	var mints []*blockchain2.Transaction
+	for i := 0; i < 3; i++ {
+		mint, err := blockchain2.NewMintTx(issuer, []blockchain2.Payment{{To: to, Amount: 10}}, fmt.Sprintf("order-%d", i))
+		assert.NoError(t, err)
+
+		mints = append(mints, mint)
+	}
+
+	// a handler mines one mint while peers relay the others, which fill the
+	// pool and get mined as well, and the chain is read throughout
+	mineInBackground(chain, string(issuer.Address()), mints[0])
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			_, err := chain.EstimateFee(10)
+			assert.NoError(t, err)
+		}
+	}()
+
+	for _, mint := range mints[1:] {
+		request := append(CmdToBytes("tx"), GobEncode(Tx{KnownNodes[0], mint.Serialize()})...)
+		assert.NoError(t, HandleTx(request, chain))
+	}
+
+	mined := func() bool {
+		for _, mint := range mints {
+			if _, err := chain.FindTransaction(mint.ID); err != nil {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !mined() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	assert.True(t, mined())
+	assert.Empty(t, memoryPool.pending())
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
+
+func TestControlRequiresRegulatorToken(t *testing.T) {
+	regulator := string(wallet2.MakeWallet().Address())
+	other := string(wallet2.MakeWallet().Address())
+
+	params := blockchain2.DefaultChainParams
+	params.Regulators = []string{regulator}
+
+	handler := HTTP{chain: newTestNode(t, params, regulator)}
+	body := fmt.Sprintf(`{"regulator":%q,"asset":"00"}`, regulator)
+
+	tests := []struct {
+		name     string
+		userType types.UserType
+		wallet   string
+	}{
+		{name: "no token"},
+		{name: "other wallet", userType: types.UserTypeGovernment, wallet: other},
+		{name: "not government", userType: types.UserTypeManufacturer, wallet: regulator},
+	}
+
+	for _, tt := range tests {
+		for _, handle := range []echo.HandlerFunc{handler.handleFreeze, handler.handleUnfreeze} {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			if tt.userType != "" {
+				c.Set("type", string(tt.userType))
+				c.Set("wallet", tt.wallet)
+			}
+
+			err := handle(c)
+			if httpErr, ok := err.(*fault.HTTPError); assert.True(t, ok, tt.name) {
+				assert.Equal(t, http.StatusForbidden, httpErr.StatusCode, tt.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math"
+)
+
+var paramsKey = []byte("params")
+
+// ChainParams are the consensus rules of a network. They are written next to
+// the genesis block so that every node of the chain validates with the same values.
+type ChainParams struct {
+	// Consensus selects the block sealing engine, ConsensusPoW or ConsensusPoA.
+	Consensus string
+	// Authorities are the addresses allowed to sign blocks under proof of authority.
+	Authorities []string
+	// InitialDifficulty is the number of leading zero bits required of the genesis block.
+	InitialDifficulty int
+	MinDifficulty     int
+	MaxDifficulty     int
+	// TargetBlockInterval is the desired number of seconds between blocks.
+	TargetBlockInterval int64
+	// RetargetInterval is the number of blocks between difficulty adjustments.
+	RetargetInterval int
+	// MaxRetargetStep bounds how many bits the difficulty may move per adjustment.
+	MaxRetargetStep int
+	// Issuers are the addresses allowed to sign mint transactions.
+	Issuers []string
+	// DisposalAddress is the only address expired outputs may be spent to.
+	// Without one, expired outputs cannot be spent.
+	DisposalAddress string
+	// Regulators are the addresses allowed to freeze and unfreeze assets.
+	Regulators []string
+	// QuarantineAddress is the only address outputs of a frozen asset may be
+	// spent to. Without one, they cannot be spent while frozen.
+	QuarantineAddress string
+}
+
+var DefaultChainParams = ChainParams{
+	Consensus:           ConsensusPoW,
+	InitialDifficulty:   12,
+	MinDifficulty:       8,
+	MaxDifficulty:       32,
+	TargetBlockInterval: 10,
+	RetargetInterval:    10,
+	MaxRetargetStep:     2,
+}
+
+// hash returns the SHA-256 of the canonical encoding of the parameters.
+func (p ChainParams) hash() []byte {
+	e := newEncoder()
+	e.params(&p)
+	sum := sha256.Sum256(e.buf.Bytes())
+
+	return sum[:]
+}
+
+// genesisCoinbaseData is the data of the genesis coinbase of a chain with
+// params. It commits the genesis block, and so every block hash, to them.
+func genesisCoinbaseData(params ChainParams) string {
+	return fmt.Sprintf("%s %x", genesisData, params.hash())
+}
+
+// genesisCommitsTo reports whether the genesis block of a chain commits to
+// params. Chains created before the genesis block committed to them can only
+// have the default parameters checked.
+func genesisCommitsTo(genesis *Block, params ChainParams) bool {
+	if len(genesis.Transactions) == 0 || !genesis.Transactions[0].IsCoinbase() {
+		return false
+	}
+
+	if !committedGenesis(genesis) {
+		return bytes.Equal(params.hash(), DefaultChainParams.hash())
+	}
+
+	return string(genesis.Transactions[0].Inputs[0].PubKey) == genesisCoinbaseData(params)
+}
+
+// committedGenesis reports whether the coinbase of genesis, which must have
+// one first, carries more than the genesis data of chains created before
+// parameters were committed to.
+func committedGenesis(genesis *Block) bool {
+	return string(genesis.Transactions[0].Inputs[0].PubKey) != genesisData
+}
+
+func loadParams(txn StoreTxn) (ChainParams, error) {
+	val, err := txn.Get(paramsKey)
+	if err == ErrKeyNotFound {
+		return DefaultChainParams, nil
+	}
+	if err != nil {
+		return ChainParams{}, err
+	}
+
+	var params ChainParams
+	err = decodeGob(val, &params)
+
+	return params, err
+}
+
+// NextDifficulty returns the difficulty a block following parent must carry.
+// Every RetargetInterval blocks it moves the difficulty towards the target
+// block interval, based on the timestamps of the last RetargetInterval blocks.
+func (chain *BlockChain) NextDifficulty(parent *BlockHeader) (int, error) {
+	params := chain.Params
+	height := parent.Height + 1
+
+	if params.RetargetInterval <= 1 || height%params.RetargetInterval != 0 {
+		return parent.Difficulty, nil
+	}
+
+	first := parent
+	for i := 0; i < params.RetargetInterval-1; i++ {
+		prev, err := chain.GetBlockHeader(first.PrevHash)
+		if err != nil {
+			return 0, ErrOrphanBlock
+		}
+
+		first = prev
+	}
+
+	actual := parent.Timestamp - first.Timestamp
+	if actual < 1 {
+		actual = 1
+	}
+
+	expected := params.TargetBlockInterval * int64(params.RetargetInterval-1)
+
+	// Each bit of difficulty doubles the expected mining time.
+	step := int(math.Round(math.Log2(float64(expected) / float64(actual))))
+	if step > params.MaxRetargetStep {
+		step = params.MaxRetargetStep
+	}
+	if step < -params.MaxRetargetStep {
+		step = -params.MaxRetargetStep
+	}
+
+	difficulty := parent.Difficulty + step
+	if difficulty < params.MinDifficulty {
+		difficulty = params.MinDifficulty
+	}
+	if difficulty > params.MaxDifficulty {
+		difficulty = params.MaxDifficulty
+	}
+
+	return difficulty, nil
+}
@@ -0,0 +1,66 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextDifficulty(t *testing.T) {
+	params := DefaultChainParams
+	params.RetargetInterval = 4
+
+	// expected is 30 seconds over the three intervals of a retarget window
+	tests := []struct {
+		name       string
+		height     int
+		difficulty int
+		spacing    int64
+		want       int
+	}{
+		{name: "on target", height: 3, difficulty: 12, spacing: 10, want: 12},
+		{name: "twice as fast", height: 3, difficulty: 12, spacing: 5, want: 13},
+		{name: "twice as slow", height: 3, difficulty: 12, spacing: 20, want: 11},
+		{name: "not a boundary", height: 4, difficulty: 12, spacing: 1, want: 12},
+		{name: "step clamped up", height: 3, difficulty: 12, spacing: 1, want: 14},
+		{name: "step clamped down", height: 3, difficulty: 12, spacing: 1000, want: 10},
+		{name: "min difficulty", height: 7, difficulty: 9, spacing: 1000, want: params.MinDifficulty},
+		{name: "max difficulty", height: 7, difficulty: 31, spacing: 1, want: params.MaxDifficulty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := &BlockChain{Database: NewMemoryStore(), Params: params}
+
+			// store the headers up to the parent, spacing seconds apart
+			var parent *BlockHeader
+			err := chain.Database.Update(func(txn StoreTxn) error {
+				var prevHash []byte
+				for height := 0; height <= tt.height; height++ {
+					parent = &BlockHeader{
+						PrevHash:   prevHash,
+						Timestamp:  int64(height) * tt.spacing,
+						Difficulty: tt.difficulty,
+						Height:     height,
+					}
+					prevHash = parent.ComputeHash()
+
+					if err := txn.Set(headerKey(prevHash), parent.Serialize()); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+			assert.NoError(t, err)
+
+			difficulty, err := chain.NextDifficulty(parent)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, difficulty)
+		})
+	}
+
+	chain := &BlockChain{Database: NewMemoryStore(), Params: params}
+	_, err := chain.NextDifficulty(&BlockHeader{PrevHash: []byte("missing"), Height: 3})
+	assert.ErrorIs(t, err, ErrOrphanBlock)
+}
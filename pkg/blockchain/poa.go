@@ -0,0 +1,237 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+var (
+	ErrNoAuthorities = errors.New("proof of authority needs at least one authority")
+	ErrNotAuthority  = errors.New("key is not a block authority")
+	ErrInvalidSeal   = errors.New("block seal signature is invalid")
+	ErrRecentSigner  = errors.New("authority signed one of the recent blocks")
+	ErrOutOfTurnSoon = errors.New("out of turn block is too close to its parent")
+)
+
+// PoAConsensus is the proof of authority engine. Blocks are signed by a fixed
+// set of authorities taking turns by height. An authority may sign out of
+// turn, for example when the in-turn authority is offline, but only
+// TargetBlockInterval seconds after the parent, and such blocks weigh less in
+// fork choice. No authority may sign two of any len(Authorities)/2+1
+// consecutive blocks.
+type PoAConsensus struct {
+	// Authorities are the public key hashes of the signers, in signing order.
+	Authorities [][]byte
+
+	signer    *ecdsa.PrivateKey
+	signerPub []byte
+}
+
+// NewPoAConsensus returns an engine for the authorities with the given addresses.
+func NewPoAConsensus(addresses []string) (*PoAConsensus, error) {
+	if len(addresses) == 0 {
+		return nil, ErrNoAuthorities
+	}
+
+	engine := &PoAConsensus{}
+
+	for _, address := range addresses {
+		if !wallet.ValidateAddress(address) {
+			return nil, fmt.Errorf("authority %q: invalid address", address)
+		}
+
+		pubKeyHash := wallet.Base58Decode([]byte(address))
+		engine.Authorities = append(engine.Authorities, pubKeyHash[1:len(pubKeyHash)-4])
+	}
+
+	return engine, nil
+}
+
+// Authorize makes the node seal blocks with the given authority key.
+func (e *PoAConsensus) Authorize(privKey ecdsa.PrivateKey, pubKey []byte) error {
+	if e.authorityIndex(wallet.PublicKeyToHash(pubKey)) < 0 {
+		return ErrNotAuthority
+	}
+
+	e.signer = &privKey
+	e.signerPub = pubKey
+
+	return nil
+}
+
+// Prepare marks block as signed by this node. The genesis block is unsigned.
+func (e *PoAConsensus) Prepare(chain *BlockChain, parent *BlockHeader, block *Block) error {
+	block.Difficulty = 0
+
+	if parent == nil {
+		return nil
+	}
+
+	if e.signer == nil {
+		return ErrNotAuthority
+	}
+
+	signer := wallet.PublicKeyToHash(e.signerPub)
+	if err := e.checkRecent(chain, parent, signer); err != nil {
+		return err
+	}
+
+	// out of turn, leave the in-turn authority its interval
+	if !bytes.Equal(signer, e.ExpectedSigner(parent)) {
+		if earliest := parent.Timestamp + chain.Params.TargetBlockInterval; block.Timestamp < earliest {
+			block.Timestamp = earliest
+		}
+	}
+
+	block.Signer = e.signerPub
+
+	return nil
+}
+
+// Seal signs block, first waiting for its timestamp when Prepare moved it
+// ahead.
+func (e *PoAConsensus) Seal(ctx context.Context, block *Block) error {
+	if wait := time.Until(time.Unix(block.Timestamp, 0)); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	block.Hash = block.ComputeHash()
+
+	if len(block.Signer) == 0 {
+		return nil
+	}
+
+	if e.signer == nil || !bytes.Equal(block.Signer, e.signerPub) {
+		return ErrNotAuthority
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, e.signer, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	block.Signature = signature
+
+	return nil
+}
+
+func (e *PoAConsensus) VerifySeal(chain *BlockChain, parent *BlockHeader, block *Block) error {
+	if !bytes.Equal(block.Hash, block.ComputeHash()) {
+		return ErrInvalidHash
+	}
+
+	if block.Difficulty != 0 {
+		return ErrInvalidDifficulty
+	}
+
+	if parent == nil {
+		return nil
+	}
+
+	if err := e.verifySignature(block); err != nil {
+		return err
+	}
+
+	signer := wallet.PublicKeyToHash(block.Signer)
+	if err := e.checkRecent(chain, parent, signer); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(signer, e.ExpectedSigner(parent)) && block.Timestamp < parent.Timestamp+chain.Params.TargetBlockInterval {
+		return ErrOutOfTurnSoon
+	}
+
+	return nil
+}
+
+// verifySignature checks that an authority signed the hash of block.
+func (e *PoAConsensus) verifySignature(block *Block) error {
+	if e.authorityIndex(wallet.PublicKeyToHash(block.Signer)) < 0 {
+		return ErrNotAuthority
+	}
+
+	if len(block.Signature) != 64 || len(block.Signer) == 0 {
+		return ErrInvalidSeal
+	}
+
+	keyLen := len(block.Signer)
+	pubKey := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(block.Signer[:keyLen/2]),
+		Y:     new(big.Int).SetBytes(block.Signer[keyLen/2:]),
+	}
+	r := new(big.Int).SetBytes(block.Signature[:32])
+	s := new(big.Int).SetBytes(block.Signature[32:])
+
+	if !ecdsa.Verify(&pubKey, block.Hash, r, s) {
+		return ErrInvalidSeal
+	}
+
+	return nil
+}
+
+// checkRecent rejects signer for the child of parent when it signed one of
+// the last len(Authorities)/2 blocks up to parent.
+func (e *PoAConsensus) checkRecent(chain *BlockChain, parent *BlockHeader, signer []byte) error {
+	header := parent
+
+	for i := len(e.Authorities) / 2; i > 0 && len(header.Signer) > 0; i-- {
+		if bytes.Equal(wallet.PublicKeyToHash(header.Signer), signer) {
+			return ErrRecentSigner
+		}
+
+		if i == 1 {
+			break
+		}
+
+		var err error
+		if header, err = chain.GetBlockHeader(header.PrevHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExpectedSigner returns the authority whose turn it is to sign the child of parent.
+func (e *PoAConsensus) ExpectedSigner(parent *BlockHeader) []byte {
+	return e.Authorities[(parent.Height+1)%len(e.Authorities)]
+}
+
+// Work is 2 for blocks signed in turn and 1 otherwise.
+func (e *PoAConsensus) Work(header *BlockHeader) *big.Int {
+	if len(header.Signer) > 0 {
+		inTurn := e.Authorities[header.Height%len(e.Authorities)]
+		if bytes.Equal(wallet.PublicKeyToHash(header.Signer), inTurn) {
+			return big.NewInt(2)
+		}
+	}
+
+	return big.NewInt(1)
+}
+
+func (e *PoAConsensus) authorityIndex(pubKeyHash []byte) int {
+	for i, authority := range e.Authorities {
+		if bytes.Equal(authority, pubKeyHash) {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestPoARoundRobin(t *testing.T) {
+	authorities := []*wallet.Wallet{wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()}
+
+	params := DefaultChainParams
+	params.Consensus = ConsensusPoA
+	params.TargetBlockInterval = 1
+	for _, authority := range authorities {
+		params.Authorities = append(params.Authorities, string(authority.Address()))
+	}
+
+	chain := newTestChain(t, params, authorities[0])
+	engine := chain.Engine.(*PoAConsensus)
+
+	// sealed signs a block on the tip by signer at timestamp, bypassing Prepare
+	sealed := func(signer *wallet.Wallet, timestamp int64) *Block {
+		parent, err := chain.GetBlockHeader(chain.LastHash)
+		assert.NoError(t, err)
+
+		coinbase, err := CoinbaseTx(string(signer.Address()), "")
+		assert.NoError(t, err)
+
+		block := NewBlock([]*Transaction{coinbase}, chain.LastHash, parent.Height+1)
+		block.Timestamp = timestamp
+		block.Signer = signer.PublicKey
+
+		assert.NoError(t, engine.Authorize(signer.PrivateKey, signer.PublicKey))
+		assert.NoError(t, engine.Seal(context.Background(), block))
+
+		return block
+	}
+
+	// height 1 is the turn of the second authority
+	assert.NoError(t, engine.Authorize(authorities[1].PrivateKey, authorities[1].PublicKey))
+	first := mineBlock(t, chain, authorities[1])
+	assert.Equal(t, int64(2), engine.Work(&first.BlockHeader).Int64())
+
+	// it may not sign the next block too, in turn or not
+	coinbase, err := CoinbaseTx(string(authorities[1].Address()), "")
+	assert.NoError(t, err)
+
+	_, err = chain.MineBlock(context.Background(), []*Transaction{coinbase})
+	assert.ErrorIs(t, err, ErrRecentSigner)
+	assert.ErrorIs(t, chain.ValidateBlock(sealed(authorities[1], first.Timestamp+1)), ErrRecentSigner)
+
+	// out of turn, the first authority must leave the third its interval
+	assert.ErrorIs(t, chain.ValidateBlock(sealed(authorities[0], first.Timestamp)), ErrOutOfTurnSoon)
+
+	assert.NoError(t, engine.Authorize(authorities[0].PrivateKey, authorities[0].PublicKey))
+	outOfTurn := mineBlock(t, chain, authorities[0])
+	assert.GreaterOrEqual(t, outOfTurn.Timestamp, first.Timestamp+params.TargetBlockInterval)
+	assert.Equal(t, int64(1), engine.Work(&outOfTurn.BlockHeader).Int64())
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
@@ -2,76 +2,175 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
 	"log"
-	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const Difficulty = 12
+const (
+	// hashBatch is the number of nonces a worker tries between cancellation checks.
+	hashBatch        = 1 << 12
+	progressInterval = time.Second
+)
+
+// MiningStats describes the progress of a running proof of work.
+type MiningStats struct {
+	Height   int
+	Hashes   uint64
+	Elapsed  time.Duration
+	HashRate float64
+}
+
+// ProgressFunc receives mining progress reports.
+type ProgressFunc func(MiningStats)
 
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
+	// Progress is called about once a second while Run is searching, if set.
+	Progress ProgressFunc
 }
 
-func NewProof(b *Block) *ProofOfWork {
+// NewProof returns the proof of work of b. It returns ErrInvalidDifficulty
+// when the difficulty of b is not a number of bits of a hash.
+func NewProof(b *Block) (*ProofOfWork, error) {
+	if b.Difficulty < 0 || b.Difficulty > 256 {
+		return nil, ErrInvalidDifficulty
+	}
+
 	target := big.NewInt(1)
 	target.Lsh(target, uint(256-b.Difficulty))
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{Block: b, Target: target}
 
-	return pow
+	return pow, nil
 }
 
+// InitData returns the header bytes hashed for the given nonce. The Merkle
+// root comes from the header, so trying a nonce does not touch the transactions.
 func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			ToHex(int64(nonce)),
-			ToHex(int64(pow.Block.Difficulty)),
-		},
-		[]byte{},
+	return pow.Block.hashData(nonce)
+}
+
+// Run searches the nonce space on every CPU until a hash below the target is
+// found. It returns ctx.Err() if ctx is done before that.
+func (pow *ProofOfWork) Run(ctx context.Context) (int, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		nonce int
+		hash  []byte
+	}
+
+	workers := runtime.NumCPU()
+	found := make(chan result, 1)
+
+	var (
+		hashes uint64
+		wg     sync.WaitGroup
 	)
 
-	return data
-}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var intHash big.Int
-	var hash [32]byte
+		go func(start int) {
+			defer wg.Done()
 
-	nonce := 0
+			var intHash big.Int
 
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
+			for nonce := start; nonce >= 0; {
+				for i := 0; i < hashBatch && nonce >= 0; i++ {
+					hash := sha256.Sum256(pow.InitData(nonce))
+					intHash.SetBytes(hash[:])
 
-		fmt.Printf("\r%x", hash)
-		intHash.SetBytes(hash[:])
+					if intHash.Cmp(pow.Target) == -1 {
+						select {
+						case found <- result{nonce, hash[:]}:
+						default:
+						}
+						cancel()
 
-		if intHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
-		}
+						return
+					}
 
+					nonce += workers
+				}
+
+				atomic.AddUint64(&hashes, hashBatch)
+
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}(w)
+	}
+
+	stopProgress := pow.reportProgress(ctx, &hashes)
+
+	wg.Wait()
+	stopProgress()
+
+	select {
+	case res := <-found:
+		return res.nonce, res.hash, nil
+	default:
+		return 0, nil, ctx.Err()
+	}
+}
+
+// reportProgress calls pow.Progress until ctx is done or the returned func is called.
+func (pow *ProofOfWork) reportProgress(ctx context.Context, hashes *uint64) func() {
+	if pow.Progress == nil {
+		return func() {}
 	}
-	fmt.Println()
 
-	return nonce, hash[:]
+	done := make(chan struct{})
+	started := time.Now()
+	ticker := time.NewTicker(progressInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				elapsed := time.Since(started)
+				count := atomic.LoadUint64(hashes)
+
+				pow.Progress(MiningStats{
+					Height:   pow.Block.Height,
+					Hashes:   count,
+					Elapsed:  elapsed,
+					HashRate: float64(count) / elapsed.Seconds(),
+				})
+			}
+		}
+	}()
+
+	return func() { close(done) }
 }
 
 func (pow *ProofOfWork) Validate() bool {
-	var intHash big.Int
+	hash := sha256.Sum256(pow.InitData(pow.Block.Nonce))
 
-	data := pow.InitData(pow.Block.Nonce)
+	return pow.meetsTarget(hash[:])
+}
 
-	hash := sha256.Sum256(data)
-	intHash.SetBytes(hash[:])
+// meetsTarget reports whether hash is below the target.
+func (pow *ProofOfWork) meetsTarget(hash []byte) bool {
+	var intHash big.Int
+	intHash.SetBytes(hash)
 
 	return intHash.Cmp(pow.Target) == -1
 }
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestProofOfWork(t *testing.T) {
+	coinbase, err := CoinbaseTx(string(wallet.MakeWallet().Address()), "")
+	assert.NoError(t, err)
+
+	block := NewBlock([]*Transaction{coinbase}, []byte("parent"), 1)
+	block.Difficulty = 8
+
+	pow, err := NewProof(block)
+	assert.NoError(t, err)
+
+	nonce, hash, err := pow.Run(context.Background())
+	assert.NoError(t, err)
+
+	block.Nonce = nonce
+	assert.True(t, pow.Validate())
+	assert.Equal(t, block.ComputeHash(), hash)
+
+	// only a zero hash meets this difficulty, so these runs stop through ctx
+	block.Difficulty = 256
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pow, err = NewProof(block)
+	assert.NoError(t, err)
+
+	_, _, err = pow.Run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	var stats MiningStats
+
+	pow, err = NewProof(block)
+	assert.NoError(t, err)
+
+	pow.Progress = func(s MiningStats) {
+		stats = s
+		cancel()
+	}
+
+	_, _, err = pow.Run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, stats.Height)
+	assert.NotZero(t, stats.Hashes)
+	assert.Greater(t, stats.HashRate, 0.0)
+
+	block.Difficulty = 257
+	_, err = NewProof(block)
+	assert.ErrorIs(t, err, ErrInvalidDifficulty)
+}
+
+func TestOutOfRangeDifficulty(t *testing.T) {
+	miner := wallet.MakeWallet()
+	chain := newTestChain(t, DefaultChainParams, miner)
+
+	parent, err := chain.GetBlockHeader(chain.LastHash)
+	assert.NoError(t, err)
+
+	for _, difficulty := range []int{-1, 257, 1000} {
+		coinbase, err := CoinbaseTx(string(miner.Address()), "")
+		assert.NoError(t, err)
+
+		block := NewBlock([]*Transaction{coinbase}, chain.LastHash, parent.Height+1)
+		block.Difficulty = difficulty
+		block.Hash = block.ComputeHash()
+
+		_, err = chain.AddBlock(block)
+		assert.ErrorIs(t, err, ErrInvalidDifficulty)
+	}
+
+	height, err := chain.GetBestHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, height)
+}
@@ -0,0 +1,290 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/big"
+)
+
+var (
+	workPrefix   = []byte("work-")
+	headerPrefix = []byte("hdr-")
+)
+
+// ChainUpdate describes how the main chain moved when a block was added.
+// Disconnected lists the old blocks from the old tip down to the fork point and
+// Connected lists the new blocks from the fork point up to the new tip.
+type ChainUpdate struct {
+	Disconnected []*Block
+	Connected    []*Block
+}
+
+func workKey(blockHash []byte) []byte {
+	return append(append([]byte{}, workPrefix...), blockHash...)
+}
+
+func headerKey(blockHash []byte) []byte {
+	return append(append([]byte{}, headerPrefix...), blockHash...)
+}
+
+func storeBlock(txn StoreTxn, block *Block, work *big.Int) error {
+	if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+		return err
+	}
+
+	if err := txn.Set(headerKey(block.Hash), block.BlockHeader.Serialize()); err != nil {
+		return err
+	}
+
+	return txn.Set(workKey(block.Hash), work.Bytes())
+}
+
+// chainWork returns the cumulative work of the chain ending at blockHash.
+// Work missing for blocks stored by older versions is recomputed and saved.
+func (chain *BlockChain) chainWork(blockHash []byte) (*big.Int, error) {
+	// the stored hash of legacy blocks is not their ComputeHash, so work is
+	// saved under the hash the walk found them by
+	type missingWork struct {
+		hash   []byte
+		header *BlockHeader
+	}
+
+	var missing []missingWork
+
+	work := new(big.Int)
+	hash := blockHash
+
+	for {
+		var stored []byte
+
+		err := chain.Database.View(func(txn StoreTxn) error {
+			var err error
+
+			stored, err = txn.Get(workKey(hash))
+
+			return err
+		})
+		if err == nil {
+			work.SetBytes(stored)
+
+			break
+		}
+		if err != ErrKeyNotFound {
+			return nil, err
+		}
+
+		header, err := chain.GetBlockHeader(hash)
+		if err != nil {
+			return nil, ErrOrphanBlock
+		}
+
+		missing = append(missing, missingWork{hash: hash, header: header})
+
+		if len(header.PrevHash) == 0 {
+			break
+		}
+		hash = header.PrevHash
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		entry := missing[i]
+		work = new(big.Int).Add(work, chain.Engine.Work(entry.header))
+
+		err := chain.Database.Update(func(txn StoreTxn) error {
+			return txn.Set(workKey(entry.hash), work.Bytes())
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return work, nil
+}
+
+// connectBlock makes block, whose parent must be the current tip, the new tip.
+func (chain *BlockChain) connectBlock(block *Block) error {
+	parentWork, err := chain.chainWork(block.PrevHash)
+	if err != nil {
+		return err
+	}
+
+	work := new(big.Int).Add(parentWork, chain.Engine.Work(&block.BlockHeader))
+
+	err = chain.Database.Update(func(txn StoreTxn) error {
+		if err := storeBlock(txn, block, work); err != nil {
+			return err
+		}
+
+		if err := connectUTXO(txn, block); err != nil {
+			return err
+		}
+
+		if err := indexBlock(txn, block); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	chain.LastHash = block.Hash
+
+	return nil
+}
+
+// disconnectBlock removes block, which must be the current tip, from the main chain.
+func (chain *BlockChain) disconnectBlock(block *Block) error {
+	err := chain.Database.Update(func(txn StoreTxn) error {
+		if err := disconnectUTXO(txn, block); err != nil {
+			return err
+		}
+
+		if err := unindexBlock(txn, block); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), block.PrevHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	chain.LastHash = block.PrevHash
+
+	return nil
+}
+
+// reorganize switches the main chain to the branch ending at newTip. If a block
+// of that branch turns out to be invalid, the old chain is restored and the
+// invalid block and its descendants are discarded.
+func (chain *BlockChain) reorganize(newTip *Block) (*ChainUpdate, error) {
+	oldTip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return nil, err
+	}
+
+	detach, attach, err := chain.findFork(&oldTip, newTip)
+	if err != nil {
+		return nil, err
+	}
+
+	update := &ChainUpdate{}
+
+	for _, block := range detach {
+		if err := chain.disconnectBlock(block); err != nil {
+			return nil, chain.rollback(update, err)
+		}
+
+		update.Disconnected = append(update.Disconnected, block)
+	}
+
+	for i := len(attach) - 1; i >= 0; i-- {
+		block := attach[i]
+
+		err := chain.validateTransactions(block)
+		if err == nil {
+			err = chain.connectBlock(block)
+		}
+
+		if err != nil {
+			err = chain.rollback(update, err)
+			if discardErr := chain.discardBlocks(attach[:i+1]); discardErr != nil {
+				log.Printf("discarding invalid branch: %v", discardErr)
+			}
+
+			return nil, err
+		}
+
+		update.Connected = append(update.Connected, block)
+	}
+
+	return update, nil
+}
+
+// findFork walks both branches back to their common ancestor. Both returned
+// slices are ordered from the branch tip down to the block after the fork.
+func (chain *BlockChain) findFork(oldTip, newTip *Block) ([]*Block, []*Block, error) {
+	var detach, attach []*Block
+
+	parent := func(block *Block) (*Block, error) {
+		prev, err := chain.GetBlock(block.PrevHash)
+		if err != nil {
+			return nil, ErrOrphanBlock
+		}
+
+		return &prev, nil
+	}
+
+	a, b := oldTip, newTip
+
+	var err error
+
+	for a.Height > b.Height {
+		detach = append(detach, a)
+		if a, err = parent(a); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for b.Height > a.Height {
+		attach = append(attach, b)
+		if b, err = parent(b); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for !bytes.Equal(a.Hash, b.Hash) {
+		detach = append(detach, a)
+		attach = append(attach, b)
+
+		if a, err = parent(a); err != nil {
+			return nil, nil, err
+		}
+		if b, err = parent(b); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return detach, attach, nil
+}
+
+// rollback undoes a partially applied reorganization that failed with cause.
+// It returns cause, annotated when the old chain could not be restored.
+func (chain *BlockChain) rollback(update *ChainUpdate, cause error) error {
+	for i := len(update.Connected) - 1; i >= 0; i-- {
+		if err := chain.disconnectBlock(update.Connected[i]); err != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", cause, err)
+		}
+	}
+
+	for i := len(update.Disconnected) - 1; i >= 0; i-- {
+		if err := chain.connectBlock(update.Disconnected[i]); err != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", cause, err)
+		}
+	}
+
+	return cause
+}
+
+func (chain *BlockChain) discardBlocks(blocks []*Block) error {
+	return chain.Database.Update(func(txn StoreTxn) error {
+		for _, block := range blocks {
+			if err := txn.Delete(block.Hash); err != nil {
+				return err
+			}
+
+			if err := txn.Delete(headerKey(block.Hash)); err != nil {
+				return err
+			}
+
+			if err := txn.Delete(workKey(block.Hash)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,118 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestReorganize(t *testing.T) {
+	from := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+	other := wallet.MakeWallet()
+	miner := string(wallet.MakeWallet().Address())
+
+	chain := newTestChain(t, DefaultChainParams, from)
+	utxo := UTXOSet{chain}
+
+	genesis, err := chain.GetBlock(chain.LastHash)
+	assert.NoError(t, err)
+
+	balance := func(w *wallet.Wallet) int {
+		unspent, err := utxo.FindUnspentTransactions(wallet.PublicKeyToHash(w.PublicKey), nil)
+		assert.NoError(t, err)
+
+		total := 0
+		for _, out := range unspent {
+			total += out.Value
+		}
+
+		return total
+	}
+
+	coinbase := func(value int) *Transaction {
+		tx, err := newCoinbase(miner, "", value)
+		assert.NoError(t, err)
+
+		return tx
+	}
+
+	// sealed seals a block of txs on parent without connecting it, as a peer would
+	sealed := func(parent *Block, txs ...*Transaction) *Block {
+		block := NewBlock(txs, parent.Hash, parent.Height+1)
+		assert.NoError(t, chain.Engine.Prepare(chain, &parent.BlockHeader, block))
+		assert.NoError(t, chain.Engine.Seal(context.Background(), block))
+
+		return block
+	}
+
+	// both spend the genesis output
+	toTx, err := NewTransaction(from, []Payment{{To: string(to.Address()), Amount: 5}}, 0, &utxo)
+	assert.NoError(t, err)
+	otherTx, err := NewTransaction(from, []Payment{{To: string(other.Address()), Amount: 7}}, 0, &utxo)
+	assert.NoError(t, err)
+
+	a1 := sealed(&genesis, coinbase(BlockSubsidy), toTx)
+	update, err := chain.AddBlock(a1)
+	assert.NoError(t, err)
+	assert.Len(t, update.Connected, 1)
+	assert.Equal(t, 5, balance(to))
+
+	// a branch of equal work does not replace the tip
+	b1 := sealed(&genesis, coinbase(BlockSubsidy))
+	update, err = chain.AddBlock(b1)
+	assert.NoError(t, err)
+	assert.Empty(t, update.Connected)
+	assert.Equal(t, a1.Hash, chain.LastHash)
+
+	// a heavier one does, undoing toTx
+	b2 := sealed(b1, coinbase(BlockSubsidy), otherTx)
+	update, err = chain.AddBlock(b2)
+	assert.NoError(t, err)
+	assert.Len(t, update.Disconnected, 1)
+	assert.Len(t, update.Connected, 2)
+	assert.Equal(t, b2.Hash, chain.LastHash)
+
+	assert.Equal(t, 0, balance(to))
+	assert.Equal(t, 7, balance(other))
+	assert.Equal(t, BlockSubsidy-7, balance(from))
+
+	unspent, err := utxo.IsUnspent(a1.Transactions[0].ID, 0)
+	assert.NoError(t, err)
+	assert.False(t, unspent)
+
+	_, err = chain.FindTransaction(toTx.ID)
+	assert.ErrorIs(t, err, ErrTxNotFound)
+
+	meta, ok, err := utxo.Meta()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, b2.Hash, meta.Tip)
+
+	// the old branch grows heavier with an invalid block: the reorganization
+	// fails on it and the chain goes back to b2
+	a2 := sealed(a1, coinbase(BlockSubsidy))
+	_, err = chain.AddBlock(a2)
+	assert.NoError(t, err)
+	assert.Equal(t, b2.Hash, chain.LastHash)
+
+	a3 := sealed(a2, coinbase(BlockSubsidy+1))
+	_, err = chain.AddBlock(a3)
+	assert.ErrorIs(t, err, ErrExcessiveCoinbase)
+	assert.Equal(t, b2.Hash, chain.LastHash)
+
+	_, err = chain.GetBlock(a3.Hash)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+
+	assert.Equal(t, 0, balance(to))
+	assert.Equal(t, 7, balance(other))
+	assert.Equal(t, BlockSubsidy-7, balance(from))
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+	assert.Equal(t, 2, report.Height)
+}
@@ -0,0 +1,427 @@
+package blockchain
+
+// Outputs may be locked by a script for a small stack machine. An input
+// spends such an output when its unlocking script, which may only push data,
+// followed by the locking script leaves a true value, any non-zero byte, on
+// top of the stack. Outputs without a script are locked by the template
+//
+//	OP_DUP OP_HASH160 <PubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+//
+// and unlocked by the input's Signature and PubKey.
+//
+// Numbers are big-endian and unsigned, at most 8 bytes. Signatures are in the
+// format described in sighash.go and commit to the locking script, or just
+// PubKeyHash for the template. OP_CHECKMULTISIG takes its signatures in the
+// order of the keys they match. OP_CHECKLOCKTIMEVERIFY leaves its operand on
+// the stack and fails unless the transaction's LockTime is at least that Unix
+// time; blocks only include transactions whose LockTime has passed.
+//
+// Evaluation is bounded by MaxScriptSize, maxScriptOps and maxStackSize, and
+// has no loops.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+const (
+	Op0                   = 0x00
+	OpPushData1           = 0x4c
+	Op1                   = 0x51
+	Op16                  = 0x60
+	OpVerify              = 0x69
+	OpDrop                = 0x75
+	OpDup                 = 0x76
+	OpEqual               = 0x87
+	OpEqualVerify         = 0x88
+	OpSHA256              = 0xa8
+	OpHash160             = 0xa9
+	OpCheckSig            = 0xac
+	OpCheckSigVerify      = 0xad
+	OpCheckMultiSig       = 0xae
+	OpCheckLockTimeVerify = 0xb1
+)
+
+const (
+	// MaxScriptSize bounds locking and unlocking scripts, in bytes.
+	MaxScriptSize = 1024
+	// MaxMultiSigKeys bounds the keys of an OP_CHECKMULTISIG.
+	MaxMultiSigKeys = 16
+
+	maxStackSize = 100
+	maxScriptOps = 200
+)
+
+var (
+	ErrInvalidScript = errors.New("transaction script is malformed")
+	ErrScriptFailed  = errors.New("transaction script failed")
+)
+
+// P2PKHScript returns the locking script of the pay-to-pubkey-hash template.
+func P2PKHScript(pubKeyHash []byte) []byte {
+	script := []byte{OpDup, OpHash160}
+	script = append(script, PushData(pubKeyHash)...)
+
+	return append(script, OpEqualVerify, OpCheckSig)
+}
+
+// MultiSigScript returns a script locking an output to any m of pubKeys.
+func MultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	if m < 1 || m > len(pubKeys) || len(pubKeys) > MaxMultiSigKeys {
+		return nil, fmt.Errorf("%w: %d of %d keys", ErrInvalidScript, m, len(pubKeys))
+	}
+
+	script := []byte{byte(Op1 + m - 1)}
+	script = append(script, PushData(pubKeys...)...)
+
+	return append(script, byte(Op1+len(pubKeys)-1), OpCheckMultiSig), nil
+}
+
+// TimeLockScript returns a script locking an output to pubKeyHash until the
+// Unix time lockTime.
+func TimeLockScript(lockTime int64, pubKeyHash []byte) []byte {
+	script := PushData(scriptNum(lockTime))
+	script = append(script, OpCheckLockTimeVerify, OpDrop)
+
+	return append(script, P2PKHScript(pubKeyHash)...)
+}
+
+// PushData returns a script pushing each of data, at most 255 bytes long, for
+// example an unlocking script.
+func PushData(data ...[]byte) []byte {
+	var script []byte
+
+	for _, d := range data {
+		if len(d) < OpPushData1 {
+			script = append(script, byte(len(d)))
+		} else {
+			script = append(script, OpPushData1, byte(len(d)))
+		}
+
+		script = append(script, d...)
+	}
+
+	return script
+}
+
+// scriptNum returns the shortest encoding of a non-negative number.
+func scriptNum(n int64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(n))
+
+	return bytes.TrimLeft(b[:], "\x00")
+}
+
+// scriptEngine runs the scripts of input index of tx. subscript is what the
+// signatures commit to.
+type scriptEngine struct {
+	tx        *Transaction
+	index     int
+	subscript []byte
+	stack     [][]byte
+	ops       int
+}
+
+// verify checks that unlocking and then locking leave a true value.
+func (vm *scriptEngine) verify(unlocking, locking []byte) error {
+	if err := vm.run(unlocking, true); err != nil {
+		return err
+	}
+
+	if err := vm.run(locking, false); err != nil {
+		return err
+	}
+
+	if len(vm.stack) == 0 || !scriptBool(vm.stack[len(vm.stack)-1]) {
+		return scriptError("script left false on the stack")
+	}
+
+	return nil
+}
+
+func scriptError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrScriptFailed, fmt.Sprintf(format, args...))
+}
+
+func scriptBool(v []byte) bool {
+	for _, b := range v {
+		if b != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (vm *scriptEngine) push(v []byte) error {
+	if len(vm.stack) >= maxStackSize {
+		return scriptError("stack overflow")
+	}
+
+	vm.stack = append(vm.stack, v)
+
+	return nil
+}
+
+func (vm *scriptEngine) pushBool(b bool) error {
+	if b {
+		return vm.push([]byte{1})
+	}
+
+	return vm.push(nil)
+}
+
+func (vm *scriptEngine) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, scriptError("stack underflow")
+	}
+
+	v := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return v, nil
+}
+
+func (vm *scriptEngine) popNum() (int64, error) {
+	v, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return parseScriptNum(v)
+}
+
+func parseScriptNum(v []byte) (int64, error) {
+	if len(v) > 8 || (len(v) == 8 && v[0]&0x80 != 0) {
+		return 0, scriptError("number out of range")
+	}
+
+	var b [8]byte
+	copy(b[8-len(v):], v)
+
+	return int64(binary.BigEndian.Uint64(b[:])), nil
+}
+
+func (vm *scriptEngine) run(script []byte, pushOnly bool) error {
+	if len(script) > MaxScriptSize {
+		return scriptError("script of %d bytes", len(script))
+	}
+
+	for pc := 0; pc < len(script); {
+		op := script[pc]
+		pc++
+
+		if op <= OpPushData1 {
+			n := int(op)
+			if op == OpPushData1 {
+				if pc >= len(script) {
+					return scriptError("truncated push")
+				}
+
+				n = int(script[pc])
+				pc++
+			}
+
+			if pc+n > len(script) {
+				return scriptError("truncated push")
+			}
+
+			if err := vm.push(script[pc : pc+n]); err != nil {
+				return err
+			}
+
+			pc += n
+
+			continue
+		}
+
+		if op >= Op1 && op <= Op16 {
+			if err := vm.push([]byte{op - Op1 + 1}); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if pushOnly {
+			return scriptError("unlocking script must only push data")
+		}
+
+		vm.ops++
+		if vm.ops > maxScriptOps {
+			return scriptError("too many operations")
+		}
+
+		if err := vm.step(op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (vm *scriptEngine) step(op byte) error {
+	switch op {
+	case OpVerify:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		if !scriptBool(v) {
+			return scriptError("OP_VERIFY failed")
+		}
+
+	case OpDrop:
+		_, err := vm.pop()
+
+		return err
+
+	case OpDup:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		vm.stack = append(vm.stack, v)
+
+		return vm.push(v)
+
+	case OpEqual, OpEqualVerify:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		if op == OpEqualVerify {
+			if !bytes.Equal(a, b) {
+				return scriptError("OP_EQUALVERIFY failed")
+			}
+
+			return nil
+		}
+
+		return vm.pushBool(bytes.Equal(a, b))
+
+	case OpSHA256:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(v)
+
+		return vm.push(hash[:])
+
+	case OpHash160:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		return vm.push(wallet.PublicKeyToHash(v))
+
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		signature, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		ok := vm.tx.checkSig(vm.index, vm.subscript, pubKey, signature)
+		if op == OpCheckSigVerify {
+			if !ok {
+				return scriptError("OP_CHECKSIGVERIFY failed")
+			}
+
+			return nil
+		}
+
+		return vm.pushBool(ok)
+
+	case OpCheckMultiSig:
+		return vm.checkMultiSig()
+
+	case OpCheckLockTimeVerify:
+		if len(vm.stack) == 0 {
+			return scriptError("stack underflow")
+		}
+
+		lockTime, err := parseScriptNum(vm.stack[len(vm.stack)-1])
+		if err != nil {
+			return err
+		}
+
+		if vm.tx.LockTime < lockTime {
+			return scriptError("output is locked until %d", lockTime)
+		}
+
+	default:
+		return scriptError("unknown opcode 0x%02x", op)
+	}
+
+	return nil
+}
+
+// checkMultiSig pops n, n keys, m and m signatures, and pushes whether the
+// signatures match m of the keys, in order.
+func (vm *scriptEngine) checkMultiSig() error {
+	n, err := vm.popNum()
+	if err != nil {
+		return err
+	}
+
+	if n < 1 || n > MaxMultiSigKeys {
+		return scriptError("%d multisig keys", n)
+	}
+
+	pubKeys := make([][]byte, n)
+	for i := len(pubKeys) - 1; i >= 0; i-- {
+		if pubKeys[i], err = vm.pop(); err != nil {
+			return err
+		}
+	}
+
+	m, err := vm.popNum()
+	if err != nil {
+		return err
+	}
+
+	if m < 1 || m > n {
+		return scriptError("%d of %d multisig signatures", m, n)
+	}
+
+	signatures := make([][]byte, m)
+	for i := len(signatures) - 1; i >= 0; i-- {
+		if signatures[i], err = vm.pop(); err != nil {
+			return err
+		}
+	}
+
+	matched := 0
+	for _, pubKey := range pubKeys {
+		if matched == len(signatures) {
+			break
+		}
+
+		if vm.tx.checkSig(vm.index, vm.subscript, pubKey, signatures[matched]) {
+			matched++
+		}
+	}
+
+	return vm.pushBool(matched == len(signatures))
+}
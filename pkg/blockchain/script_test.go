@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestScripts(t *testing.T) {
+	keys := []*wallet.Wallet{wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()}
+	owner := keys[0]
+	to := string(wallet.MakeWallet().Address())
+
+	chain := newTestChain(t, DefaultChainParams, owner)
+
+	multiSig, err := MultiSigScript(2, [][]byte{keys[0].PublicKey, keys[1].PublicKey, keys[2].PublicKey})
+	assert.NoError(t, err)
+
+	_, err = MultiSigScript(4, [][]byte{keys[0].PublicKey})
+	assert.ErrorIs(t, err, ErrInvalidScript)
+
+	unlockAt := time.Now().Add(time.Hour).Unix()
+	timeLock := TimeLockScript(unlockAt, wallet.PublicKeyToHash(owner.PublicKey))
+
+	utxo := UTXOSet{chain}
+	fund, err := NewTransaction(owner, []Payment{{To: to, Amount: 1}}, 0, &utxo)
+	assert.NoError(t, err)
+
+	// lock the change to the scripts instead
+	fund.Outputs = []TxOutput{*NewScriptOutput(10, multiSig), *NewScriptOutput(10, timeLock)}
+	assert.NoError(t, chain.SignTransaction(fund, owner.PrivateKey))
+	fund.ID = fund.Hash()
+
+	decoded, err := DeserializeTransaction(fund.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, multiSig, decoded.Outputs[0].Script)
+
+	stored, err := DeserializeOutputs(TxOutputs{Outputs: fund.Outputs}.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, timeLock, stored.Outputs[1].Script)
+
+	mineBlock(t, chain, owner, fund)
+
+	prevTXs := map[string]Transaction{hex.EncodeToString(fund.ID): *fund}
+
+	spend := func(out int, lockTime int64, unlock func(tx *Transaction) []byte) *Transaction {
+		tx := &Transaction{
+			Version:  TxVersion,
+			Inputs:   []TxInput{{ID: fund.ID, Out: out}},
+			Outputs:  []TxOutput{*NewTXOutput(10, to)},
+			LockTime: lockTime,
+		}
+		tx.Inputs[0].Script = unlock(tx)
+		tx.ID = tx.Hash()
+
+		return tx
+	}
+
+	sign := func(tx *Transaction, w *wallet.Wallet, script []byte) []byte {
+		signature, err := tx.ScriptSignature(0, w.PrivateKey, script, SigHashAll)
+		assert.NoError(t, err)
+
+		return signature
+	}
+
+	twoOfThree := spend(0, 0, func(tx *Transaction) []byte {
+		return PushData(sign(tx, keys[0], multiSig), sign(tx, keys[2], multiSig))
+	})
+	assert.NoError(t, twoOfThree.Validate(prevTXs))
+
+	outOfOrder := spend(0, 0, func(tx *Transaction) []byte {
+		return PushData(sign(tx, keys[2], multiSig), sign(tx, keys[0], multiSig))
+	})
+	assert.ErrorIs(t, outOfOrder.Validate(prevTXs), ErrScriptFailed)
+
+	oneOfThree := spend(0, 0, func(tx *Transaction) []byte {
+		return PushData(sign(tx, keys[1], multiSig))
+	})
+	assert.ErrorIs(t, oneOfThree.Validate(prevTXs), ErrScriptFailed)
+
+	notPushOnly := spend(0, 0, func(tx *Transaction) []byte {
+		return append(PushData(sign(tx, keys[0], multiSig), sign(tx, keys[1], multiSig)), OpDup)
+	})
+	assert.ErrorIs(t, notPushOnly.Validate(prevTXs), ErrScriptFailed)
+
+	unlockTimeLock := func(tx *Transaction) []byte {
+		return PushData(sign(tx, owner, timeLock), owner.PublicKey)
+	}
+	assert.ErrorIs(t, spend(1, 0, unlockTimeLock).Validate(prevTXs), ErrScriptFailed)
+
+	// a lock time after the output's passes the script, but keeps the
+	// transaction out of blocks until then
+	late := spend(1, unlockAt, unlockTimeLock)
+	assert.NoError(t, late.Validate(prevTXs))
+	assert.ErrorIs(t, chain.VerifyTransaction(late), ErrLockTime)
+
+	assert.NoError(t, chain.VerifyTransaction(twoOfThree))
+
+	mineBlock(t, chain, owner, twoOfThree)
+
+	report, err := chain.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+}
+
+func TestPayToPubKeyHashTemplate(t *testing.T) {
+	from := wallet.MakeWallet()
+	other := wallet.MakeWallet()
+
+	prev, err := CoinbaseTx(string(from.Address()), "")
+	assert.NoError(t, err)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): *prev}
+
+	tx := &Transaction{
+		Version: TxVersion,
+		Inputs:  []TxInput{{ID: prev.ID, Out: 0, PubKey: from.PublicKey}},
+		Outputs: []TxOutput{*NewTXOutput(BlockSubsidy, string(other.Address()))},
+	}
+	tx.ID = tx.Hash()
+	assert.NoError(t, tx.Sign(from.PrivateKey, prevTXs))
+	assert.NoError(t, tx.Validate(prevTXs))
+
+	// a signature by a key that does not hash to the output's PubKeyHash
+	tx.Inputs[0].PubKey = other.PublicKey
+	tx.ID = tx.Hash()
+	assert.NoError(t, tx.SignInput(0, other.PrivateKey, prev.Outputs[0].PubKeyHash, SigHashAll))
+	assert.ErrorIs(t, tx.Validate(prevTXs), ErrInvalidSignature)
+
+	tx.Version = 2
+	tx.Inputs[0].Script = PushData([]byte{1})
+	tx.ID = tx.Hash()
+	assert.ErrorIs(t, tx.Validate(prevTXs), ErrInvalidScript)
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+// Input signatures of transactions since version 2 cover a signature hash: the
+// double SHA-256 of the canonical encoding of a copy of the transaction,
+// followed by the hash type byte. In the copy:
+//
+//   - ID and every input's Signature are empty;
+//   - the signed input's PubKey is the PubKeyHash, or the locking script, of
+//     the output it spends (for a mint, the issuer's public key hash), the
+//     other inputs' PubKey is empty;
+//   - every input's Script is empty;
+//   - with SigHashNone the outputs are dropped;
+//   - with SigHashAnyoneCanPay only the signed input is kept.
+//
+// An input's Signature is the ECDSA P-256 signature of that hash, either 64
+// bytes of r and s, each big-endian and zero-padded to 32 bytes, or ASN.1 DER,
+// followed by the hash type byte.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// SigHashType selects the parts of a transaction an input signature covers.
+type SigHashType byte
+
+const (
+	// SigHashAll covers every input and output.
+	SigHashAll SigHashType = 0x01
+	// SigHashNone covers the inputs but no output.
+	SigHashNone SigHashType = 0x02
+	// SigHashAnyoneCanPay may be added to the above to cover only the signed
+	// input, so that others can add inputs of their own.
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+var ErrInvalidHashType = errors.New("invalid signature hash type")
+
+// Valid reports whether t is SigHashAll or SigHashNone, with or without
+// SigHashAnyoneCanPay.
+func (t SigHashType) Valid() bool {
+	base := t &^ SigHashAnyoneCanPay
+
+	return base == SigHashAll || base == SigHashNone
+}
+
+// SignatureHash returns the hash that the signature of input i covers, where
+// pubKeyHash locks the output the input spends.
+func (tx *Transaction) SignatureHash(i int, pubKeyHash []byte, hashType SigHashType) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.LockTime = tx.LockTime
+	txCopy.Inputs[i].PubKey = pubKeyHash
+
+	if hashType&^SigHashAnyoneCanPay == SigHashNone {
+		txCopy.Outputs = nil
+	}
+
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txCopy.Inputs = []TxInput{txCopy.Inputs[i]}
+	}
+
+	data := append(txCopy.Serialize(), byte(hashType))
+
+	first := sha256.Sum256(data)
+	hash := sha256.Sum256(first[:])
+
+	return hash[:]
+}
+
+// SignInput signs input i, which spends an output locked to pubKeyHash, with
+// privKey. The signature is fixed width.
+func (tx *Transaction) SignInput(i int, privKey ecdsa.PrivateKey, pubKeyHash []byte, hashType SigHashType) error {
+	signature, err := tx.ScriptSignature(i, privKey, pubKeyHash, hashType)
+	if err != nil {
+		return err
+	}
+
+	tx.Inputs[i].Signature = signature
+
+	return nil
+}
+
+// ScriptSignature returns the fixed width signature of input i by privKey,
+// where subscript is the PubKeyHash or the locking script of the spent output.
+// Signatures for locking scripts go into the input's unlocking script.
+func (tx *Transaction) ScriptSignature(i int, privKey ecdsa.PrivateKey, subscript []byte, hashType SigHashType) ([]byte, error) {
+	if !hashType.Valid() {
+		return nil, ErrInvalidHashType
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, tx.SignatureHash(i, subscript, hashType))
+	if err != nil {
+		return nil, err
+	}
+
+	signature := make([]byte, 65)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:64])
+	signature[64] = byte(hashType)
+
+	return signature, nil
+}
+
+// verifyInput checks the Signature of input i, which spends an output locked
+// to pubKeyHash, against the PubKey in the input.
+func (tx *Transaction) verifyInput(i int, pubKeyHash []byte) bool {
+	in := tx.Inputs[i]
+
+	if tx.Version < 2 {
+		txCopy := tx.TrimmedCopy()
+		txCopy.Inputs[i].PubKey = pubKeyHash
+
+		return verifySignature(in.PubKey, in.Signature, txCopy.signatureData())
+	}
+
+	return tx.checkSig(i, pubKeyHash, in.PubKey, in.Signature)
+}
+
+// checkSig checks a signature, with its hash type byte, of input i by pubKey.
+func (tx *Transaction) checkSig(i int, subscript, pubKey, signature []byte) bool {
+	if len(signature) == 0 || len(pubKey) == 0 {
+		return false
+	}
+
+	hashType := SigHashType(signature[len(signature)-1])
+	if !hashType.Valid() {
+		return false
+	}
+
+	signature = signature[:len(signature)-1]
+	hash := tx.SignatureHash(i, subscript, hashType)
+
+	keyLen := len(pubKey)
+	key := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pubKey[:keyLen/2]),
+		Y:     new(big.Int).SetBytes(pubKey[keyLen/2:]),
+	}
+
+	// a 64 byte signature may also be DER
+	if len(signature) == 64 {
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+
+		if ecdsa.Verify(&key, hash, r, s) {
+			return true
+		}
+	}
+
+	return ecdsa.VerifyASN1(&key, hash, signature)
+}
@@ -0,0 +1,80 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestSignatureHash(t *testing.T) {
+	from := wallet.MakeWallet()
+	other := wallet.MakeWallet()
+	to := string(wallet.MakeWallet().Address())
+
+	prev, err := CoinbaseTx(string(from.Address()), "")
+	assert.NoError(t, err)
+	otherPrev, err := CoinbaseTx(string(other.Address()), "")
+	assert.NoError(t, err)
+
+	prevTXs := map[string]Transaction{
+		hex.EncodeToString(prev.ID):      *prev,
+		hex.EncodeToString(otherPrev.ID): *otherPrev,
+	}
+	pubKeyHash := wallet.PublicKeyToHash(from.PublicKey)
+
+	newTx := func() *Transaction {
+		return &Transaction{
+			Version: TxVersion,
+			Inputs:  []TxInput{{ID: prev.ID, Out: 0, PubKey: from.PublicKey}},
+			Outputs: []TxOutput{*NewTXOutput(BlockSubsidy, to)},
+		}
+	}
+
+	tx := newTx()
+	assert.NoError(t, tx.Sign(from.PrivateKey, prevTXs))
+	assert.Len(t, tx.Inputs[0].Signature, 65)
+	assert.Equal(t, byte(SigHashAll), tx.Inputs[0].Signature[64])
+	assert.True(t, tx.Verify(prevTXs))
+
+	tx.Outputs[0].Value--
+	assert.False(t, tx.Verify(prevTXs))
+
+	// the hash type byte is covered
+	tx = newTx()
+	assert.NoError(t, tx.SignInput(0, from.PrivateKey, pubKeyHash, SigHashAll))
+	tx.Inputs[0].Signature[64] = byte(SigHashNone)
+	assert.False(t, tx.Verify(prevTXs))
+
+	assert.ErrorIs(t, tx.SignInput(0, from.PrivateKey, pubKeyHash, SigHashType(0x03)), ErrInvalidHashType)
+
+	// NONE leaves the outputs to whoever completes the transaction
+	tx = newTx()
+	assert.NoError(t, tx.SignInput(0, from.PrivateKey, pubKeyHash, SigHashNone))
+	tx.Outputs[0].Value--
+	assert.True(t, tx.Verify(prevTXs))
+
+	// ANYONECANPAY lets others add inputs
+	tx = newTx()
+	tx.Outputs[0].Value += BlockSubsidy
+	assert.NoError(t, tx.SignInput(0, from.PrivateKey, pubKeyHash, SigHashAll|SigHashAnyoneCanPay))
+	tx.Inputs = append(tx.Inputs, TxInput{ID: otherPrev.ID, Out: 0, PubKey: other.PublicKey})
+	tx.ID = tx.Hash()
+	assert.NoError(t, tx.SignInput(1, other.PrivateKey, wallet.PublicKeyToHash(other.PublicKey), SigHashAll))
+	assert.True(t, tx.Verify(prevTXs))
+	assert.NoError(t, tx.Validate(prevTXs))
+
+	// external signers may produce DER
+	tx = newTx()
+	der, err := ecdsa.SignASN1(rand.Reader, &from.PrivateKey, tx.SignatureHash(0, pubKeyHash, SigHashAll))
+	assert.NoError(t, err)
+	tx.Inputs[0].Signature = append(der, byte(SigHashAll))
+	assert.True(t, tx.Verify(prevTXs))
+
+	tx.Inputs[0].Signature = append(der, 0, byte(SigHashAll))
+	assert.False(t, tx.Verify(prevTXs))
+}
@@ -0,0 +1,435 @@
+package blockchain
+
+// A chain snapshot holds the main chain and its UTXO set in one file:
+//
+//	magic     the 8 bytes "CVXSNAP1"
+//	header    frame: height int, tip bytes, UTXO entry count int, chain parameters bytes
+//	blocks    one frame per block, genesis first, holding the canonical block record
+//	utxo      one frame per UTXO entry: transaction id bytes, TxOutputs record bytes
+//	trailer   SHA-256 of every preceding byte
+//
+// A frame is a 4 byte big-endian payload length, the payload and the CRC-32
+// (IEEE) of the payload. Payload fields use the canonical encoding without
+// the record prefix; the chain parameters are gob encoded.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const maxFrameSize = 64 << 20
+
+var (
+	ErrInvalidSnapshot  = errors.New("snapshot is invalid")
+	ErrSnapshotTip      = errors.New("snapshot tip does not match")
+	ErrSnapshotParams   = errors.New("snapshot parameters are not those the genesis block commits to")
+	ErrImportIncomplete = errors.New("a snapshot import into this store did not finish")
+
+	snapshotMagic = []byte("CVXSNAP1")
+	// importingKey is set while a snapshot is imported.
+	importingKey = []byte("importing")
+)
+
+type snapshotHeader struct {
+	Height    int
+	Tip       []byte
+	UTXOCount int
+	Params    ChainParams
+}
+
+// ExportChain writes the main chain and the UTXO set to w as a snapshot.
+func (chain *BlockChain) ExportChain(w io.Writer) error {
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+
+	var utxo [][2][]byte
+
+	err = chain.Database.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(utxoPrefix, func(key, value []byte) error {
+			if !isUTXOMeta(key) {
+				utxo = append(utxo, [2][]byte{bytes.TrimPrefix(key, utxoPrefix), value})
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	sw := &snapshotWriter{w: bufio.NewWriter(w), sum: sha256.New()}
+	sw.write(snapshotMagic)
+
+	header := &encoder{}
+	header.int(int64(height))
+	header.bytes(chain.LastHash)
+	header.int(int64(len(utxo)))
+	header.bytes(encodeGob(chain.Params))
+	sw.frame(header.buf.Bytes())
+
+	for h := 0; h <= height; h++ {
+		block, err := chain.GetBlockByHeight(h)
+		if err != nil {
+			return err
+		}
+
+		sw.frame(block.Serialize())
+	}
+
+	for _, entry := range utxo {
+		outs, err := DeserializeOutputs(entry[1])
+		if err != nil {
+			return err
+		}
+
+		e := &encoder{}
+		e.bytes(entry[0])
+		e.bytes(outs.Serialize())
+		sw.frame(e.buf.Bytes())
+	}
+
+	return sw.finish()
+}
+
+// ImportChain loads a snapshot written by ExportChain into store, which must
+// not hold a chain yet. Every block is validated as it is loaded, and the
+// import is refused when the loaded chain does not end at the tip named in
+// the snapshot, or at expectedTip when it is not nil. The chain parameters
+// in the snapshot must be those the genesis block commits to, so that with
+// the tip checked they cannot be swapped. Chains whose genesis block does not
+// commit to parameters predate MinTxVersion too, and get a legacy height
+// above the tip as when they are opened. Errors wrap ErrInvalidSnapshot,
+// ErrSnapshotTip or ErrSnapshotParams; a store whose import failed keeps
+// returning ErrImportIncomplete from ContinueBlockChain and should be discarded.
+func ImportChain(store ChainStore, r io.Reader, expectedTip []byte) (*BlockChain, error) {
+	err := store.View(func(txn StoreTxn) error {
+		_, err := txn.Get([]byte("lh"))
+
+		return err
+	})
+	if err == nil {
+		return nil, ErrChainExists
+	}
+	if err != ErrKeyNotFound {
+		return nil, err
+	}
+
+	sr := &snapshotReader{r: bufio.NewReader(r), sum: sha256.New()}
+
+	header, err := sr.header()
+	if err != nil {
+		return nil, err
+	}
+
+	if expectedTip != nil && !bytes.Equal(header.Tip, expectedTip) {
+		return nil, fmt.Errorf("tip %x, expected %x: %w", header.Tip, expectedTip, ErrSnapshotTip)
+	}
+
+	engine, err := NewConsensus(header.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := &BlockChain{Database: store, Params: header.Params, Engine: engine}
+
+	var parent *Block
+
+	for height := 0; height <= header.Height; height++ {
+		payload, err := sr.frame()
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := Deserialize(payload)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w: %v", height, ErrInvalidSnapshot, err)
+		}
+
+		if parent == nil {
+			if !genesisCommitsTo(block, header.Params) {
+				return nil, ErrSnapshotParams
+			}
+
+			// chains this old may hold legacy transactions up to the tip
+			if !committedGenesis(block) {
+				chain.legacyTxHeight = header.Height + 1
+			}
+		}
+
+		if err := chain.importBlock(block, parent, height); err != nil {
+			return nil, err
+		}
+
+		parent = block
+	}
+
+	if !bytes.Equal(chain.LastHash, header.Tip) {
+		return nil, fmt.Errorf("loaded tip %x, snapshot tip %x: %w", chain.LastHash, header.Tip, ErrSnapshotTip)
+	}
+
+	if err := chain.checkSnapshotUTXO(sr, header.UTXOCount); err != nil {
+		return nil, err
+	}
+
+	if err := sr.finish(); err != nil {
+		return nil, err
+	}
+
+	err = store.Update(func(txn StoreTxn) error {
+		return txn.Delete(importingKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// importBlock validates block, which should sit at height on top of parent,
+// and connects it. Merkle roots of legacy blocks cannot be recomputed and are
+// not checked.
+func (chain *BlockChain) importBlock(block, parent *Block, height int) error {
+	if d := chain.auditBlock(block, parent, height); d != nil {
+		return fmt.Errorf("block %d: %w: %v", height, ErrInvalidSnapshot, d.Err)
+	}
+
+	if !block.hasLegacyHeader() && !block.hasLegacyTransactions() && !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return fmt.Errorf("block %d: %w: %v", height, ErrInvalidSnapshot, ErrInvalidMerkleRoot)
+	}
+
+	if parent == nil {
+		err := chain.Database.Update(func(txn StoreTxn) error {
+			if err := txn.Set(importingKey, []byte{}); err != nil {
+				return err
+			}
+
+			return writeGenesis(txn, chain.Params, block, chain.Engine.Work(&block.BlockHeader), chain.legacyTxHeight)
+		})
+		if err != nil {
+			return err
+		}
+
+		chain.LastHash = block.Hash
+
+		return nil
+	}
+
+	if err := chain.validateTransactions(block); err != nil {
+		return fmt.Errorf("block %d: %w: %v", height, ErrInvalidSnapshot, err)
+	}
+
+	return chain.connectBlock(block)
+}
+
+// checkSnapshotUTXO reads the UTXO entries of the snapshot and compares them
+// with the UTXO set built while the blocks were loaded.
+func (chain *BlockChain) checkSnapshotUTXO(sr *snapshotReader, count int) error {
+	var built map[string]TxOutputs
+
+	err := chain.Database.View(func(txn StoreTxn) error {
+		var err error
+
+		built, err = storedUTXO(txn)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if count != len(built) {
+		return fmt.Errorf("%d UTXO entries, chain has %d: %w", count, len(built), ErrInvalidSnapshot)
+	}
+
+	for i := 0; i < count; i++ {
+		payload, err := sr.frame()
+		if err != nil {
+			return err
+		}
+
+		d := &decoder{data: payload}
+		txID := d.bytes()
+		record := d.bytes()
+		if err := d.finish(); err != nil {
+			return fmt.Errorf("utxo entry %d: %w: %v", i, ErrInvalidSnapshot, err)
+		}
+
+		outs, err := DeserializeOutputs(record)
+		if err != nil {
+			return fmt.Errorf("utxo entry %d: %w: %v", i, ErrInvalidSnapshot, err)
+		}
+
+		id := hex.EncodeToString(txID)
+
+		want, ok := built[id]
+		if !ok || !sameOutputs(want, outs) {
+			return fmt.Errorf("utxo %s: %w: does not match the chain", id, ErrInvalidSnapshot)
+		}
+
+		// each entry may only be matched once
+		delete(built, id)
+	}
+
+	return nil
+}
+
+// sameOutputs reports whether a and b hold the same outputs at the same indexes.
+func sameOutputs(a, b TxOutputs) bool {
+	byIndex := outputsByIndex(b)
+	if len(byIndex) != len(a.Outputs) {
+		return false
+	}
+
+	for i, out := range a.Outputs {
+		other, ok := byIndex[a.Index(i)]
+		if !ok || !outputEqual(other, out) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	sum hash.Hash
+	err error
+}
+
+func (sw *snapshotWriter) write(data []byte) {
+	if sw.err != nil {
+		return
+	}
+
+	sw.sum.Write(data)
+	_, sw.err = sw.w.Write(data)
+}
+
+func (sw *snapshotWriter) frame(payload []byte) {
+	var b [4]byte
+
+	binary.BigEndian.PutUint32(b[:], uint32(len(payload)))
+	sw.write(b[:])
+	sw.write(payload)
+	binary.BigEndian.PutUint32(b[:], crc32.ChecksumIEEE(payload))
+	sw.write(b[:])
+}
+
+func (sw *snapshotWriter) finish() error {
+	if sw.err != nil {
+		return sw.err
+	}
+
+	if _, err := sw.w.Write(sw.sum.Sum(nil)); err != nil {
+		return err
+	}
+
+	return sw.w.Flush()
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	sum hash.Hash
+}
+
+func (sr *snapshotReader) read(n int) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	sr.sum.Write(data)
+
+	return data, nil
+}
+
+func (sr *snapshotReader) frame() ([]byte, error) {
+	b, err := sr.read(4)
+	if err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(b)
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("%w: frame of %d bytes", ErrInvalidSnapshot, size)
+	}
+
+	payload, err := sr.read(int(size))
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = sr.read(4); err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(b) != crc32.ChecksumIEEE(payload) {
+		return nil, fmt.Errorf("%w: frame checksum mismatch", ErrInvalidSnapshot)
+	}
+
+	return payload, nil
+}
+
+func (sr *snapshotReader) header() (*snapshotHeader, error) {
+	magic, err := sr.read(len(snapshotMagic))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, snapshotMagic) {
+		return nil, fmt.Errorf("%w: not a chain snapshot", ErrInvalidSnapshot)
+	}
+
+	payload, err := sr.frame()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: payload}
+	header := &snapshotHeader{
+		Height:    int(d.int()),
+		Tip:       d.bytes(),
+		UTXOCount: int(d.int()),
+	}
+	params := d.bytes()
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("header: %w: %v", ErrInvalidSnapshot, err)
+	}
+
+	if header.Height < 0 || header.UTXOCount < 0 {
+		return nil, fmt.Errorf("header: %w: negative count", ErrInvalidSnapshot)
+	}
+
+	if err := decodeGob(params, &header.Params); err != nil {
+		return nil, fmt.Errorf("header: %w: %v", ErrInvalidSnapshot, err)
+	}
+
+	return header, nil
+}
+
+// finish checks the trailing SHA-256 of the snapshot.
+func (sr *snapshotReader) finish() error {
+	sum := sr.sum.Sum(nil)
+
+	trailer := make([]byte, len(sum))
+	if _, err := io.ReadFull(sr.r, trailer); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	if !bytes.Equal(trailer, sum) {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+
+	return nil
+}
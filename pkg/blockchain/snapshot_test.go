@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestChainSnapshot(t *testing.T) {
+	from := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+
+	chain := newTestChain(t, DefaultChainParams, from)
+
+	utxo := UTXOSet{chain}
+	tx, err := NewTransaction(from, []Payment{{To: string(to.Address()), Amount: 5}}, 0, &utxo)
+	assert.NoError(t, err)
+
+	mineBlock(t, chain, from, tx)
+
+	var snapshot bytes.Buffer
+	assert.NoError(t, chain.ExportChain(&snapshot))
+
+	store := NewMemoryStore()
+	imported, err := ImportChain(store, bytes.NewReader(snapshot.Bytes()), chain.LastHash)
+	assert.NoError(t, err)
+	assert.Equal(t, chain.LastHash, imported.LastHash)
+
+	report, err := imported.VerifyChain()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid())
+
+	_, err = ContinueBlockChain(store)
+	assert.NoError(t, err)
+
+	_, err = ImportChain(store, bytes.NewReader(snapshot.Bytes()), nil)
+	assert.ErrorIs(t, err, ErrChainExists)
+
+	_, err = ImportChain(NewMemoryStore(), bytes.NewReader(snapshot.Bytes()), []byte("other tip"))
+	assert.ErrorIs(t, err, ErrSnapshotTip)
+
+	// parameters the genesis block does not commit to
+	params := chain.Params
+	chain.Params.Issuers = append(chain.Params.Issuers, string(wallet.MakeWallet().Address()))
+
+	var swapped bytes.Buffer
+	assert.NoError(t, chain.ExportChain(&swapped))
+	chain.Params = params
+
+	_, err = ImportChain(NewMemoryStore(), &swapped, chain.LastHash)
+	assert.ErrorIs(t, err, ErrSnapshotParams)
+
+	// a flipped bit in the frame of block 1
+	tampered := append([]byte{}, snapshot.Bytes()...)
+	tampered[len(tampered)/2] ^= 1
+
+	store = NewMemoryStore()
+	_, err = ImportChain(store, bytes.NewReader(tampered), nil)
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	_, err = ContinueBlockChain(store)
+	assert.ErrorIs(t, err, ErrImportIncomplete)
+
+	// a UTXO entry whose expiry or asset differs from the outputs of the chain
+	for _, tamper := range []func(out *TxOutput){
+		func(out *TxOutput) { out.Expiry = 1 },
+		func(out *TxOutput) { out.Asset = []byte("other lot") },
+	} {
+		var original []byte
+
+		err = chain.Database.Update(func(txn StoreTxn) error {
+			original, err = txn.Get(utxoKey(tx.ID))
+			if err != nil {
+				return err
+			}
+
+			outs, err := DeserializeOutputs(original)
+			if err != nil {
+				return err
+			}
+			tamper(&outs.Outputs[0])
+
+			return txn.Set(utxoKey(tx.ID), outs.Serialize())
+		})
+		assert.NoError(t, err)
+
+		snapshot.Reset()
+		assert.NoError(t, chain.ExportChain(&snapshot))
+
+		_, err = ImportChain(NewMemoryStore(), &snapshot, chain.LastHash)
+		assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+		err = chain.Database.Update(func(txn StoreTxn) error {
+			return txn.Set(utxoKey(tx.ID), original)
+		})
+		assert.NoError(t, err)
+	}
+}
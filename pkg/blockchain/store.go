@@ -0,0 +1,147 @@
+package blockchain
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrReadOnlyTxn = errors.New("write in a read-only transaction")
+)
+
+// StoreTxn is a transaction over a ChainStore. Values returned by Get and
+// passed to IteratePrefix callbacks may be kept by the caller.
+type StoreTxn interface {
+	// Get returns ErrKeyNotFound when key is not set.
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	// IteratePrefix calls fn for the keys starting with prefix in key order,
+	// stopping at the first error fn returns.
+	IteratePrefix(prefix []byte, fn func(key, value []byte) error) error
+}
+
+// ChainStore is the key-value storage behind a BlockChain.
+type ChainStore interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(txn StoreTxn) error) error
+	// Update runs fn in a read-write transaction. The writes are applied as
+	// one batch when fn returns nil and discarded otherwise.
+	Update(fn func(txn StoreTxn) error) error
+	Close() error
+}
+
+// MemoryStore is a ChainStore that keeps everything in memory, for tests.
+type MemoryStore struct {
+	mutex sync.RWMutex
+	data  map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) View(fn func(txn StoreTxn) error) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return fn(&memoryTxn{store: s})
+}
+
+func (s *MemoryStore) Update(fn func(txn StoreTxn) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	txn := &memoryTxn{store: s, writes: make(map[string][]byte)}
+	if err := fn(txn); err != nil {
+		return err
+	}
+
+	for key, value := range txn.writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// memoryTxn reads through its pending writes; a nil value marks a deletion.
+// writes is nil for read-only transactions.
+type memoryTxn struct {
+	store  *MemoryStore
+	writes map[string][]byte
+}
+
+func (txn *memoryTxn) Get(key []byte) ([]byte, error) {
+	value, ok := txn.writes[string(key)]
+	if !ok {
+		value, ok = txn.store.data[string(key)]
+	}
+
+	if !ok || value == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	return append([]byte{}, value...), nil
+}
+
+func (txn *memoryTxn) Set(key, value []byte) error {
+	if txn.writes == nil {
+		return ErrReadOnlyTxn
+	}
+
+	txn.writes[string(key)] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (txn *memoryTxn) Delete(key []byte) error {
+	if txn.writes == nil {
+		return ErrReadOnlyTxn
+	}
+
+	txn.writes[string(key)] = nil
+
+	return nil
+}
+
+func (txn *memoryTxn) IteratePrefix(prefix []byte, fn func(key, value []byte) error) error {
+	var keys []string
+
+	for key := range txn.store.data {
+		if _, pending := txn.writes[key]; !pending && strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+
+	for key, value := range txn.writes {
+		if value != nil && strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
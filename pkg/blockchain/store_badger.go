@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// ErrStoreLocked is returned when another process, usually a running node,
+// holds the database. Badger allows one writer or any number of readers.
+var ErrStoreLocked = errors.New("chain database is in use by another process")
+
+// BadgerStore is the ChainStore backed by a Badger database on disk.
+type BadgerStore struct {
+	DB *badger.DB
+}
+
+// OpenBadgerStore opens or creates the Badger database in dir for reading
+// and writing. It returns an error wrapping ErrStoreLocked while another
+// process has the database open.
+func OpenBadgerStore(dir string) (*BadgerStore, error) {
+	return openBadger(badger.DefaultOptions(dir))
+}
+
+// OpenBadgerStoreReadOnly opens the existing Badger database in dir for
+// inspection. Writes fail with ErrReadOnlyTxn. It returns ErrNoChain when
+// there is no database and an error wrapping ErrStoreLocked while a writer
+// has it open.
+func OpenBadgerStoreReadOnly(dir string) (*BadgerStore, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, ErrNoChain
+	}
+
+	opts := badger.DefaultOptions(dir)
+	opts.ReadOnly = true
+
+	return openBadger(opts)
+}
+
+func openBadger(opts badger.Options) (*BadgerStore, error) {
+	db, err := badger.Open(opts)
+	if err != nil && strings.Contains(err.Error(), "Another process is using this Badger database") {
+		return nil, fmt.Errorf("%s: %w", opts.Dir, ErrStoreLocked)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &BadgerStore{DB: db}, nil
+}
+
+func (s *BadgerStore) View(fn func(txn StoreTxn) error) error {
+	return s.DB.View(func(txn *badger.Txn) error {
+		return fn(badgerTxn{txn})
+	})
+}
+
+func (s *BadgerStore) Update(fn func(txn StoreTxn) error) error {
+	return s.DB.Update(func(txn *badger.Txn) error {
+		return fn(badgerTxn{txn})
+	})
+}
+
+func (s *BadgerStore) Close() error {
+	return s.DB.Close()
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t badgerTxn) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+func (t badgerTxn) Set(key, value []byte) error {
+	return writeError(t.txn.Set(key, value))
+}
+
+func (t badgerTxn) Delete(key []byte) error {
+	return writeError(t.txn.Delete(key))
+}
+
+func writeError(err error) error {
+	if err == badger.ErrReadOnlyTxn {
+		return ErrReadOnlyTxn
+	}
+
+	return err
+}
+
+func (t badgerTxn) IteratePrefix(prefix []byte, fn func(key, value []byte) error) error {
+	it := t.txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
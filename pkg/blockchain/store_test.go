@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+
+	err := store.Update(func(txn StoreTxn) error {
+		for _, key := range []string{"b-2", "a-1", "b-1", "b-3"} {
+			if err := txn.Set([]byte(key), []byte(key)); err != nil {
+				return err
+			}
+		}
+
+		return txn.Delete([]byte("b-3"))
+	})
+	assert.NoError(t, err)
+
+	err = store.Update(func(txn StoreTxn) error {
+		if err := txn.Set([]byte("b-4"), nil); err != nil {
+			return err
+		}
+
+		return errors.New("abort")
+	})
+	assert.EqualError(t, err, "abort")
+
+	err = store.View(func(txn StoreTxn) error {
+		var keys []string
+		err := txn.IteratePrefix([]byte("b-"), func(key, value []byte) error {
+			assert.Equal(t, key, value)
+			keys = append(keys, string(key))
+
+			return nil
+		})
+		assert.Equal(t, []string{"b-1", "b-2"}, keys)
+
+		_, getErr := txn.Get([]byte("b-4"))
+		assert.Equal(t, ErrKeyNotFound, getErr)
+		assert.Equal(t, ErrReadOnlyTxn, txn.Set([]byte("c"), nil))
+
+		return err
+	})
+	assert.NoError(t, err)
+}
+
+func TestBadgerStoreLocking(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := OpenBadgerStoreReadOnly(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, ErrNoChain)
+
+	writer, err := OpenBadgerStore(dir)
+	assert.NoError(t, err)
+
+	_, err = OpenBadgerStore(dir)
+	assert.ErrorIs(t, err, ErrStoreLocked)
+
+	_, err = OpenBadgerStoreReadOnly(dir)
+	assert.ErrorIs(t, err, ErrStoreLocked)
+
+	assert.NoError(t, writer.Update(func(txn StoreTxn) error {
+		return txn.Set([]byte("key"), []byte("value"))
+	}))
+	assert.NoError(t, writer.Close())
+
+	reader, err := OpenBadgerStoreReadOnly(dir)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	other, err := OpenBadgerStoreReadOnly(dir)
+	assert.NoError(t, err)
+	defer other.Close()
+
+	err = reader.View(func(txn StoreTxn) error {
+		value, err := txn.Get([]byte("key"))
+		assert.Equal(t, []byte("value"), value)
+
+		return err
+	})
+	assert.NoError(t, err)
+
+	err = reader.Update(func(txn StoreTxn) error {
+		return txn.Set([]byte("key"), []byte("other"))
+	})
+	assert.ErrorIs(t, err, ErrReadOnlyTxn)
+
+	_, err = OpenBadgerStore(dir)
+	assert.ErrorIs(t, err, ErrStoreLocked)
+}
@@ -0,0 +1,242 @@
+package blockchain
+
+// Tokens of an asset only move to outputs of the same asset, so a trace
+// follows one asset: back through the inputs spending it to the mints, or the
+// coinbases for the native token, that issued it, and forward through the
+// transactions spending it to the outputs holding it now.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MaxTraceSteps bounds the outputs a trace visits, as native tokens merged
+// over many transactions can descend from a large part of the chain.
+const MaxTraceSteps = 10000
+
+var ErrTraceTooLong = errors.New("trace visits more than MaxTraceSteps outputs")
+
+// TraceStep is one output in the custody chain of a traced output.
+type TraceStep struct {
+	TxID      []byte
+	Out       int
+	Output    TxOutput
+	Height    int
+	Timestamp int64
+	// Origin is set for outputs of mints and coinbases, where a trace back
+	// ends.
+	Origin bool
+	// Unspent is set for outputs still held, the current holders.
+	Unspent bool
+
+	position int
+}
+
+func outpointKey(txID []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txID, out)
+}
+
+// traceStep returns the step for output out of the transaction at position in
+// block.
+func (chain *BlockChain) traceStep(block *Block, position, out int) (TraceStep, error) {
+	tx := block.Transactions[position]
+
+	unspent, err := UTXOSet{chain}.IsUnspent(tx.ID, out)
+	if err != nil {
+		return TraceStep{}, err
+	}
+
+	return TraceStep{
+		TxID:      tx.ID,
+		Out:       out,
+		Output:    tx.Outputs[out],
+		Height:    block.Height,
+		Timestamp: block.Timestamp,
+		Origin:    tx.IsMint() || tx.IsCoinbase(),
+		Unspent:   unspent,
+		position:  position,
+	}, nil
+}
+
+// findOutput returns the main chain block holding output out of txID, the
+// position of its transaction in it and its step.
+func (chain *BlockChain) findOutput(txID []byte, out int) (*Block, TraceStep, error) {
+	block, position, err := chain.FindTransactionBlock(txID)
+	if err != nil {
+		return nil, TraceStep{}, err
+	}
+
+	if out < 0 || out >= len(block.Transactions[position].Outputs) {
+		return nil, TraceStep{}, fmt.Errorf("output %x:%d: %w", txID, out, ErrTxNotFound)
+	}
+
+	step, err := chain.traceStep(block, position, out)
+
+	return block, step, err
+}
+
+// TraceBack returns the custody chain of output out of transaction txID, from
+// the mints or coinbases that issued its tokens to the output itself, in chain
+// order. At every transaction it follows the inputs spending the asset of the
+// output. It returns an error wrapping ErrTxNotFound when the output is not on
+// the main chain.
+func (chain *BlockChain) TraceBack(txID []byte, out int) ([]TraceStep, error) {
+	_, start, err := chain.findOutput(txID, out)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []TraceStep{start}
+	seen := map[string]bool{outpointKey(txID, out): true}
+
+	for i := 0; i < len(steps); i++ {
+		if steps[i].Origin {
+			continue
+		}
+
+		tx, err := chain.FindTransaction(steps[i].TxID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, in := range tx.Inputs {
+			key := outpointKey(in.ID, in.Out)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			_, step, err := chain.findOutput(in.ID, in.Out)
+			if err != nil {
+				return nil, err
+			}
+
+			if !bytes.Equal(step.Output.Asset, start.Output.Asset) {
+				continue
+			}
+
+			if len(steps) == MaxTraceSteps {
+				return nil, ErrTraceTooLong
+			}
+
+			steps = append(steps, step)
+		}
+	}
+
+	sortSteps(steps)
+
+	return steps, nil
+}
+
+// TraceForward returns output out of transaction txID and every output its
+// tokens moved to since, in chain order. The unspent ones are the current
+// holders. It returns an error wrapping ErrTxNotFound when the output is not
+// on the main chain.
+func (chain *BlockChain) TraceForward(txID []byte, out int) ([]TraceStep, error) {
+	_, start, err := chain.findOutput(txID, out)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.traceForward([]TraceStep{start}, start.Output.Asset, start.Height, start.position, false)
+}
+
+// TraceLot returns every output the mints of asset issued, in one or more
+// mints and outputs, and every output their tokens moved to since, in chain
+// order. The unspent ones are the current holders of the lot. It returns an
+// error wrapping ErrUnknownAsset when asset is not registered.
+func (chain *BlockChain) TraceLot(asset []byte) ([]TraceStep, error) {
+	registered, err := chain.GetAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lot cannot be minted before the transaction registering it
+	block, position, err := chain.FindTransactionBlock(registered.TxID)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.traceForward(nil, asset, block.Height, position-1, true)
+}
+
+// traceForward walks the main chain from the transaction after position at
+// height to the tip, adding to steps the outputs of asset of every
+// transaction spending one of steps, and with mints set of every mint.
+func (chain *BlockChain) traceForward(steps []TraceStep, asset []byte, height, position int, mints bool) ([]TraceStep, error) {
+	best, err := chain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	traced := make(map[string]bool)
+	for _, step := range steps {
+		traced[outpointKey(step.TxID, step.Out)] = true
+	}
+
+	for h := height; h <= best; h++ {
+		block, err := chain.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+
+		for p, tx := range block.Transactions {
+			if h == height && p <= position {
+				continue
+			}
+
+			issues := mints && tx.IsMint()
+			if !issues && (!tx.spendsOutputs() || !spendsAny(tx, traced)) {
+				continue
+			}
+
+			for i, output := range tx.Outputs {
+				if !bytes.Equal(output.Asset, asset) {
+					continue
+				}
+
+				if len(steps) == MaxTraceSteps {
+					return nil, ErrTraceTooLong
+				}
+
+				step, err := chain.traceStep(block, p, i)
+				if err != nil {
+					return nil, err
+				}
+
+				steps = append(steps, step)
+				traced[outpointKey(tx.ID, i)] = true
+			}
+		}
+	}
+
+	return steps, nil
+}
+
+// spendsAny reports whether tx spends one of the outputs in outpoints.
+func spendsAny(tx *Transaction, outpoints map[string]bool) bool {
+	for _, in := range tx.Inputs {
+		if outpoints[outpointKey(in.ID, in.Out)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortSteps puts steps in chain order.
+func sortSteps(steps []TraceStep) {
+	sort.Slice(steps, func(i, j int) bool {
+		if steps[i].Height != steps[j].Height {
+			return steps[i].Height < steps[j].Height
+		}
+
+		if steps[i].position != steps[j].position {
+			return steps[i].position < steps[j].position
+		}
+
+		return steps[i].Out < steps[j].Out
+	})
+}
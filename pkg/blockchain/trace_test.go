@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/swagftw/covax19-blockchain/pkg/wallet"
+)
+
+func TestTrace(t *testing.T) {
+	maker := wallet.MakeWallet()
+	distributor := wallet.MakeWallet()
+	clinic := wallet.MakeWallet()
+	citizen := wallet.MakeWallet()
+
+	params := DefaultChainParams
+	params.Issuers = []string{string(maker.Address())}
+
+	chain := newTestChain(t, params, maker)
+
+	lotA := Asset{Product: "Covaxin", Manufacturer: "Bharat Biotech", Lot: "A"}
+	lotB := Asset{Product: "Covaxin", Manufacturer: "Bharat Biotech", Lot: "B"}
+	idA := AssetID(wallet.PublicKeyToHash(maker.PublicKey), lotA)
+	idB := AssetID(wallet.PublicKeyToHash(maker.PublicKey), lotB)
+
+	utxo := UTXOSet{chain}
+
+	mint, err := NewMintTx(maker, []Payment{
+		{To: string(distributor.Address()), Amount: 10, Asset: idA},
+		{To: string(distributor.Address()), Amount: 10, Asset: idB},
+	}, "order-1", lotA, lotB)
+	assert.NoError(t, err)
+	mineBlock(t, chain, maker, mint)
+
+	shipment, err := NewTransaction(distributor, []Payment{
+		{To: string(clinic.Address()), Amount: 4, Asset: idA},
+		{To: string(clinic.Address()), Amount: 4, Asset: idB},
+	}, 0, &utxo)
+	assert.NoError(t, err)
+	mineBlock(t, chain, maker, shipment)
+
+	dose, err := NewTransaction(clinic, []Payment{{To: string(citizen.Address()), Amount: 1, Asset: idA}}, 0, &utxo)
+	assert.NoError(t, err)
+	mineBlock(t, chain, maker, dose)
+
+	out := -1
+	for i, output := range dose.Outputs {
+		if output.IsLockedWithKey(wallet.PublicKeyToHash(citizen.PublicKey)) {
+			out = i
+		}
+	}
+
+	// back from the dose to the mint, through lot A only
+	steps, err := chain.TraceBack(dose.ID, out)
+	assert.NoError(t, err)
+	assert.Len(t, steps, 3)
+	assert.Equal(t, mint.ID, steps[0].TxID)
+	assert.True(t, steps[0].Origin)
+	assert.Equal(t, wallet.PublicKeyToHash(distributor.PublicKey), steps[0].Output.PubKeyHash)
+	assert.Equal(t, shipment.ID, steps[1].TxID)
+	assert.Equal(t, wallet.PublicKeyToHash(clinic.PublicKey), steps[1].Output.PubKeyHash)
+	assert.Equal(t, dose.ID, steps[2].TxID)
+	assert.True(t, steps[2].Unspent)
+
+	for i, step := range steps {
+		assert.Equal(t, idA, step.Output.Asset)
+		assert.Equal(t, i+1, step.Height)
+	}
+
+	// forward from the lot A mint output to its current holders
+	steps, err = chain.TraceForward(mint.ID, 0)
+	assert.NoError(t, err)
+
+	held := 0
+	for _, step := range steps {
+		assert.Equal(t, idA, step.Output.Asset)
+		if step.Unspent {
+			held += step.Output.Value
+		}
+	}
+	assert.Equal(t, 10, held)
+	assert.False(t, steps[0].Unspent)
+
+	// lot A is issued again, to two holders, and traced as a whole
+	restock, err := NewMintTx(maker, []Payment{
+		{To: string(clinic.Address()), Amount: 3, Asset: idA},
+		{To: string(citizen.Address()), Amount: 2, Asset: idA},
+	}, "order-2")
+	assert.NoError(t, err)
+	mineBlock(t, chain, maker, restock)
+
+	steps, err = chain.TraceLot(idA)
+	assert.NoError(t, err)
+
+	held = 0
+	var origins [][]byte
+	for _, step := range steps {
+		assert.Equal(t, idA, step.Output.Asset)
+		if step.Origin {
+			origins = append(origins, step.TxID)
+		}
+		if step.Unspent {
+			held += step.Output.Value
+		}
+	}
+	assert.Equal(t, [][]byte{mint.ID, restock.ID, restock.ID}, origins)
+	assert.Equal(t, 15, held)
+	assert.Equal(t, mint.ID, steps[0].TxID)
+
+	_, err = chain.TraceLot(AssetID(wallet.PublicKeyToHash(maker.PublicKey), Asset{Product: "Covaxin", Lot: "C"}))
+	assert.ErrorIs(t, err, ErrUnknownAsset)
+
+	_, err = chain.TraceBack(dose.ID, len(dose.Outputs))
+	assert.ErrorIs(t, err, ErrTxNotFound)
+}
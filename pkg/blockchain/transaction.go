@@ -6,10 +6,9 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"math"
 	"math/big"
 	"strings"
 	"time"
@@ -18,103 +17,273 @@ import (
 	"github.com/swagftw/covax19-blockchain/types"
 )
 
+// TxVersion is the version of transactions created by this node. Version 0
+// transactions were signed before the canonical encoding existed, version 1
+// transactions sign the SHA-256 of it. Version 2 signatures cover a signature
+// hash, see SignatureHash. Version 3 transactions carry scripts, see script.go,
+// version 4 transactions output expiries and version 5 transactions assets,
+// see assets.go.
+const TxVersion = 5
+
+// MinTxVersion is the lowest version of transactions accepted in the memory
+// pool and in new blocks. Older transactions are only valid in the blocks of
+// chains created before signature hashes existed, below their legacy height.
+const MinTxVersion = 2
+
+const (
+	// MintInput is the Out of the single input of a mint transaction. The
+	// input ID holds the issuance reference and PubKey the issuer's key.
+	MintInput = -2
+	// MaxReferenceLength bounds the issuance reference of a mint.
+	MaxReferenceLength = 64
+)
+
+// Transaction spends Inputs to Outputs. A mint may also register Assets.
 type Transaction struct {
+	Version  int
 	ID       []byte
 	Inputs   []TxInput
 	Outputs  []TxOutput
 	LockTime int64
+	Assets   []Asset
 }
 
+// Hash returns the SHA-256 of the canonical encoding of tx without its ID and
+// the input signatures and unlocking scripts, so that it is the ID of tx
+// before and after the inputs are signed.
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *tx
 	txCopy.ID = []byte{}
+	txCopy.Inputs = make([]TxInput, len(tx.Inputs))
+
+	for i, in := range tx.Inputs {
+		txCopy.Inputs[i] = TxInput{ID: in.ID, Out: in.Out, PubKey: in.PubKey}
+	}
 
 	hash = sha256.Sum256(txCopy.Serialize())
 
 	return hash[:]
 }
 
+// Serialize returns the canonical encoding of the transaction.
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
-
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
+	e := newEncoder()
+	e.transaction(&tx)
 
-	return encoded.Bytes()
+	return e.buf.Bytes()
 }
 
-func DeserializeTransaction(data []byte) Transaction {
+// DeserializeTransaction decodes a transaction in the canonical or the legacy
+// gob encoding.
+func DeserializeTransaction(data []byte) (Transaction, error) {
 	var transaction Transaction
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
-	Handle(err)
-	return transaction
+	if isCanonical(data) {
+		d := newDecoder(data)
+		transaction = d.transaction()
+
+		if err := d.finish(); err != nil {
+			return Transaction{}, corrupt("transaction", err)
+		}
+
+		return transaction, nil
+	}
+
+	if err := decodeGob(data, &transaction); err != nil {
+		return Transaction{}, corrupt("transaction", err)
+	}
+
+	return transaction, nil
+}
+
+// CoinbaseTx pays the block subsidy to the address.
+func CoinbaseTx(to, data string) (*Transaction, error) {
+	return newCoinbase(to, data, BlockSubsidy)
 }
 
-func CoinbaseTx(to, data string) *Transaction {
+// newCoinbase pays value to the address. data defaults to random bytes so
+// that coinbases to the same address differ.
+func newCoinbase(to, data string, value int) (*Transaction, error) {
 	if data == "" {
 		randData := make([]byte, 24)
-		_, err := rand.Read(randData)
-		Handle(err)
+		if _, err := rand.Read(randData); err != nil {
+			return nil, err
+		}
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(20, to)
+	txin := TxInput{ID: []byte{}, Out: -1, PubKey: []byte(data)}
+	txout := NewTXOutput(value, to)
 
-	tx := Transaction{Inputs: []TxInput{txin}, Outputs: []TxOutput{*txout}}
+	tx := Transaction{Version: TxVersion, Inputs: []TxInput{txin}, Outputs: []TxOutput{*txout}}
 	tx.ID = tx.Hash()
 
-	return &tx
+	return &tx, nil
+}
+
+// Payment is an output to create: Amount units of Asset, or of the native
+// token when it is empty, locked to the address To and expiring at the Unix
+// time Expiry unless it is zero.
+type Payment struct {
+	To     string
+	Amount int
+	Expiry int64
+	Asset  []byte
 }
 
-func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet, skipBalanceCheck bool) (*Transaction, error) {
-	var inputs []TxInput
+// paymentOutputs returns the outputs for payments and the total of all of
+// them, which bounds the total of each asset.
+func paymentOutputs(payments []Payment) ([]TxOutput, int, error) {
+	if len(payments) == 0 {
+		return nil, 0, ErrEmptyTransaction
+	}
 
 	var outputs []TxOutput
 
-	pubKeyHash := wallet.PublicKeyToHash(w.PublicKey)
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
-
-	if !skipBalanceCheck {
-		if acc < amount {
-			return nil, types.ErrNotEnoughFunds
+	total := 0
+	for _, p := range payments {
+		if p.Amount <= 0 || p.Amount > math.MaxInt-total {
+			return nil, 0, ErrInvalidOutputValue
 		}
+		total += p.Amount
+
+		out := NewTXOutput(p.Amount, p.To)
+		out.Expiry = p.Expiry
+		out.Asset = p.Asset
+		outputs = append(outputs, *out)
+	}
+
+	return outputs, total, nil
+}
+
+// NewTransaction makes the payments from the wallet in one transaction that
+// leaves fee, in the native token, to the miner, returning any excess of the
+// spent outputs to the wallet in one change output per asset. It returns
+// ErrNotEnoughFunds when the wallet's unspent outputs of an asset do not cover
+// the payments of it and, for the native token, the fee. Outputs expire no
+// later than the earliest expiring output spent.
+func NewTransaction(w *wallet.Wallet, payments []Payment, fee int, UTXO *UTXOSet) (*Transaction, error) {
+	var inputs []TxInput
+
+	outputs, total, err := paymentOutputs(payments)
+	if err != nil {
+		return nil, err
+	}
+
+	if fee < 0 || fee > math.MaxInt-total {
+		return nil, ErrInvalidFee
 	}
 
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		Handle(err)
+	// the amount of each asset, in the order the payments first name them
+	var assets [][]byte
+	amounts := make(map[string]int)
 
-		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
-			inputs = append(inputs, input)
+	for _, out := range outputs {
+		if _, ok := amounts[string(out.Asset)]; !ok {
+			assets = append(assets, out.Asset)
 		}
+		amounts[string(out.Asset)] += out.Value
 	}
 
+	if _, ok := amounts[""]; !ok && fee > 0 {
+		assets = append(assets, nil)
+	}
+	amounts[""] += fee
+
+	pubKeyHash := wallet.PublicKeyToHash(w.PublicKey)
 	from := fmt.Sprintf("%s", w.Address())
 
-	outputs = append(outputs, *NewTXOutput(amount, to))
+	for _, asset := range assets {
+		amount := amounts[string(asset)]
+
+		acc, validOutputs, err := UTXO.FindSpendableOutputs(pubKeyHash, asset, amount)
+		if err != nil {
+			return nil, err
+		}
+
+		if acc < amount {
+			return nil, types.ErrNotEnoughFunds
+		}
+
+		for txid, outs := range validOutputs {
+			txID, err := hex.DecodeString(txid)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, out := range outs {
+				input := TxInput{ID: txID, Out: out, PubKey: w.PublicKey}
+				inputs = append(inputs, input)
+			}
+		}
 
-	if skipBalanceCheck {
-		outputs = append(outputs, *NewTXOutput(0, from))
-	} else if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+		if acc > amount {
+			change := NewTXOutput(acc-amount, from)
+			change.Asset = asset
+			outputs = append(outputs, *change)
+		}
 	}
 
 	tx := Transaction{
+		Version: TxVersion,
 		ID:      nil,
 		Inputs:  inputs,
 		Outputs: outputs,
 	}
+
+	prevTXs, err := UTXO.Blockchain.prevTransactions(&tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the outputs expire with the earliest expiring input
+	if expiry := tx.inputExpiry(prevTXs); expiry != 0 {
+		for i := range tx.Outputs {
+			if out := &tx.Outputs[i]; out.Expiry == 0 || out.Expiry > expiry {
+				out.Expiry = expiry
+			}
+		}
+	}
+
+	tx.ID = tx.Hash()
+	if err := UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// NewMintTx creates new tokens for the payments, signed by the issuer wallet.
+// reference identifies the issuance, for example an order number, and may be
+// used only once per chain. The mint registers assets, whose IDs AssetID
+// returns; payments may issue those and the assets the issuer registered
+// before.
+func NewMintTx(issuer *wallet.Wallet, payments []Payment, reference string, assets ...Asset) (*Transaction, error) {
+	outputs, _, err := paymentOutputs(payments)
+	if err != nil {
+		return nil, err
+	}
+
+	if reference == "" || len(reference) > MaxReferenceLength {
+		return nil, ErrInvalidReference
+	}
+
+	txin := TxInput{ID: []byte(reference), Out: MintInput, PubKey: issuer.PublicKey}
+
+	tx := Transaction{
+		Version:  TxVersion,
+		Inputs:   []TxInput{txin},
+		Outputs:  outputs,
+		LockTime: time.Now().Unix(),
+		Assets:   assets,
+	}
 	tx.ID = tx.Hash()
-	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
+
+	if err := tx.SignInput(0, issuer.PrivateKey, tx.Issuer(), SigHashAll); err != nil {
+		return nil, err
+	}
 
 	return &tx, nil
 }
@@ -123,79 +292,329 @@ func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
-	if tx.IsCoinbase() {
-		return
+// IsMint reports whether tx issues new tokens rather than spending outputs.
+func (tx *Transaction) IsMint() bool {
+	return len(tx.Inputs) == 1 && tx.Inputs[0].Out == MintInput
+}
+
+// Issuer returns the public key hash of the key that signed a mint, freeze or
+// unfreeze.
+func (tx *Transaction) Issuer() []byte {
+	return wallet.PublicKeyToHash(tx.Inputs[0].PubKey)
+}
+
+// spendsOutputs reports whether the inputs of tx spend outputs, unlike those
+// of coinbases, mints, freezes and unfreezes.
+func (tx *Transaction) spendsOutputs() bool {
+	return !tx.IsCoinbase() && !tx.IsMint() && !tx.isControl()
+}
+
+// Reference returns the issuance reference of a mint.
+func (tx *Transaction) Reference() []byte {
+	return tx.Inputs[0].ID
+}
+
+// Sign signs every input with privKey, covering the whole transaction. prevTXs
+// must hold the transactions the inputs spend from, otherwise an error
+// wrapping ErrTxNotFound is returned. Inputs spending outputs with a locking
+// script are left to the caller, see ScriptSignature.
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+	if !tx.spendsOutputs() {
+		return nil
 	}
 
 	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+		prevTX := prevTXs[hex.EncodeToString(in.ID)]
+		if prevTX.ID == nil || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return fmt.Errorf("input %x:%d: %w", in.ID, in.Out, ErrTxNotFound)
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
+	for inId, in := range tx.Inputs {
+		out := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		if len(out.Script) > 0 {
+			continue
+		}
 
-	for inId, in := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
+		pubKeyHash := out.PubKeyHash
+
+		if tx.Version >= 2 {
+			if err := tx.SignInput(inId, privKey, pubKeyHash, SigHashAll); err != nil {
+				return err
+			}
+
+			continue
+		}
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+		txCopy := tx.TrimmedCopy()
+		txCopy.Inputs[inId].PubKey = pubKeyHash
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
-		Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.signatureData())
+		if err != nil {
+			return err
+		}
 
+		// fixed width, so that the halves split at r and s
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
 		tx.Inputs[inId].Signature = signature
-		txCopy.Inputs[inId].PubKey = nil
 	}
 
-	tx.LockTime = time.Now().Unix()
+	return nil
 }
 
+// Verify checks the input signatures, and scripts, against prevTXs. Inputs
+// spending outputs missing from prevTXs, or carrying malformed keys, do not
+// verify. A mint must be signed by the key in its input.
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+	return tx.verifyInputs(prevTXs) == nil
+}
+
+// verifyInputs returns ErrInvalidSignature, or an error wrapping
+// ErrScriptFailed for outputs with a locking script, when an input does not
+// unlock the output it spends.
+func (tx *Transaction) verifyInputs(prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction not correct")
+	if tx.IsMint() || tx.isControl() {
+		if !tx.verifyInput(0, tx.Issuer()) {
+			return ErrInvalidSignature
 		}
+
+		return nil
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	for _, in := range tx.Inputs {
+		prevTX := prevTXs[hex.EncodeToString(in.ID)]
+		if prevTX.ID == nil || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return ErrInvalidSignature
+		}
+	}
 
 	for inId, in := range tx.Inputs {
-		prevTx := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
+		out := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+
+		switch {
+		case len(out.Script) > 0:
+			vm := &scriptEngine{tx: tx, index: inId, subscript: out.Script}
+			if err := vm.verify(in.Script, out.Script); err != nil {
+				return fmt.Errorf("input %d: %w", inId, err)
+			}
+		case tx.Version < 2:
+			if !tx.verifyInput(inId, out.PubKeyHash) {
+				return ErrInvalidSignature
+			}
+		default:
+			if len(in.Script) > 0 {
+				return ErrInvalidSignature
+			}
+
+			// the pay-to-pubkey-hash template
+			vm := &scriptEngine{tx: tx, index: inId, subscript: out.PubKeyHash}
+			vm.stack = [][]byte{in.Signature, in.PubKey}
+
+			if vm.verify(nil, out.LockingScript()) != nil {
+				return ErrInvalidSignature
+			}
+		}
+	}
+
+	return nil
+}
+
+// verifySignature checks an r||s signature of data by the X||Y public key.
+func verifySignature(pubKey, signature, data []byte) bool {
+	if len(signature) == 0 || len(pubKey) == 0 {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+
+	return ecdsa.Verify(&rawPubKey, data, &r, &s)
+}
+
+// CheckSanity runs the checks that need nothing but the transaction itself:
+// its ID must pass checkID, it must have inputs and outputs, every output value
+// must be positive and no output may be spent twice. A mint must carry a valid reference. Scripts
+// must fit MaxScriptSize, and only version 3 and later may carry them. Output
+// expiries must not be negative, and only version 4 and later may carry them.
+// Assets must pass checkAssets. Freezes and unfreezes have no outputs and
+// must pass checkControl instead.
+func (tx *Transaction) CheckSanity() error {
+	if err := tx.checkID(); err != nil {
+		return err
+	}
+
+	if tx.isControl() {
+		return tx.checkControl()
+	}
+
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return ErrEmptyTransaction
+	}
+
+	if tx.IsMint() && (len(tx.Reference()) == 0 || len(tx.Reference()) > MaxReferenceLength) {
+		return ErrInvalidReference
+	}
+
+	total := 0
+	for _, out := range tx.Outputs {
+		if out.Value <= 0 || out.Value > math.MaxInt-total {
+			return ErrInvalidOutputValue
+		}
+		total += out.Value
+
+		if len(out.Script) > 0 && len(out.PubKeyHash) > 0 {
+			return ErrInvalidScript
+		}
+
+		if out.Expiry < 0 || (out.Expiry != 0 && !hasExpiry(tx.Version)) {
+			return ErrInvalidExpiry
+		}
+	}
+
+	if err := tx.checkAssets(); err != nil {
+		return err
+	}
+
+	for _, in := range tx.Inputs {
+		if !tx.scriptsFit(in.Script) {
+			return ErrInvalidScript
+		}
+	}
+
+	for _, out := range tx.Outputs {
+		if !tx.scriptsFit(out.Script) {
+			return ErrInvalidScript
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		outpoint := fmt.Sprintf("%x:%d", in.ID, in.Out)
+		if seen[outpoint] {
+			return ErrDuplicateInput
+		}
+		seen[outpoint] = true
+	}
+
+	return nil
+}
 
-		r := big.Int{}
-		s := big.Int{}
+// checkID checks that the ID of tx is its Hash. The IDs of version 0
+// transactions hashed their gob encoding and cannot be checked.
+func (tx *Transaction) checkID() error {
+	if tx.Version > 0 && !bytes.Equal(tx.ID, tx.Hash()) {
+		return ErrInvalidTxID
+	}
+
+	return nil
+}
+
+// scriptsFit reports whether tx may carry script.
+func (tx *Transaction) scriptsFit(script []byte) bool {
+	if len(script) == 0 {
+		return true
+	}
+
+	return hasScripts(tx.Version) && len(script) <= MaxScriptSize
+}
+
+// Validate checks tx against the transactions its inputs spend from: on top
+// of CheckSanity, every input must exist in prevTXs, the inputs must cover the
+// outputs and the signatures and scripts must verify. Whether the inputs are still unspent,
+// or a mint's signer is an issuer, is up to the caller. The error wraps one of
+// the Err* sentinels.
+func (tx *Transaction) Validate(prevTXs map[string]Transaction) error {
+	if err := tx.CheckSanity(); err != nil {
+		return err
+	}
+
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	if tx.IsMint() || tx.isControl() {
+		return tx.verifyInputs(nil)
+	}
 
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
+	if _, err := tx.Fee(prevTXs); err != nil {
+		return err
+	}
+
+	return tx.verifyInputs(prevTXs)
+}
 
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
+// Fee returns what the native inputs of tx, spending from prevTXs, leave over
+// after its native outputs. Coinbases, mints and freezes have no fee. It returns
+// ErrMissingInput for an input missing from prevTXs, ErrAssetImbalance when
+// the outputs of another asset differ from its inputs and ErrValueImbalance
+// when the native outputs exceed the native inputs.
+func (tx *Transaction) Fee(prevTXs map[string]Transaction) (int, error) {
+	if !tx.spendsOutputs() {
+		return 0, nil
+	}
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+	inputTotal := 0
+	for _, in := range tx.Inputs {
+		prevTX, ok := prevTXs[hex.EncodeToString(in.ID)]
+		if !ok || in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return 0, fmt.Errorf("input %x:%d: %w", in.ID, in.Out, ErrMissingInput)
+		}
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
-			return false
+		if out := prevTX.Outputs[in.Out]; len(out.Asset) == 0 {
+			inputTotal += out.Value
 		}
-		txCopy.Inputs[inId].PubKey = nil
 	}
 
-	return true
+	if err := tx.checkConservation(prevTXs); err != nil {
+		return 0, err
+	}
+
+	outputs := 0
+	for _, out := range tx.Outputs {
+		if len(out.Asset) == 0 {
+			outputs += out.Value
+		}
+	}
+
+	if outputs <= inputTotal {
+		return inputTotal - outputs, nil
+	}
+
+	return 0, ErrValueImbalance
+}
+
+// IsFinal reports whether tx may be in a block with the given timestamp, that
+// is whether its LockTime has passed.
+func (tx *Transaction) IsFinal(timestamp int64) bool {
+	return tx.LockTime <= timestamp
+}
+
+// OutputTotal returns the sum of the output values.
+func (tx *Transaction) OutputTotal() int {
+	total := 0
+	for _, out := range tx.Outputs {
+		total += out.Value
+	}
+
+	return total
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
@@ -203,22 +622,38 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{ID: in.ID, Out: in.Out})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{Value: out.Value, PubKeyHash: out.PubKeyHash, Script: out.Script, Expiry: out.Expiry, Asset: out.Asset})
 	}
 
 	txCopy := Transaction{
+		Version: tx.Version,
 		ID:      nil,
 		Inputs:  inputs,
 		Outputs: outputs,
+		Assets:  tx.Assets,
 	}
 
 	return txCopy
 }
 
+// signatureData is what an input signature of a version 0 or 1 transaction
+// covers, given the trimmed copy with the input's PubKey set to the spent
+// output's PubKeyHash. Version 0 transactions sign their printed form,
+// version 1 the SHA-256 of the canonical encoding.
+func (tx *Transaction) signatureData() []byte {
+	if tx.Version == 0 {
+		return []byte(fmt.Sprintf("%x\n", *tx))
+	}
+
+	hash := sha256.Sum256(tx.Serialize())
+
+	return hash[:]
+}
+
 func (tx Transaction) String() string {
 	var lines []string
 
@@ -229,12 +664,29 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
 		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
 		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if len(input.Script) > 0 {
+			lines = append(lines, fmt.Sprintf("       Script:    %x", input.Script))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %x", output.LockingScript()))
+		if output.Expiry != 0 {
+			lines = append(lines, fmt.Sprintf("       Expiry: %s", time.Unix(output.Expiry, 0).UTC().Format(time.RFC3339)))
+		}
+		if len(output.Asset) > 0 {
+			lines = append(lines, fmt.Sprintf("       Asset:  %x", output.Asset))
+		}
+	}
+
+	for i, asset := range tx.Assets {
+		lines = append(lines, fmt.Sprintf("     Asset %d:", i))
+		lines = append(lines, fmt.Sprintf("       ID:           %x", AssetID(tx.Issuer(), asset)))
+		lines = append(lines, fmt.Sprintf("       Product:      %s", asset.Product))
+		lines = append(lines, fmt.Sprintf("       Manufacturer: %s", asset.Manufacturer))
+		lines = append(lines, fmt.Sprintf("       Lot:          %s", asset.Lot))
 	}
 
 	return strings.Join(lines, "\n")
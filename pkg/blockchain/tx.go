@@ -2,25 +2,48 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
+	"fmt"
 
 	wallet2 "github.com/swagftw/covax19-blockchain/pkg/wallet"
 )
 
+// TxOutput is locked either to the key hashing to PubKeyHash, or, when
+// Script is set, by that locking script. Once the Unix time Expiry, when not
+// zero, has passed the output may only be spent to the disposal address.
+// Value counts units of the asset with ID Asset, or of the native token when
+// Asset is empty.
 type TxOutput struct {
 	Value      int
 	PubKeyHash []byte
+	Script     []byte
+	Expiry     int64
+	Asset      []byte
+}
+
+// outputEqual reports whether a and b are the same output, field by field.
+func outputEqual(a, b TxOutput) bool {
+	return a.Value == b.Value &&
+		bytes.Equal(a.PubKeyHash, b.PubKeyHash) &&
+		bytes.Equal(a.Script, b.Script) &&
+		a.Expiry == b.Expiry &&
+		bytes.Equal(a.Asset, b.Asset)
 }
 
 type TxOutputs struct {
 	Outputs []TxOutput
+	// Indexes holds the position of each output in its transaction. It is nil
+	// for entries written before outputs were spent individually.
+	Indexes []int
 }
 
+// TxInput spends output Out of transaction ID. Outputs locked to a key are
+// unlocked by Signature and PubKey, outputs with a locking script by Script.
 type TxInput struct {
 	ID        []byte
 	Out       int
 	Signature []byte
 	PubKey    []byte
+	Script    []byte
 }
 
 func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
@@ -36,28 +59,167 @@ func (out *TxOutput) Lock(address []byte) {
 }
 
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	return len(out.Script) == 0 && bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+}
+
+// LockingScript returns the script that locks out: its Script, or the
+// pay-to-pubkey-hash template for PubKeyHash.
+func (out *TxOutput) LockingScript() []byte {
+	if len(out.Script) > 0 {
+		return out.Script
+	}
+
+	return P2PKHScript(out.PubKeyHash)
+}
+
+// subscript is what input signatures spending out commit to in place of the
+// input's PubKey: the locking script, or just PubKeyHash for the template.
+func (out *TxOutput) subscript() []byte {
+	if len(out.Script) > 0 {
+		return out.Script
+	}
+
+	return out.PubKeyHash
 }
 
 func NewTXOutput(value int, address string) *TxOutput {
-	txo := &TxOutput{value, nil}
+	txo := &TxOutput{Value: value}
 	txo.Lock([]byte(address))
 
 	return txo
 }
 
+// NewScriptOutput returns an output of value locked by script.
+func NewScriptOutput(value int, script []byte) *TxOutput {
+	return &TxOutput{Value: value, Script: script}
+}
+
+// Index returns the position in its transaction of the i-th unspent output.
+func (outs TxOutputs) Index(i int) int {
+	if outs.Indexes == nil {
+		return i
+	}
+
+	return outs.Indexes[i]
+}
+
+// Serialize returns the canonical encoding of the outputs.
 func (outs TxOutputs) Serialize() []byte {
-	var buffer bytes.Buffer
-	encode := gob.NewEncoder(&buffer)
-	err := encode.Encode(outs)
-	Handle(err)
-	return buffer.Bytes()
+	e := newEncoder()
+
+	// in the version 0 layout, scripts and expiries follow
+	e.count(len(outs.Outputs))
+	for i := range outs.Outputs {
+		e.output(&outs.Outputs[i], 0)
+	}
+
+	e.count(len(outs.Indexes))
+	for _, index := range outs.Indexes {
+		e.int(int64(index))
+	}
+
+	scripts, expiries, assets := outs.extensions()
+
+	if scripts || expiries || assets {
+		e.count(len(outs.Outputs))
+		for i := range outs.Outputs {
+			e.bytes(outs.Outputs[i].Script)
+		}
+	}
+
+	if expiries || assets {
+		e.count(len(outs.Outputs))
+		for i := range outs.Outputs {
+			e.int(outs.Outputs[i].Expiry)
+		}
+	}
+
+	if assets {
+		e.count(len(outs.Outputs))
+		for i := range outs.Outputs {
+			e.bytes(outs.Outputs[i].Asset)
+		}
+	}
+
+	return e.buf.Bytes()
+}
+
+// extensions reports whether any output has a script, whether any expires and
+// whether any holds an asset.
+func (outs TxOutputs) extensions() (scripts, expiries, assets bool) {
+	for _, out := range outs.Outputs {
+		scripts = scripts || len(out.Script) > 0
+		expiries = expiries || out.Expiry != 0
+		assets = assets || len(out.Asset) > 0
+	}
+
+	return scripts, expiries, assets
 }
 
-func DeserializeOutputs(data []byte) TxOutputs {
+// DeserializeOutputs decodes outputs in the canonical or the legacy gob encoding.
+func DeserializeOutputs(data []byte) (TxOutputs, error) {
 	var outputs TxOutputs
-	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&outputs)
-	Handle(err)
-	return outputs
+
+	if isCanonical(data) {
+		d := newDecoder(data)
+
+		if n := d.count(12); n > 0 {
+			outputs.Outputs = make([]TxOutput, n)
+			for i := range outputs.Outputs {
+				outputs.Outputs[i] = d.output(0)
+			}
+		}
+
+		if n := d.count(8); n > 0 {
+			outputs.Indexes = make([]int, n)
+			for i := range outputs.Indexes {
+				outputs.Indexes[i] = int(d.int())
+			}
+		}
+
+		// scripts, when any output has one
+		if d.err == nil && len(d.data) > 0 {
+			if n := d.count(4); n != len(outputs.Outputs) {
+				d.err = fmt.Errorf("%d scripts for %d outputs", n, len(outputs.Outputs))
+			}
+
+			for i := range outputs.Outputs {
+				outputs.Outputs[i].Script = d.bytes()
+			}
+		}
+
+		// expiries, when any output expires
+		if d.err == nil && len(d.data) > 0 {
+			if n := d.count(8); n != len(outputs.Outputs) {
+				d.err = fmt.Errorf("%d expiries for %d outputs", n, len(outputs.Outputs))
+			}
+
+			for i := range outputs.Outputs {
+				outputs.Outputs[i].Expiry = d.int()
+			}
+		}
+
+		// assets, when any output holds one
+		if d.err == nil && len(d.data) > 0 {
+			if n := d.count(4); n != len(outputs.Outputs) {
+				d.err = fmt.Errorf("%d assets for %d outputs", n, len(outputs.Outputs))
+			}
+
+			for i := range outputs.Outputs {
+				outputs.Outputs[i].Asset = d.bytes()
+			}
+		}
+
+		if err := d.finish(); err != nil {
+			return TxOutputs{}, corrupt("outputs", err)
+		}
+
+		return outputs, nil
+	}
+
+	if err := decodeGob(data, &outputs); err != nil {
+		return TxOutputs{}, corrupt("outputs", err)
+	}
+
+	return outputs, nil
 }
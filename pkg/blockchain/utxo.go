@@ -3,13 +3,15 @@ package blockchain
 import (
 	"bytes"
 	"encoding/hex"
-	"log"
-
-	"github.com/dgraph-io/badger"
+	"fmt"
+	"sort"
+	"time"
 )
 
 var (
 	utxoPrefix   = []byte("utxo-")
+	undoPrefix   = []byte("undo-")
+	utxoMetaKey  = []byte("utxo-meta")
 	prefixLength = len(utxoPrefix)
 )
 
@@ -17,219 +19,394 @@ type UTXOSet struct {
 	Blockchain *BlockChain
 }
 
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+// FindSpendableOutputs picks unspent, unexpired outputs of asset locked to
+// pubKeyHash worth at least amount, if the wallet has that much. A nil asset
+// selects the native token.
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash, asset []byte, amount int) (int, map[string][]int, error) {
 	unspentOuts := make(map[string][]int)
 	accumulated := 0
 	db := u.Blockchain.Database
+	now := time.Now().Unix()
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			var v []byte
-			err := item.Value(func(val []byte) error {
-				v = val
+	err := db.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(utxoPrefix, func(k, v []byte) error {
+			if isUTXOMeta(k) {
 				return nil
-			})
-			Handle(err)
+			}
 			k = bytes.TrimPrefix(k, utxoPrefix)
 			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
 
 			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
+				if out.IsLockedWithKey(pubKeyHash) && bytes.Equal(out.Asset, asset) && !out.IsExpired(now) && accumulated < amount {
 					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+					unspentOuts[txID] = append(unspentOuts[txID], outs.Index(outIdx))
 				}
 			}
-		}
-		return nil
+
+			return nil
+		})
 	})
-	Handle(err)
+	if err != nil {
+		return 0, nil, err
+	}
 
-	return accumulated, unspentOuts
+	return accumulated, unspentOuts, nil
 }
 
-func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
+// FindUnspentTransactions returns the unspent outputs of asset, or of the
+// native token when it is nil, locked to pubKeyHash. Expired outputs, which
+// can only be disposed of, are left out.
+func (u UTXOSet) FindUnspentTransactions(pubKeyHash, asset []byte) ([]TxOutput, error) {
 	var UTXOs []TxOutput
 
 	db := u.Blockchain.Database
+	now := time.Now().Unix()
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			var v []byte
-			err := item.Value(func(val []byte) error {
-				v = val
-
+	err := db.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(utxoPrefix, func(k, v []byte) error {
+			if isUTXOMeta(k) {
 				return nil
-			})
-			Handle(err)
-			outs := DeserializeOutputs(v)
+			}
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
 			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
+				if out.IsLockedWithKey(pubKeyHash) && bytes.Equal(out.Asset, asset) && !out.IsExpired(now) {
 					UTXOs = append(UTXOs, out)
 				}
 			}
 
-		}
-		return nil
+			return nil
+		})
 	})
-	Handle(err)
 
-	return UTXOs
+	return UTXOs, err
 }
 
-func (u UTXOSet) CountTransactions() int {
-	db := u.Blockchain.Database
-	counter := 0
+// IsUnspent reports whether output index of transaction txID is in the UTXO set.
+func (u UTXOSet) IsUnspent(txID []byte, index int) (bool, error) {
+	unspent := false
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := u.Blockchain.Database.View(func(txn StoreTxn) error {
+		outs, _, err := getOutputs(txn, utxoKey(txID))
+		if err != nil {
+			return err
+		}
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			counter++
+		for i := range outs.Outputs {
+			if outs.Index(i) == index {
+				unspent = true
+			}
 		}
 
 		return nil
 	})
 
-	Handle(err)
+	return unspent, err
+}
+
+func (u UTXOSet) CountTransactions() (int, error) {
+	db := u.Blockchain.Database
+	counter := 0
 
-	return counter
+	err := db.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(utxoPrefix, func(k, _ []byte) error {
+			if !isUTXOMeta(k) {
+				counter++
+			}
+
+			return nil
+		})
+	})
+
+	return counter, err
 }
 
-func (u UTXOSet) Reindex() {
+// Reindex rebuilds the UTXO set from the whole chain. Blocks keep it up to
+// date incrementally, so this is only needed to repair a damaged set.
+func (u UTXOSet) Reindex() error {
 	db := u.Blockchain.Database
 
-	u.DeleteByPrefix(utxoPrefix)
+	// utxo-meta shares the prefix, so an interrupted rebuild leaves no meta behind.
+	if err := u.DeleteByPrefix(utxoPrefix); err != nil {
+		return err
+	}
 
-	UTXO := u.Blockchain.FindUTXO()
+	UTXO, err := u.Blockchain.FindUTXO()
+	if err != nil {
+		return err
+	}
 
-	err := db.Update(func(txn *badger.Txn) error {
+	header, err := u.Blockchain.GetBlockHeader(u.Blockchain.LastHash)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(txn StoreTxn) error {
 		for txId, outs := range UTXO {
 			key, err := hex.DecodeString(txId)
-			Handle(err)
-			key = append(utxoPrefix, key...)
+			if err != nil {
+				return err
+			}
 
-			err = txn.Set(key, outs.Serialize())
-			Handle(err)
+			if err := txn.Set(utxoKey(key), outs.Serialize()); err != nil {
+				return err
+			}
 		}
 
-		return nil
+		return setUTXOMeta(txn, header.Height, u.Blockchain.LastHash)
 	})
-	Handle(err)
 }
 
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
+// Update applies block to the UTXO set and stores its undo record.
+// The block inputs must be unspent.
+func (u *UTXOSet) Update(block *Block) error {
+	return u.Blockchain.Database.Update(func(txn StoreTxn) error {
+		return connectUTXO(txn, block)
+	})
+}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					Handle(err)
-					var v []byte
-					err = item.Value(func(val []byte) error {
-						v = val
-
-						return nil
-					})
-					Handle(err)
-
-					outs := DeserializeOutputs(v)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+// Revert undoes Update for block, which must be the last block applied.
+func (u *UTXOSet) Revert(block *Block) error {
+	return u.Blockchain.Database.Update(func(txn StoreTxn) error {
+		return disconnectUTXO(txn, block)
+	})
+}
 
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
-				}
-			}
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
+// Meta returns the height and tip hash of the chain the UTXO set reflects.
+// ok is false when the set has no meta, for example while it is rebuilt.
+func (u UTXOSet) Meta() (meta UTXOMeta, ok bool, err error) {
+	err = u.Blockchain.Database.View(func(txn StoreTxn) error {
+		val, err := txn.Get(utxoMetaKey)
+		if err != nil {
+			return err
+		}
 
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
-			}
+		if err := decodeGob(val, &meta); err != nil {
+			return corrupt("utxo meta", err)
 		}
 
 		return nil
 	})
-	Handle(err)
+	if err == ErrKeyNotFound {
+		return meta, false, nil
+	}
+
+	return meta, err == nil, err
 }
 
-func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
-	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
-			for _, key := range keysForDelete {
+func (u *UTXOSet) DeleteByPrefix(prefix []byte) error {
+	var keys [][]byte
+
+	err := u.Blockchain.Database.View(func(txn StoreTxn) error {
+		return txn.IteratePrefix(prefix, func(key, _ []byte) error {
+			keys = append(keys, key)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	collectSize := 100000
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > collectSize {
+			batch = batch[:collectSize]
+		}
+		keys = keys[len(batch):]
+
+		err := u.Blockchain.Database.Update(func(txn StoreTxn) error {
+			for _, key := range batch {
 				if err := txn.Delete(key); err != nil {
 					return err
 				}
 			}
 
 			return nil
-		}); err != nil {
+		})
+		if err != nil {
 			return err
 		}
+	}
 
-		return nil
+	return nil
+}
+
+// SpentOutput records an output consumed by a block so that it can be restored
+// when the block is disconnected.
+type SpentOutput struct {
+	TxID   []byte
+	Index  int
+	Output TxOutput
+}
+
+type undoRecord struct {
+	Spent []SpentOutput
+}
+
+// UTXOMeta identifies the chain tip the UTXO set was built for.
+type UTXOMeta struct {
+	Height int
+	Tip    []byte
+}
+
+func isUTXOMeta(key []byte) bool {
+	return bytes.Equal(key, utxoMetaKey)
+}
+
+func setUTXOMeta(txn StoreTxn, height int, tip []byte) error {
+	return txn.Set(utxoMetaKey, encodeGob(UTXOMeta{Height: height, Tip: tip}))
+}
+
+func undoKey(blockHash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), blockHash...)
+}
+
+func utxoKey(txID []byte) []byte {
+	return append(append([]byte{}, utxoPrefix...), txID...)
+}
+
+func getOutputs(txn StoreTxn, key []byte) (TxOutputs, bool, error) {
+	val, err := txn.Get(key)
+	if err == ErrKeyNotFound {
+		return TxOutputs{}, false, nil
+	}
+	if err != nil {
+		return TxOutputs{}, false, err
 	}
 
-	collectSize := 100000
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		keysForDelete := make([][]byte, 0, collectSize)
-		keysCollected := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().KeyCopy(nil)
-			keysForDelete = append(keysForDelete, key)
-			keysCollected++
-			if keysCollected == collectSize {
-				if err := deleteKeys(keysForDelete); err != nil {
-					log.Panic(err)
+	outs, err := DeserializeOutputs(val)
+
+	return outs, err == nil, err
+}
+
+func putOutputs(txn StoreTxn, key []byte, outs TxOutputs) error {
+	if len(outs.Outputs) == 0 {
+		return txn.Delete(key)
+	}
+
+	return txn.Set(key, outs.Serialize())
+}
+
+// connectUTXO spends the inputs and adds the outputs of block to the UTXO set
+// and stores the undo record needed to disconnect it again.
+func connectUTXO(txn StoreTxn, block *Block) error {
+	var undo undoRecord
+
+	for _, tx := range block.Transactions {
+		if tx.spendsOutputs() {
+			for _, in := range tx.Inputs {
+				key := utxoKey(in.ID)
+				outs, ok, err := getOutputs(txn, key)
+				if err != nil {
+					return err
+				}
+
+				spent := -1
+				for outIdx := range outs.Outputs {
+					if outs.Index(outIdx) == in.Out {
+						spent = outIdx
+					}
+				}
+
+				if !ok || spent == -1 {
+					return fmt.Errorf("tx %x spends %x:%d: %w", tx.ID, in.ID, in.Out, ErrMissingInput)
+				}
+
+				undo.Spent = append(undo.Spent, SpentOutput{TxID: in.ID, Index: in.Out, Output: outs.Outputs[spent]})
+
+				updated := TxOutputs{}
+				for outIdx, out := range outs.Outputs {
+					if outIdx != spent {
+						updated.Outputs = append(updated.Outputs, out)
+						updated.Indexes = append(updated.Indexes, outs.Index(outIdx))
+					}
+				}
+
+				if err := putOutputs(txn, key, updated); err != nil {
+					return err
 				}
-				keysForDelete = make([][]byte, 0, collectSize)
-				keysCollected = 0
 			}
 		}
-		if keysCollected > 0 {
-			if err := deleteKeys(keysForDelete); err != nil {
-				log.Panic(err)
+
+		newOutputs := TxOutputs{}
+		for outIdx, out := range tx.Outputs {
+			newOutputs.Outputs = append(newOutputs.Outputs, out)
+			newOutputs.Indexes = append(newOutputs.Indexes, outIdx)
+		}
+
+		if err := putOutputs(txn, utxoKey(tx.ID), newOutputs); err != nil {
+			return err
+		}
+	}
+
+	if err := txn.Set(undoKey(block.Hash), encodeGob(undo)); err != nil {
+		return err
+	}
+
+	return setUTXOMeta(txn, block.Height, block.Hash)
+}
+
+// disconnectUTXO reverts connectUTXO for block, which must be the current tip.
+func disconnectUTXO(txn StoreTxn, block *Block) error {
+	val, err := txn.Get(undoKey(block.Hash))
+	if err != nil {
+		return fmt.Errorf("undo data for block %x: %w", block.Hash, err)
+	}
+
+	var undo undoRecord
+	if err := decodeGob(val, &undo); err != nil {
+		return corrupt("undo data", err)
+	}
+
+	created := make(map[string]bool)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		created[hex.EncodeToString(block.Transactions[i].ID)] = true
+		if err := txn.Delete(utxoKey(block.Transactions[i].ID)); err != nil {
+			return err
+		}
+	}
+
+	for _, spent := range undo.Spent {
+		if created[hex.EncodeToString(spent.TxID)] {
+			continue
+		}
+
+		key := utxoKey(spent.TxID)
+		outs, _, err := getOutputs(txn, key)
+		if err != nil {
+			return err
+		}
+
+		if outs.Indexes == nil {
+			for outIdx := range outs.Outputs {
+				outs.Indexes = append(outs.Indexes, outIdx)
 			}
 		}
-		return nil
-	})
+
+		pos := sort.SearchInts(outs.Indexes, spent.Index)
+		outs.Outputs = append(outs.Outputs, TxOutput{})
+		copy(outs.Outputs[pos+1:], outs.Outputs[pos:])
+		outs.Outputs[pos] = spent.Output
+		outs.Indexes = append(outs.Indexes, 0)
+		copy(outs.Indexes[pos+1:], outs.Indexes[pos:])
+		outs.Indexes[pos] = spent.Index
+
+		if err := putOutputs(txn, key, outs); err != nil {
+			return err
+		}
+	}
+
+	if err := txn.Delete(undoKey(block.Hash)); err != nil {
+		return err
+	}
+
+	return setUTXOMeta(txn, block.Height-1, block.PrevHash)
 }
@@ -0,0 +1,266 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxFutureBlockTime is how far ahead of the local clock a block timestamp may be.
+const maxFutureBlockTime = 2 * time.Hour
+
+var (
+	ErrNoTransactions     = errors.New("block has no transactions")
+	ErrInvalidHash        = errors.New("block hash does not match its header")
+	ErrInvalidMerkleRoot  = errors.New("block Merkle root does not match its transactions")
+	ErrInvalidPoW         = errors.New("block hash does not satisfy the proof of work target")
+	ErrInvalidDifficulty  = errors.New("block difficulty is not the expected difficulty")
+	ErrInvalidTimestamp   = errors.New("block timestamp is out of range")
+	ErrOrphanBlock        = errors.New("block parent is unknown")
+	ErrInvalidHeight      = errors.New("block height does not follow its parent")
+	ErrInvalidCoinbase    = errors.New("block must contain exactly one coinbase transaction")
+	ErrMissingInput       = errors.New("transaction input references an unknown output")
+	ErrInvalidSignature   = errors.New("transaction signature is invalid")
+	ErrValueImbalance     = errors.New("transaction outputs exceed its inputs")
+	ErrInvalidOutputValue = errors.New("transaction output value must be positive")
+	ErrDuplicateInput     = errors.New("transaction spends the same output twice")
+	ErrEmptyTransaction   = errors.New("transaction has no inputs or no outputs")
+	ErrBlockTooLarge      = errors.New("block exceeds the maximum block size")
+	ErrExcessiveCoinbase  = errors.New("coinbase pays more than the block subsidy and fees")
+	ErrLockTime           = errors.New("transaction lock time has not passed")
+	ErrStaleTip           = errors.New("chain tip moved while the block was mined")
+	ErrInvalidTxID        = errors.New("transaction ID is not the hash of the transaction")
+	ErrDuplicateTxID      = errors.New("transaction ID is already on the chain")
+	ErrLegacyTransaction  = errors.New("transaction version is no longer accepted")
+)
+
+// ValidateBlock checks a block that extends the current tip.
+// It returns an error wrapping one of the Err* sentinels when the block is rejected.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	if err := chain.validateHeader(block); err != nil {
+		return err
+	}
+
+	return chain.validateTransactions(block)
+}
+
+// validateHeader runs the checks that do not depend on the UTXO state, so that
+// blocks of side branches can be checked before they are stored.
+func (chain *BlockChain) validateHeader(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return blockError(block, ErrNoTransactions)
+	}
+
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return blockError(block, ErrInvalidMerkleRoot)
+	}
+
+	if block.Timestamp > time.Now().Add(maxFutureBlockTime).Unix() {
+		return blockError(block, ErrInvalidTimestamp)
+	}
+
+	parent, err := chain.GetBlockHeader(block.PrevHash)
+	if err != nil {
+		return blockError(block, ErrOrphanBlock)
+	}
+
+	if block.Height != parent.Height+1 {
+		return blockError(block, ErrInvalidHeight)
+	}
+
+	if block.Timestamp < parent.Timestamp {
+		return blockError(block, ErrInvalidTimestamp)
+	}
+
+	if err := chain.Engine.VerifySeal(chain, parent, block); err != nil {
+		return blockError(block, err)
+	}
+
+	coinbases := 0
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			coinbases++
+		}
+	}
+
+	if coinbases != 1 {
+		return blockError(block, ErrInvalidCoinbase)
+	}
+
+	return nil
+}
+
+// validateTransactions checks the block transactions against the chain ending
+// at the current tip. Inputs must spend outputs that are unspent at the tip or
+// created earlier in the block, no output may be spent twice, and the coinbase
+// may claim no more than the block subsidy and the fees of the block. The lock
+// time of every transaction must have passed at the block timestamp, and
+// transactions older than MinTxVersion are only valid below the legacy height.
+func (chain *BlockChain) validateTransactions(block *Block) error {
+	if len(block.Serialize()) > MaxBlockSize {
+		return blockError(block, ErrBlockTooLarge)
+	}
+
+	inBlock := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	fees := 0
+
+	var coinbase *Transaction
+
+	for _, tx := range block.Transactions {
+		if err := chain.checkTxVersion(tx, block.Height); err != nil {
+			return blockError(block, err)
+		}
+
+		if !tx.IsFinal(block.Timestamp) {
+			return blockError(block, fmt.Errorf("tx %x: %w", tx.ID, ErrLockTime))
+		}
+
+		fee, err := chain.validateTransaction(tx, inBlock, spent, block.Timestamp)
+		if err != nil {
+			return blockError(block, err)
+		}
+
+		if tx.IsCoinbase() {
+			coinbase = tx
+		}
+
+		fees += fee
+		inBlock[hex.EncodeToString(tx.ID)] = *tx
+	}
+
+	if coinbase != nil && coinbase.OutputTotal() > BlockSubsidy+fees {
+		return blockError(block, ErrExcessiveCoinbase)
+	}
+
+	return nil
+}
+
+// validateTransaction checks tx, in a block with the given timestamp, marks
+// the outputs it spends in spent and returns its fee.
+// Inputs may reference the earlier transactions of the same block through
+// inBlock, otherwise they must be in the UTXO set. Mints record their
+// issuance reference and assets in spent, freezes and unfreezes the state
+// they leave the asset in.
+func (chain *BlockChain) validateTransaction(tx *Transaction, inBlock map[string]Transaction, spent map[string]bool, timestamp int64) (int, error) {
+	if err := chain.checkNewTxID(tx, inBlock); err != nil {
+		return 0, fmt.Errorf("tx %x: %w", tx.ID, err)
+	}
+
+	if tx.IsCoinbase() {
+		if err := tx.CheckSanity(); err != nil {
+			return 0, fmt.Errorf("tx %x: %w", tx.ID, err)
+		}
+
+		return 0, nil
+	}
+
+	if tx.IsMint() {
+		if err := chain.validateMint(tx, spent); err != nil {
+			return 0, fmt.Errorf("tx %x: %w", tx.ID, err)
+		}
+
+		return 0, nil
+	}
+
+	if tx.isControl() {
+		if err := chain.validateControl(tx, spent, true); err != nil {
+			return 0, fmt.Errorf("tx %x: %w", tx.ID, err)
+		}
+
+		return 0, nil
+	}
+
+	prevTXs := make(map[string]Transaction)
+	utxo := UTXOSet{chain}
+
+	for _, in := range tx.Inputs {
+		id := hex.EncodeToString(in.ID)
+
+		outpoint := fmt.Sprintf("%s:%d", id, in.Out)
+		if spent[outpoint] {
+			return 0, fmt.Errorf("tx %x: input %s: %w", tx.ID, outpoint, ErrDoubleSpend)
+		}
+
+		prevTX, ok := inBlock[id]
+		if !ok {
+			var err error
+
+			prevTX, err = chain.FindTransaction(in.ID)
+			if err != nil {
+				return 0, fmt.Errorf("tx %x: input %s: %w", tx.ID, outpoint, ErrMissingInput)
+			}
+
+			unspent, err := utxo.IsUnspent(in.ID, in.Out)
+			if err != nil {
+				return 0, err
+			}
+			if !unspent {
+				return 0, fmt.Errorf("tx %x: input %s: %w", tx.ID, outpoint, ErrDoubleSpend)
+			}
+		}
+
+		prevTXs[id] = prevTX
+	}
+
+	if err := tx.Validate(prevTXs); err != nil {
+		return 0, fmt.Errorf("tx %x: %w", tx.ID, err)
+	}
+
+	if err := chain.checkExpiry(tx, prevTXs, timestamp); err != nil {
+		return 0, fmt.Errorf("tx %x: %w", tx.ID, err)
+	}
+
+	if err := chain.checkFrozen(tx, prevTXs, spent, true); err != nil {
+		return 0, fmt.Errorf("tx %x: %w", tx.ID, err)
+	}
+
+	for _, in := range tx.Inputs {
+		spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+	}
+
+	return tx.Fee(prevTXs)
+}
+
+// checkTxVersion returns ErrLegacyTransaction when tx is older than
+// MinTxVersion and the block at height is not below the legacy height.
+func (chain *BlockChain) checkTxVersion(tx *Transaction, height int) error {
+	if tx.Version < MinTxVersion && height >= chain.legacyTxHeight {
+		return fmt.Errorf("tx %x: %w", tx.ID, ErrLegacyTransaction)
+	}
+
+	return nil
+}
+
+func blockError(block *Block, err error) error {
+	return fmt.Errorf("block %x: %w", block.Hash, err)
+}
+
+// checkNewTxID rejects tx when its ID is not its hash or is taken by an earlier transaction of
+// the block, in inBlock, or of the main chain. Storing it would overwrite the
+// unspent outputs and the index entry of the other transaction.
+func (chain *BlockChain) checkNewTxID(tx *Transaction, inBlock map[string]Transaction) error {
+	if err := tx.checkID(); err != nil {
+		return err
+	}
+
+	if _, ok := inBlock[hex.EncodeToString(tx.ID)]; ok {
+		return ErrDuplicateTxID
+	}
+
+	return chain.Database.View(func(txn StoreTxn) error {
+		for _, key := range [][]byte{utxoKey(tx.ID), txIndexKey(tx.ID)} {
+			_, err := txn.Get(key)
+			if err == nil {
+				return ErrDuplicateTxID
+			}
+			if err != ErrKeyNotFound {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
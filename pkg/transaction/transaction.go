@@ -11,9 +11,12 @@ import (
 	"github.com/swagftw/covax19-blockchain/pkg/blockchain/network"
 	"github.com/swagftw/covax19-blockchain/types"
 	"github.com/swagftw/covax19-blockchain/utl/server"
+	"github.com/swagftw/covax19-blockchain/utl/server/fault"
 	tx "github.com/swagftw/covax19-blockchain/utl/transaction"
 )
 
+var errNoPayments = fault.New("ERR_NO_PAYMENTS", "at least one payment is required", http.StatusBadRequest)
+
 type service struct {
 	repo       Repository
 	tx         tx.Transaction
@@ -47,6 +50,27 @@ func (s service) GetTotalVaccinatedCitizens(ctx context.Context) (int, error) {
 }
 
 func (s service) Send(ctx context.Context, dto *types.SendTokens) error {
+	return s.SendBatch(ctx, &types.SendBatch{
+		From:     dto.From,
+		Payments: []*types.Payment{{To: dto.To, Amount: dto.Amount, Expiry: dto.Expiry, Asset: dto.Asset}},
+		Fee:      dto.Fee,
+	})
+}
+
+// SendBatch records every payment and makes them in one chain transaction.
+// Payments are addressed by user email. When the node rejects the transaction
+// no payment is recorded.
+func (s service) SendBatch(ctx context.Context, dto *types.SendBatch) error {
+	if len(dto.Payments) == 0 {
+		return errNoPayments
+	}
+
+	for _, payment := range dto.Payments {
+		if payment == nil {
+			return errNoPayments
+		}
+	}
+
 	err := s.tx.Run(ctx, func(ctx context.Context) error {
 		// get sender by address
 		userFrom, err := s.usrService.GetUserByEmail(ctx, dto.From)
@@ -54,37 +78,62 @@ func (s service) Send(ctx context.Context, dto *types.SendTokens) error {
 			return err
 		}
 
-		userTo, err := s.usrService.GetUserByEmail(ctx, dto.To)
-		if err != nil {
-			return err
+		payments := make([]*types.Payment, 0, len(dto.Payments))
+		ids := make([]uint, 0, len(dto.Payments))
+
+		for _, payment := range dto.Payments {
+			userTo, err := s.usrService.GetUserByEmail(ctx, payment.To)
+			if err != nil {
+				return err
+			}
+
+			txn := &types.Transaction{
+				FromAddress: userFrom.WalletAddress,
+				ToAddress:   userTo.WalletAddress,
+				Amount:      payment.Amount,
+			}
+
+			txn, err = s.repo.SaveTransaction(ctx, txn)
+			if err != nil {
+				return err
+			}
+
+			payments = append(payments, &types.Payment{To: userTo.WalletAddress, Amount: payment.Amount, Expiry: payment.Expiry, Asset: payment.Asset})
+			ids = append(ids, txn.ID)
 		}
 
+		// the government issues new tokens, referenced by the saved transactions.
 		if userFrom.Type == types.UserTypeGovernment {
-			dto.SkipBalanceCheck = true
-		}
+			endpoint := fmt.Sprintf("http://%s/v1/transactions/mint", network.KnownNodes[0])
 
-		txn := &types.Transaction{
-			FromAddress: userFrom.WalletAddress,
-			ToAddress:   userTo.WalletAddress,
-			Amount:      dto.Amount,
-		}
+			reference := fmt.Sprintf("transaction-%d", ids[0])
+			if len(ids) > 1 {
+				reference = fmt.Sprintf("transactions-%d-%d", ids[0], ids[len(ids)-1])
+			}
+
+			mint := &types.MintTokens{
+				Issuer:    userFrom.WalletAddress,
+				Reference: reference,
+				Payments:  payments,
+			}
+
+			// the node only mints for the government user the request was made by
+			_, err = server.SendAuthorizedRequest(http.MethodPost, endpoint, server.Authorization(ctx), mint)
 
-		txn, err = s.repo.SaveTransaction(ctx, txn)
-		if err != nil {
 			return err
 		}
 
-		endpoint := fmt.Sprintf("http://%s/v1/transactions/send", network.KnownNodes[0])
-
-		dto.From = userFrom.WalletAddress
-		dto.To = userTo.WalletAddress
+		endpoint := fmt.Sprintf("http://%s/v1/transactions/send-batch", network.KnownNodes[0])
 
-		_, err = server.SendRequest(http.MethodPost, endpoint, dto)
-		if err != nil {
-			return err
+		batch := &types.SendBatch{
+			From:     userFrom.WalletAddress,
+			Payments: payments,
+			Fee:      dto.Fee,
 		}
 
-		return nil
+		_, err = server.SendRequest(http.MethodPost, endpoint, batch)
+
+		return err
 	})
 
 	return err
@@ -31,8 +31,11 @@ func GenerateNewWallet() string {
 }
 
 func (w *Wallet) Address() []byte {
-	pubHash := PublicKeyToHash(w.PublicKey)
+	return HashToAddress(PublicKeyToHash(w.PublicKey))
+}
 
+// HashToAddress returns the address of the public key hash pubHash.
+func HashToAddress(pubHash []byte) []byte {
 	versionedHash := append([]byte{version}, pubHash...)
 	checksum := CheckSum(versionedHash)
 
@@ -48,7 +51,10 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 		log.Panic(err)
 	}
 
-	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
+	// fixed width, so that the halves split at X and Y
+	publicKey := make([]byte, 64)
+	privateKey.PublicKey.X.FillBytes(publicKey[:32])
+	privateKey.PublicKey.Y.FillBytes(publicKey[32:])
 
 	return *privateKey, publicKey
 }
@@ -1,6 +1,7 @@
 package blockchain
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -26,10 +27,20 @@ func NewHTTP(v1Group *echo.Group, userService types.UserService, jwtMiddleware e
 	chainGroup.POST("/wallets", h.createWallet)
 	chainGroup.GET("/wallets", h.getWallets)
 	chainGroup.GET("/wallets/balance/:address", h.getBalance)
+	chainGroup.GET("/wallets/expiring/:address", h.getExpiring)
+	chainGroup.GET("/wallets/assets/:address", h.getBalances)
+	chainGroup.GET("/assets/:id", h.getAsset)
+	chainGroup.GET("/assets/:id/holders", h.getHolders)
+	chainGroup.GET("/assets/:id/trace", h.getLotTrace)
+	chainGroup.GET("/tx/:id/trace/:out", h.getTrace)
 
 	// blockchain related handlers
 	chainGroup.POST("/:address", h.createBlockchain)
 	chainGroup.GET("", h.getBlockchain)
+	chainGroup.GET("/verify", h.verifyChain)
+	chainGroup.GET("/supply", h.getSupply)
+	chainGroup.GET("/supply/:address", h.getIssuerSupply)
+	chainGroup.GET("/estimatefee", h.estimateFee)
 }
 
 func (h *httpHandler) getBalance(ctx echo.Context) error {
@@ -39,6 +50,9 @@ func (h *httpHandler) getBalance(ctx echo.Context) error {
 	}
 
 	endpoint := fmt.Sprintf("http://%s/v1/chain/wallets/balance/%s", network.KnownNodes[0], address)
+	if query := ctx.QueryString(); query != "" {
+		endpoint += "?" + query
+	}
 
 	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
 
@@ -92,7 +106,8 @@ func (h *httpHandler) createBlockchain(ctx echo.Context) error {
 	return ctx.JSON(http.StatusCreated, resp)
 }
 
-// send creates a transaction.
+// send creates a transaction. Government wallets issue tokens through the
+// transaction service, which mints them.
 func (h *httpHandler) send(ctx echo.Context) error {
 	endpoint := fmt.Sprintf("http://%s/v1/transactions/send", network.KnownNodes[0])
 	sendTokens := new(types.SendTokens)
@@ -101,18 +116,35 @@ func (h *httpHandler) send(ctx echo.Context) error {
 		return err
 	}
 
-	// get sender by address
-	user, err := h.usrService.GetUserByWallet(server.ToGoContext(ctx), sendTokens.From)
+	resp, err := server.SendRequest(http.MethodPost, endpoint, sendTokens)
+
 	if err != nil {
 		return err
 	}
 
-	if user.Type == types.UserTypeGovernment {
-		sendTokens.SkipBalanceCheck = true
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// getBlockchain returns the blockchain, optionally limited by start and end heights.
+func (h *httpHandler) getBlockchain(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain", network.KnownNodes[0])
+	if query := ctx.QueryString(); query != "" {
+		endpoint += "?" + query
 	}
 
-	resp, err := server.SendRequest(http.MethodPost, endpoint, sendTokens)
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, err)
+	}
 
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// verifyChain returns the node's audit report of the whole chain.
+func (h *httpHandler) verifyChain(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/verify", network.KnownNodes[0])
+
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -120,13 +152,150 @@ func (h *httpHandler) send(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, resp)
 }
 
-// getBlockchain returns the blockchain.
-func (h *httpHandler) getBlockchain(ctx echo.Context) error {
-	endpoint := fmt.Sprintf("http://%s/v1/chain", network.KnownNodes[0])
+// getExpiring returns the expired and expiring tokens of an address, passing
+// on the query parameters.
+func (h *httpHandler) getExpiring(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/wallets/expiring/%s", network.KnownNodes[0], ctx.Param("address"))
+	if query := ctx.QueryString(); query != "" {
+		endpoint += "?" + query
+	}
 
 	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, err)
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// getBalances returns the balance of an address in every asset it holds.
+func (h *httpHandler) getBalances(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/wallets/assets/%s", network.KnownNodes[0], ctx.Param("address"))
+
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// getAsset returns the definition and registration of an asset.
+func (h *httpHandler) getAsset(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/assets/%s", network.KnownNodes[0], ctx.Param("id"))
+
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// getHolders lists every holder of an asset, for example a recalled lot.
+func (h *httpHandler) getHolders(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/assets/%s/holders", network.KnownNodes[0], ctx.Param("id"))
+
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// getTrace returns the custody chain of a transaction output, passing on the
+// query parameters, with the names of the registered organisations holding it.
+func (h *httpHandler) getTrace(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/tx/%s/trace/%s", network.KnownNodes[0], ctx.Param("id"), ctx.Param("out"))
+	if query := ctx.QueryString(); query != "" {
+		endpoint += "?" + query
+	}
+
+	return h.trace(ctx, endpoint)
+}
+
+// getLotTrace returns the custody chain of a whole lot, from its mints on to
+// its current holders, with the names of the registered organisations holding it.
+func (h *httpHandler) getLotTrace(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/assets/%s/trace", network.KnownNodes[0], ctx.Param("id"))
+
+	return h.trace(ctx, endpoint)
+}
+
+// trace fetches a trace from the node at endpoint and names the registered
+// organisations holding its outputs.
+func (h *httpHandler) trace(ctx echo.Context, endpoint string) error {
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	trace := new(types.Trace)
+
+	data, _ := json.Marshal(resp)
+	if err := json.Unmarshal(data, trace); err != nil {
+		return err
+	}
+
+	addresses := make([]string, 0, len(trace.Steps))
+	for _, step := range trace.Steps {
+		if step.Address != "" {
+			addresses = append(addresses, step.Address)
+		}
+	}
+
+	users, err := h.usrService.GetUsersByAddresses(server.ToGoContext(ctx), addresses)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string, len(users))
+	for _, user := range users {
+		names[user.WalletAddress] = user.Name
+	}
+
+	for _, step := range trace.Steps {
+		step.Organisation = names[step.Address]
+	}
+
+	return ctx.JSON(http.StatusOK, trace)
+}
+
+// getSupply returns the tokens minted by each issuer of the chain, per asset.
+func (h *httpHandler) getSupply(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/supply", network.KnownNodes[0])
+
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// getIssuerSupply returns the tokens minted by one issuer, per asset.
+func (h *httpHandler) getIssuerSupply(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/supply/%s", network.KnownNodes[0], ctx.Param("address"))
+
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// estimateFee returns the node's fee suggestions, passing on the query parameters.
+func (h *httpHandler) estimateFee(ctx echo.Context) error {
+	endpoint := fmt.Sprintf("http://%s/v1/chain/estimatefee", network.KnownNodes[0])
+	if query := ctx.QueryString(); query != "" {
+		endpoint += "?" + query
+	}
+
+	resp, err := server.SendRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
 	}
 
 	return ctx.JSON(http.StatusOK, resp)
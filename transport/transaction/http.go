@@ -20,6 +20,7 @@ func NewHTTP(v1Group *echo.Group, service types.Service, userService types.UserS
 	// transaction related handlers
 	transactionGroup := v1Group.Group("/transactions", jwtMiddleware)
 	transactionGroup.POST("/send", h.send)
+	transactionGroup.POST("/send-batch", h.sendBatch)
 	transactionGroup.GET("/:address", h.getTransactions)
 	transactionGroup.GET("/vaccines/total", h.getTotalVaccinatedCitizens)
 }
@@ -42,6 +43,24 @@ func (h *httpHandler) send(ctx echo.Context) error {
 	})
 }
 
+// sendBatch makes several payments in one transaction, all or none of them.
+func (h *httpHandler) sendBatch(ctx echo.Context) error {
+	batch := new(types.SendBatch)
+
+	if err := ctx.Bind(batch); err != nil {
+		return err
+	}
+
+	err := h.service.SendBatch(server.ToGoContext(ctx), batch)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success!",
+	})
+}
+
 func (h *httpHandler) getTransactions(c echo.Context) error {
 	resp, err := h.service.GetTransaction(server.ToGoContext(c), c.Param("address"))
 	if err != nil {
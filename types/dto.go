@@ -1,10 +1,168 @@
 package types
 
+// SendTokens pays Amount to To. Fee is left to the miner, a higher fee gets
+// the transaction mined sooner under load. Expiry, a Unix time, sets when
+// newly issued tokens expire; sent tokens expire with the tokens they spend.
+// Asset, the hex ID of a registered asset, sends units of it instead of the
+// native token; the fee is always native.
 type SendTokens struct {
-	From             string `json:"from"`
-	To               string `json:"to"`
-	Amount           int    `json:"amount"`
-	SkipBalanceCheck bool   `json:"skipBalanceCheck"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+	Fee    int    `json:"fee"`
+	Expiry int64  `json:"expiry,omitempty"`
+	Asset  string `json:"asset,omitempty"`
+}
+
+type Payment struct {
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+	Expiry int64  `json:"expiry,omitempty"`
+	Asset  string `json:"asset,omitempty"`
+}
+
+// SendBatch makes all the payments in one transaction, so that either all of
+// them or none are made.
+type SendBatch struct {
+	From     string     `json:"from"`
+	Payments []*Payment `json:"payments"`
+	Fee      int        `json:"fee"`
+}
+
+// MintTokens issues new tokens from an issuer wallet held by the node, when
+// requested with the token of the government user owning that wallet.
+// Reference identifies the issuance and may be used only once. Asset, when
+// set, registers that asset unless the issuer already has, and issues the
+// payments in it.
+type MintTokens struct {
+	Issuer    string     `json:"issuer"`
+	Reference string     `json:"reference"`
+	Payments  []*Payment `json:"payments"`
+	Asset     *Asset     `json:"asset,omitempty"`
+}
+
+// Asset is a lot of a vaccine product. ID is the hex ID of the registered
+// asset and Issuer the address that registered it.
+type Asset struct {
+	ID           string `json:"id,omitempty"`
+	Issuer       string `json:"issuer,omitempty"`
+	TxID         string `json:"txId,omitempty"`
+	Product      string `json:"product"`
+	Manufacturer string `json:"manufacturer"`
+	Lot          string `json:"lot"`
+	Frozen       bool   `json:"frozen"`
+}
+
+// FreezeAsset freezes or unfreezes an asset, signed by a regulator wallet held
+// by the node, when requested with the token of the government user owning
+// that wallet.
+type FreezeAsset struct {
+	Regulator string `json:"regulator"`
+	Asset     string `json:"asset"`
+}
+
+// AssetHolders lists every holder of an asset, for example to coordinate a
+// recall. Holders locked by a script have no Address but their Script.
+type AssetHolders struct {
+	Asset   string         `json:"asset"`
+	Frozen  bool           `json:"frozen"`
+	Total   int            `json:"total"`
+	Holders []*AssetHolder `json:"holders"`
+}
+
+type AssetHolder struct {
+	Address string `json:"address,omitempty"`
+	Script  string `json:"script,omitempty"`
+	Amount  int    `json:"amount"`
+	Outputs int    `json:"outputs"`
+}
+
+// Trace is the custody chain of an output, in chain order: back to the mints
+// or coinbases that issued it, or forward to the outputs holding its tokens
+// now. The forward trace of a whole lot has Asset set instead of TxID and
+// Out. Organisation is only set by the API server, for registered addresses.
+type Trace struct {
+	TxID      string       `json:"txId,omitempty"`
+	Out       int          `json:"out"`
+	Asset     string       `json:"asset,omitempty"`
+	Direction string       `json:"direction"`
+	Steps     []*TraceStep `json:"steps"`
+}
+
+type TraceStep struct {
+	TxID         string `json:"txId"`
+	Out          int    `json:"out"`
+	Address      string `json:"address,omitempty"`
+	Script       string `json:"script,omitempty"`
+	Organisation string `json:"organisation,omitempty"`
+	Amount       int    `json:"amount"`
+	Asset        string `json:"asset,omitempty"`
+	Height       int    `json:"height"`
+	Timestamp    int64  `json:"timestamp"`
+	Origin       bool   `json:"origin"`
+	Unspent      bool   `json:"unspent"`
+}
+
+// AssetBalance is what an address holds of one asset. Asset is empty for the
+// native token.
+type AssetBalance struct {
+	Asset   string `json:"asset"`
+	Balance int    `json:"balance"`
+}
+
+// FeeEstimate holds the fee rates, in tokens per 1000 bytes, paid in recent
+// blocks, and the fees they suggest for a transaction of TxSize bytes.
+type FeeEstimate struct {
+	Blocks       int        `json:"blocks"`
+	Transactions int        `json:"transactions"`
+	TxSize       int        `json:"txSize"`
+	Rates        *FeeLevels `json:"rates"`
+	Fees         *FeeLevels `json:"fees"`
+}
+
+// FeeLevels are the 25th, 50th and 90th percentiles of the fees paid.
+type FeeLevels struct {
+	Low    int `json:"low"`
+	Medium int `json:"medium"`
+	High   int `json:"high"`
+}
+
+// DisposeTokens spends the expired tokens of a wallet held by the node to the
+// disposal address of the chain.
+type DisposeTokens struct {
+	From string `json:"from"`
+}
+
+// ExpiringStock lists the unspent outputs of an address that expire before
+// Before, a Unix time. Expired outputs no longer count towards the balance.
+type ExpiringStock struct {
+	Address  string            `json:"address"`
+	Before   int64             `json:"before"`
+	Expired  int               `json:"expired"`
+	Expiring int               `json:"expiring"`
+	Outputs  []*ExpiringOutput `json:"outputs"`
+}
+
+type ExpiringOutput struct {
+	TxID    string `json:"txId"`
+	Index   int    `json:"index"`
+	Amount  int    `json:"amount"`
+	Expiry  int64  `json:"expiry"`
+	Expired bool   `json:"expired"`
+	Asset   string `json:"asset,omitempty"`
+}
+
+// IssuerSupply is what an issuer minted on the main chain, per asset.
+type IssuerSupply struct {
+	Issuer string         `json:"issuer"`
+	Assets []*AssetSupply `json:"assets"`
+}
+
+// AssetSupply is the number of units of one asset an issuer minted. Asset is
+// empty for the native token.
+type AssetSupply struct {
+	Asset  string `json:"asset"`
+	Supply int    `json:"supply"`
 }
 
 type Block struct {
@@ -21,3 +179,50 @@ type Blockchain struct {
 type CreateBlockchain struct {
 	Address string `json:"address"`
 }
+
+type BlockHeader struct {
+	Version    int    `json:"version"`
+	Hash       string `json:"hash"`
+	PrevHash   string `json:"prevHash"`
+	MerkleRoot string `json:"merkleRoot"`
+	Timestamp  int64  `json:"timestamp"`
+	Difficulty int    `json:"difficulty"`
+	Nonce      int    `json:"nonce"`
+	Height     int    `json:"height"`
+	Signer     string `json:"signer,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+}
+
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// TxProof proves that a transaction is included in a block. Leaf is the
+// serialized transaction, which hashes to the first node of Path.
+type TxProof struct {
+	TxID   string             `json:"txId"`
+	Index  int                `json:"index"`
+	Leaf   string             `json:"leaf"`
+	Header *BlockHeader       `json:"header"`
+	Path   []*MerkleProofStep `json:"path"`
+}
+
+// ChainReport is the result of a full chain audit. Divergence, the first
+// failed check, is omitted when the chain is valid.
+type ChainReport struct {
+	Valid        bool        `json:"valid"`
+	Tip          string      `json:"tip"`
+	Height       int         `json:"height"`
+	Blocks       int         `json:"blocks"`
+	Transactions int         `json:"transactions"`
+	LegacyBlocks int         `json:"legacyBlocks"`
+	Divergence   *Divergence `json:"divergence,omitempty"`
+}
+
+type Divergence struct {
+	Height    int    `json:"height"`
+	BlockHash string `json:"blockHash"`
+	TxID      string `json:"txId,omitempty"`
+	Reason    string `json:"reason"`
+}
@@ -14,6 +14,7 @@ type (
 	// Service represents transaction service.
 	Service interface {
 		Send(ctx context.Context, dto *SendTokens) error
+		SendBatch(ctx context.Context, dto *SendBatch) error
 		SaveTransaction(ctx context.Context, transaction *Transaction) (*Transaction, error)
 		GetTransaction(ctx context.Context, address string) ([]*Transaction, error)
 		GetTotalVaccinatedCitizens(ctx context.Context) (int, error)
@@ -31,7 +31,7 @@ func MapJwtClaimToEchoContext(c echo.Context, token *jwt.Token) echo.Context {
 		c.Set("aadhaar", aadhaar)
 	}
 
-	wallet, ok := claims["wallet"].(string)
+	wallet, ok := claims["walletAddress"].(string)
 	if ok {
 		c.Set("wallet", wallet)
 	}
@@ -33,8 +33,18 @@ func InitEcho() *echo.Echo {
 	return e
 }
 
+type contextKey string
+
+const authorizationKey contextKey = "authorization"
+
 // SendRequest sends a request to the given URL.
 func SendRequest(method string, url string, payload interface{}) (interface{}, error) {
+	return SendAuthorizedRequest(method, url, "", payload)
+}
+
+// SendAuthorizedRequest sends a request to the given URL with the given
+// Authorization header, for example the one Authorization returns.
+func SendAuthorizedRequest(method string, url string, authorization string, payload interface{}) (interface{}, error) {
 	var client http.Client
 
 	body, err := json.Marshal(payload)
@@ -50,6 +60,9 @@ func SendRequest(method string, url string, payload interface{}) (interface{}, e
 	}
 
 	request.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
 
 	response, err := client.Do(request)
 	if err != nil {
@@ -94,10 +107,27 @@ func SendRequest(method string, url string, payload interface{}) (interface{}, e
 	return respPayload, nil
 }
 
+// ToGoContext returns the context of the request, carrying its Authorization
+// header or token query parameter for Authorization.
 func ToGoContext(c echo.Context) context.Context {
 	type key string
 
 	var newKey key = "key"
 
-	return context.WithValue(c.Request().Context(), newKey, "value")
+	authorization := c.Request().Header.Get("Authorization")
+	if authorization == "" && c.QueryParam("token") != "" {
+		authorization = "Bearer " + c.QueryParam("token")
+	}
+
+	ctx := context.WithValue(c.Request().Context(), newKey, "value")
+
+	return context.WithValue(ctx, authorizationKey, authorization)
+}
+
+// Authorization returns the Authorization header of the request ctx was
+// derived from by ToGoContext, so that it can be passed on to a node.
+func Authorization(ctx context.Context) string {
+	authorization, _ := ctx.Value(authorizationKey).(string)
+
+	return authorization
 }